@@ -31,6 +31,7 @@ import (
 	"strconv"
 	"syscall"
 	"time"
+	_ "time/tzdata" // time zones of recurring schedules, the image has no zoneinfo
 
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -81,14 +82,17 @@ func main() {
 		cfg.Redis.DB,
 	)
 
-	// Initialize webhook client
-	webhookClient := client.NewWebhookClient(cfg.Webhook.URL, cfg.Webhook.AuthKey)
+	// Initialize delivery providers
+	providers, err := client.NewRegistryFromConfig(cfg.Webhook, cfg.Delivery)
+	if err != nil {
+		logger.Fatalf("Failed to initialize delivery providers: %v", err)
+	}
 
 	// Initialize repository
 	messageRepo := repository.NewMessageRepository(db)
 
 	// Initialize controller
-	messageController := controller.NewMessageController(messageRepo, webhookClient, messageCache, logger)
+	messageController := controller.NewMessageController(messageRepo, providers, messageCache, cfg.Dispatcher, logger)
 
 	// Start message processing automatically
 	if err := messageController.Start(); err != nil {
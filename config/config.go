@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -34,14 +35,184 @@ type Server struct {
 type Webhook struct {
 	URL     string
 	AuthKey string
+	// ConnectTimeout bounds establishing the TCP connection
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout"`
+	// TLSTimeout bounds the TLS handshake
+	TLSTimeout time.Duration `mapstructure:"tls_timeout"`
+	// Timeout bounds the whole request, including reading the response
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Signing enables HMAC signatures on webhook requests
+	Signing Signing `mapstructure:"signing"`
+	// TLS configures client certificates and server verification
+	TLS TLS `mapstructure:"tls"`
+}
+
+// TLS holds the TLS settings of outgoing HTTP requests. The certificate, key
+// and CA files are reloaded when they change on disk.
+type TLS struct {
+	// CertFile and KeyFile hold the PEM client certificate and key presented
+	// to servers that require mutual TLS
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// CAFile holds the PEM bundle of CAs server certificates are verified
+	// against, the system roots if empty
+	CAFile string `mapstructure:"ca_file"`
+	// MinVersion is the lowest accepted TLS version, "1.2" or "1.3"
+	MinVersion string `mapstructure:"min_version"`
+	// ServerName overrides the host name server certificates are verified for
+	ServerName string `mapstructure:"server_name"`
+}
+
+// Signing holds the key webhook requests are signed with
+type Signing struct {
+	// Secret is the HMAC-SHA256 key, requests are not signed if empty. It must
+	// be set if KeyID is.
+	Secret string `mapstructure:"secret"`
+	// KeyID names the secret in the X-Signature-Key-Id header, so that
+	// receivers can tell the old and the new key apart during a rotation
+	KeyID string `mapstructure:"key_id"`
+}
+
+// Delivery holds the named providers messages can be delivered through
+type Delivery struct {
+	// DefaultProvider is used for messages that do not name a provider
+	DefaultProvider string `mapstructure:"default_provider"`
+	// Providers maps provider names to their settings
+	Providers map[string]Provider `mapstructure:"providers"`
+	// Channels maps channel names (email, sms, push, generic) to their settings
+	Channels map[string]Channel `mapstructure:"channels"`
+	// CircuitBreaker controls when a failing provider is skipped
+	CircuitBreaker CircuitBreaker `mapstructure:"circuit_breaker"`
+}
+
+// Channel holds the delivery settings of one channel
+type Channel struct {
+	// Providers deliver messages on this channel that do not name a provider.
+	// They are tried in order, falling back to the next one while a provider
+	// fails. The default provider is used if empty.
+	Providers []string `mapstructure:"providers"`
+	// MaxLength is the maximum content length in characters, the channel's
+	// built-in limit if 0
+	MaxLength int `mapstructure:"max_length"`
+}
+
+// Provider holds the settings of one delivery provider
+type Provider struct {
+	// Type selects the implementation, "webhook", "http" or "smtp"
+	Type string `mapstructure:"type"`
+	// URL is the endpoint messages are sent to
+	URL string `mapstructure:"url"`
+	// AuthKey is sent in the x-ins-auth-key header by webhook providers
+	AuthKey string `mapstructure:"auth_key"`
+	// Method is the HTTP method used by http providers, POST by default
+	Method string `mapstructure:"method"`
+	// Headers are added to every request of http providers
+	Headers map[string]string `mapstructure:"headers"`
+	// Body is the Go template rendering the JSON request body of http providers
+	Body string `mapstructure:"body"`
+	// MessageIDField is the dot-separated path of the provider message ID in
+	// the JSON response of http providers, "message_id" by default
+	MessageIDField string `mapstructure:"message_id_field"`
+	// Host and Port address the mail server of smtp providers, port 587 by default
+	Host string `mapstructure:"host"`
+	Port int    `mapstructure:"port"`
+	// Username and Password authenticate against the mail server, no
+	// authentication is attempted if Username is empty
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// AuthMechanism is "plain" (the default) or "login"
+	AuthMechanism string `mapstructure:"auth_mechanism"`
+	// StartTLS is "required" (the default), "optional" or "disabled"
+	StartTLS string `mapstructure:"starttls"`
+	// From is the sender address of smtp providers
+	From string `mapstructure:"from"`
+	// Subject is the Go template rendering the subject line of smtp providers
+	Subject string `mapstructure:"subject"`
+	// ConnectTimeout bounds establishing the TCP connection
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout"`
+	// TLSTimeout bounds the TLS handshake
+	TLSTimeout time.Duration `mapstructure:"tls_timeout"`
+	// Timeout bounds the whole request, including reading the response
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Signing enables HMAC signatures on requests of webhook providers
+	Signing Signing `mapstructure:"signing"`
+	// TLS configures client certificates and server verification of webhook
+	// and http providers
+	TLS TLS `mapstructure:"tls"`
+}
+
+// CircuitBreaker holds circuit breaker settings
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int `mapstructure:"failure_threshold"`
+	// Cooldown is how long an open circuit rejects calls before a probe is let through
+	Cooldown time.Duration `mapstructure:"cooldown"`
+}
+
+// Dispatcher holds message dispatcher settings
+type Dispatcher struct {
+	// BatchSize is the maximum number of messages claimed at once
+	BatchSize int `mapstructure:"batch_size"`
+	// Interval is the longest time between two dispatcher runs. Messages are
+	// sent at their scheduled time; the periodic sweep recovers expired
+	// leases and picks up messages created by other replicas.
+	Interval time.Duration `mapstructure:"interval"`
+	// MaxInFlight is the maximum number of concurrent sends
+	MaxInFlight int `mapstructure:"max_in_flight"`
+	// RateLimit caps the number of sends started per second by this replica,
+	// 0 disables the cap
+	RateLimit float64 `mapstructure:"rate_limit"`
+	// FairShare is the fraction of every batch claimed by scheduled time
+	// regardless of priority, so that low priority messages are not starved
+	FairShare float64 `mapstructure:"fair_share"`
+	// ScheduleHorizon is how far ahead recurring schedules create their messages
+	ScheduleHorizon time.Duration `mapstructure:"schedule_horizon"`
+	// Quotas limit sends across all replicas
+	Quotas Quotas `mapstructure:"quotas"`
+	// InstanceID identifies this replica as the owner of claimed messages
+	InstanceID string `mapstructure:"instance_id"`
+	// LeaseDuration is how long a claimed message stays reserved for this replica
+	LeaseDuration time.Duration `mapstructure:"lease_duration"`
+	// Retry controls how failed sends are retried
+	Retry Retry `mapstructure:"retry"`
+}
+
+// Quotas hold the send limits shared by all replicas through Redis
+type Quotas struct {
+	// Global limits all sends
+	Global Quota `mapstructure:"global"`
+	// PerRecipient limits sends to a single recipient
+	PerRecipient Quota `mapstructure:"per_recipient"`
+	// PerDomain limits sends to the email addresses of a single domain
+	PerDomain Quota `mapstructure:"per_domain"`
+}
+
+// Quota is a token bucket limit
+type Quota struct {
+	// Rate is the number of sends per second, 0 disables the limit
+	Rate float64 `mapstructure:"rate"`
+	// Burst is the number of sends allowed in quick succession, 1 if 0
+	Burst int `mapstructure:"burst"`
+}
+
+// Retry holds retry settings for failed sends
+type Retry struct {
+	// MaxAttempts is the number of sends after which a message is marked failed
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// BaseDelay is the delay before the first retry, doubled on every further attempt
+	BaseDelay time.Duration `mapstructure:"base_delay"`
+	// MaxDelay caps the delay between two attempts
+	MaxDelay time.Duration `mapstructure:"max_delay"`
 }
 
 // Config holds all configuration settings
 type Config struct {
-	DB      DB
-	Server  Server
-	Webhook Webhook
-	Redis   Redis
+	DB         DB
+	Server     Server
+	Webhook    Webhook
+	Redis      Redis
+	Dispatcher Dispatcher
+	Delivery   Delivery
 }
 
 func Load() (*Config, error) {
@@ -70,6 +241,38 @@ func Load() (*Config, error) {
 	viper.BindEnv("Server.Port", "SERVER_PORT")
 	viper.BindEnv("Webhook.URL", "WEBHOOK_URL")
 	viper.BindEnv("Webhook.AuthKey", "WEBHOOK_AUTH_KEY")
+	viper.BindEnv("Webhook.connect_timeout", "WEBHOOK_CONNECT_TIMEOUT")
+	viper.BindEnv("Webhook.tls_timeout", "WEBHOOK_TLS_TIMEOUT")
+	viper.BindEnv("Webhook.timeout", "WEBHOOK_TIMEOUT")
+	viper.BindEnv("Webhook.signing.secret", "WEBHOOK_SIGNING_SECRET")
+	viper.BindEnv("Webhook.signing.key_id", "WEBHOOK_SIGNING_KEY_ID")
+	viper.BindEnv("Webhook.tls.cert_file", "WEBHOOK_TLS_CERT_FILE")
+	viper.BindEnv("Webhook.tls.key_file", "WEBHOOK_TLS_KEY_FILE")
+	viper.BindEnv("Webhook.tls.ca_file", "WEBHOOK_TLS_CA_FILE")
+	viper.BindEnv("Webhook.tls.min_version", "WEBHOOK_TLS_MIN_VERSION")
+	viper.BindEnv("Webhook.tls.server_name", "WEBHOOK_TLS_SERVER_NAME")
+
+	viper.BindEnv("Delivery.default_provider", "DELIVERY_DEFAULT_PROVIDER")
+	viper.BindEnv("Delivery.circuit_breaker.failure_threshold", "DELIVERY_CIRCUIT_BREAKER_FAILURE_THRESHOLD")
+	viper.BindEnv("Delivery.circuit_breaker.cooldown", "DELIVERY_CIRCUIT_BREAKER_COOLDOWN")
+
+	viper.BindEnv("Dispatcher.batch_size", "DISPATCHER_BATCH_SIZE")
+	viper.BindEnv("Dispatcher.interval", "DISPATCHER_INTERVAL")
+	viper.BindEnv("Dispatcher.max_in_flight", "DISPATCHER_MAX_IN_FLIGHT")
+	viper.BindEnv("Dispatcher.rate_limit", "DISPATCHER_RATE_LIMIT")
+	viper.BindEnv("Dispatcher.fair_share", "DISPATCHER_FAIR_SHARE")
+	viper.BindEnv("Dispatcher.schedule_horizon", "DISPATCHER_SCHEDULE_HORIZON")
+	viper.BindEnv("Dispatcher.quotas.global.rate", "DISPATCHER_QUOTAS_GLOBAL_RATE")
+	viper.BindEnv("Dispatcher.quotas.global.burst", "DISPATCHER_QUOTAS_GLOBAL_BURST")
+	viper.BindEnv("Dispatcher.quotas.per_recipient.rate", "DISPATCHER_QUOTAS_PER_RECIPIENT_RATE")
+	viper.BindEnv("Dispatcher.quotas.per_recipient.burst", "DISPATCHER_QUOTAS_PER_RECIPIENT_BURST")
+	viper.BindEnv("Dispatcher.quotas.per_domain.rate", "DISPATCHER_QUOTAS_PER_DOMAIN_RATE")
+	viper.BindEnv("Dispatcher.quotas.per_domain.burst", "DISPATCHER_QUOTAS_PER_DOMAIN_BURST")
+	viper.BindEnv("Dispatcher.instance_id", "DISPATCHER_INSTANCE_ID")
+	viper.BindEnv("Dispatcher.lease_duration", "DISPATCHER_LEASE_DURATION")
+	viper.BindEnv("Dispatcher.retry.max_attempts", "DISPATCHER_RETRY_MAX_ATTEMPTS")
+	viper.BindEnv("Dispatcher.retry.base_delay", "DISPATCHER_RETRY_BASE_DELAY")
+	viper.BindEnv("Dispatcher.retry.max_delay", "DISPATCHER_RETRY_MAX_DELAY")
 
 	// Set defaults
 	viper.SetDefault("DB.Host", "localhost")
@@ -84,6 +287,25 @@ func Load() (*Config, error) {
 
 	viper.SetDefault("Server.Port", 8080)
 
+	viper.SetDefault("Webhook.connect_timeout", 5*time.Second)
+	viper.SetDefault("Webhook.tls_timeout", 5*time.Second)
+	viper.SetDefault("Webhook.timeout", 30*time.Second)
+
+	viper.SetDefault("Delivery.circuit_breaker.failure_threshold", 5)
+	viper.SetDefault("Delivery.circuit_breaker.cooldown", 30*time.Second)
+
+	viper.SetDefault("Dispatcher.batch_size", 2)
+	viper.SetDefault("Dispatcher.interval", 2*time.Minute)
+	viper.SetDefault("Dispatcher.max_in_flight", 1)
+	viper.SetDefault("Dispatcher.rate_limit", 0)
+	viper.SetDefault("Dispatcher.fair_share", 0.2)
+	viper.SetDefault("Dispatcher.schedule_horizon", 24*time.Hour)
+	viper.SetDefault("Dispatcher.instance_id", defaultInstanceID())
+	viper.SetDefault("Dispatcher.lease_duration", 5*time.Minute)
+	viper.SetDefault("Dispatcher.retry.max_attempts", 5)
+	viper.SetDefault("Dispatcher.retry.base_delay", 30*time.Second)
+	viper.SetDefault("Dispatcher.retry.max_delay", time.Hour)
+
 	// Try to read config file
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
@@ -108,6 +330,15 @@ func Load() (*Config, error) {
 	return &config, nil
 }
 
+// defaultInstanceID derives a replica identifier from the host name and process ID
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -143,6 +143,12 @@ const docTemplate = `{
                 ],
                 "summary": "Create a new message",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key that makes retries of this request return the original response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
                     {
                         "description": "Message details",
                         "name": "message",
@@ -166,6 +172,130 @@ const docTemplate = `{
                             "$ref": "#/definitions/controller.ErrorResponse"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/dead-letter": {
+            "get": {
+                "description": "Get permanently failed messages with their failure reason and attempt history",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dead-letter"
+                ],
+                "summary": "List dead-letter messages",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Recipient",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Substring of the last error",
+                        "name": "error_contains",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages that failed at or after this time (RFC 3339)",
+                        "name": "failed_after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages that failed before this time (RFC 3339)",
+                        "name": "failed_before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of messages (default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Message"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/dead-letter/requeue": {
+            "post": {
+                "description": "Move all failed messages matching the filters back to pending and reset their retry state. Expiries that have passed are dropped.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dead-letter"
+                ],
+                "summary": "Requeue dead-letter messages in bulk",
+                "parameters": [
+                    {
+                        "description": "Filters, omit to requeue every failed message",
+                        "name": "filter",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/controller.DeadLetterFilterRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.RequeueResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -264,6 +394,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/controller.ErrorResponse"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -305,25 +441,55 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/controller.ErrorResponse"
                         }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/messaging/start": {
-            "post": {
-                "description": "Start processing pending messages",
+        "/messages/{id}/events": {
+            "get": {
+                "description": "Get every status change of a message, oldest first, with who made it and the error if any",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "messaging"
+                    "messages"
+                ],
+                "summary": "Get message status history",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Message ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Start message processing",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controller.MessageResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.MessageEvent"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
                         }
                     },
                     "500": {
@@ -335,21 +501,48 @@ const docTemplate = `{
                 }
             }
         },
-        "/messaging/stop": {
+        "/messages/{id}/requeue": {
             "post": {
-                "description": "Stop processing messages",
+                "description": "Move a failed message back to pending and reset its retry state. An expiry that has passed is dropped.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "messaging"
+                    "dead-letter"
+                ],
+                "summary": "Requeue a dead-letter message",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Message ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Stop message processing",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/controller.MessageResponse"
+                            "$ref": "#/definitions/model.Message"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
                         }
                     },
                     "500": {
@@ -360,68 +553,1210 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "controller.CreateMessageRequest": {
-            "type": "object",
-            "required": [
-                "content",
-                "scheduled_time",
-                "to"
-            ],
-            "properties": {
-                "content": {
-                    "type": "string"
-                },
-                "scheduled_time": {
-                    "type": "string"
-                },
-                "to": {
-                    "type": "string"
-                }
-            }
         },
-        "controller.ErrorResponse": {
-            "type": "object",
-            "properties": {
-                "error": {
-                    "type": "string"
+        "/messaging/sent/{messageId}": {
+            "get": {
+                "description": "Look up the sent time of a message by the ID the provider assigned to it. Answers from the Redis cache and falls back to the database.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messaging"
+                ],
+                "summary": "Get when a message was sent",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider message ID",
+                        "name": "messageId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.SentMessageResponse"
+                        },
+                        "headers": {
+                            "X-Cache": {
+                                "type": "string",
+                                "description": "HIT or MISS"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "controller.MessageResponse": {
-            "type": "object",
-            "properties": {
-                "message": {
-                    "type": "string"
+        "/messaging/settings": {
+            "get": {
+                "description": "Get the current dispatcher throughput settings",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messaging"
+                ],
+                "summary": "Get dispatcher settings",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.SettingsResponse"
+                        }
+                    }
                 }
-            }
-        },
-        "model.Message": {
-            "type": "object",
-            "properties": {
-                "content": {
-                    "type": "string"
-                },
-                "created_at": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "integer"
-                },
-                "scheduled_time": {
-                    "type": "string"
-                },
-                "sent_at": {
-                    "type": "string"
-                },
-                "status": {
+            },
+            "put": {
+                "description": "Change batch size, sweep interval, max in-flight sends and rate cap of the running dispatcher",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messaging"
+                ],
+                "summary": "Update dispatcher settings",
+                "parameters": [
+                    {
+                        "description": "Dispatcher settings",
+                        "name": "settings",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controller.UpdateSettingsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.SettingsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messaging/start": {
+            "post": {
+                "description": "Start processing pending messages",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messaging"
+                ],
+                "summary": "Start message processing",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.MessageResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messaging/stats": {
+            "get": {
+                "description": "Get runtime statistics such as hit and miss counts of the sent-message cache and the number of messages in every status",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messaging"
+                ],
+                "summary": "Get service statistics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.StatsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messaging/status": {
+            "get": {
+                "description": "Get whether the dispatcher is running or paused, and the circuit breaker state of every provider",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messaging"
+                ],
+                "summary": "Get dispatcher status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.StatusResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messaging/stop": {
+            "post": {
+                "description": "Stop processing messages",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messaging"
+                ],
+                "summary": "Stop message processing",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.MessageResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/recipients": {
+            "get": {
+                "description": "Get a list of all recipients with a stored time zone",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "recipients"
+                ],
+                "summary": "Get all recipients",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Recipient"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Create a recipient or change its time zone. Pending messages scheduled at a local time in the recipient's time zone are moved to that local time in the new zone.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "recipients"
+                ],
+                "summary": "Set the time zone of a recipient",
+                "parameters": [
+                    {
+                        "description": "Recipient details",
+                        "name": "recipient",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controller.RecipientRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.RecipientResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/recipients/{id}": {
+            "delete": {
+                "description": "Delete the stored time zone of a recipient. Pending messages keep the time they are scheduled at.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "recipients"
+                ],
+                "summary": "Delete a recipient",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Recipient ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedules": {
+            "get": {
+                "description": "Get a list of all recurring schedules",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedules"
+                ],
+                "summary": "Get all recurring schedules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.RecurringSchedule"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a schedule that sends a message on every occurrence of a cron expression or iCalendar RRULE, read in its timezone",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedules"
+                ],
+                "summary": "Create a recurring schedule",
+                "parameters": [
+                    {
+                        "description": "Schedule details",
+                        "name": "schedule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controller.ScheduleRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.RecurringSchedule"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedules/{id}": {
+            "get": {
+                "description": "Get a recurring schedule by its ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedules"
+                ],
+                "summary": "Get a recurring schedule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Schedule ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.RecurringSchedule"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Replace the message and recurrence of a schedule. Pending messages it created are cancelled and created anew; a paused schedule stays paused.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedules"
+                ],
+                "summary": "Replace a recurring schedule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Schedule ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Schedule details",
+                        "name": "schedule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controller.ScheduleRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.RecurringSchedule"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a schedule and cancel the pending messages it created",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedules"
+                ],
+                "summary": "Delete a recurring schedule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Schedule ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedules/{id}/pause": {
+            "post": {
+                "description": "Stop creating messages for a schedule and cancel the pending messages it created",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedules"
+                ],
+                "summary": "Pause a recurring schedule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Schedule ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.RecurringSchedule"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedules/{id}/resume": {
+            "post": {
+                "description": "Continue a paused schedule from its next occurrence; occurrences missed while it was paused are not sent",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedules"
+                ],
+                "summary": "Resume a recurring schedule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Schedule ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.RecurringSchedule"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "client.BreakerStatus": {
+            "type": "object",
+            "properties": {
+                "consecutive_failures": {
+                    "type": "integer"
+                },
+                "next_probe_at": {
+                    "type": "string"
+                },
+                "opened_at": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string",
+                    "example": "open"
+                },
+                "total_failures": {
+                    "type": "integer"
+                }
+            }
+        },
+        "controller.CacheStats": {
+            "type": "object",
+            "properties": {
+                "hits": {
+                    "type": "integer"
+                },
+                "misses": {
+                    "type": "integer"
+                }
+            }
+        },
+        "controller.CreateMessageRequest": {
+            "type": "object",
+            "required": [
+                "content",
+                "to"
+            ],
+            "properties": {
+                "channel": {
+                    "description": "Channel is one of email, sms, push or generic, email if empty",
+                    "type": "string",
+                    "enum": [
+                        "email",
+                        "sms",
+                        "push",
+                        "generic"
+                    ],
+                    "example": "email"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt is when the message stops being worth sending, it never expires if empty",
+                    "type": "string",
+                    "example": "2024-04-26T12:00:00Z"
+                },
+                "local_time": {
+                    "description": "LocalTime is when to send the message as wall clock time without UTC\noffset, read in Timezone or, if that is empty, in the recipient's time\nzone. Messages in the recipient's time zone follow it when it changes.",
+                    "type": "string",
+                    "example": "2024-04-26T09:00:00"
+                },
+                "priority": {
+                    "description": "Priority is one of critical, high, normal or low, normal if empty",
+                    "type": "string",
+                    "enum": [
+                        "critical",
+                        "high",
+                        "normal",
+                        "low"
+                    ],
+                    "example": "high"
+                },
+                "provider": {
+                    "description": "Provider names the delivery provider, the channel's provider if empty",
+                    "type": "string",
+                    "example": "webhook"
+                },
+                "scheduled_at": {
+                    "description": "ScheduledAt is when to send the message, exclusive with LocalTime",
+                    "type": "string"
+                },
+                "timezone": {
+                    "description": "Timezone is the IANA time zone of LocalTime",
+                    "type": "string",
+                    "example": "America/New_York"
+                },
+                "to": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "description": "TTL sets ExpiresAt relative to ScheduledAt, as a duration such as 6h",
+                    "type": "string",
+                    "example": "2h"
+                }
+            }
+        },
+        "controller.DeadLetterFilterRequest": {
+            "type": "object",
+            "properties": {
+                "error_contains": {
+                    "type": "string"
+                },
+                "failed_after": {
+                    "type": "string"
+                },
+                "failed_before": {
+                    "type": "string"
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.MessageResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.RecipientRequest": {
+            "type": "object",
+            "required": [
+                "timezone",
+                "to"
+            ],
+            "properties": {
+                "channel": {
+                    "description": "Channel is one of email, sms, push or generic, email if empty",
+                    "type": "string",
+                    "enum": [
+                        "email",
+                        "sms",
+                        "push",
+                        "generic"
+                    ],
+                    "example": "email"
+                },
+                "timezone": {
+                    "description": "Timezone is the IANA time zone the recipient lives in",
+                    "type": "string",
+                    "example": "America/New_York"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.RecipientResponse": {
+            "type": "object",
+            "properties": {
+                "recipient": {
+                    "$ref": "#/definitions/model.Recipient"
+                },
+                "rescheduled": {
+                    "description": "Rescheduled counts the pending messages moved to the new time zone",
+                    "type": "integer"
+                }
+            }
+        },
+        "controller.RequeueResponse": {
+            "type": "object",
+            "properties": {
+                "requeued": {
+                    "type": "integer"
+                }
+            }
+        },
+        "controller.ScheduleRequest": {
+            "type": "object",
+            "required": [
+                "content",
+                "to"
+            ],
+            "properties": {
+                "channel": {
+                    "description": "Channel is one of email, sms, push or generic, email if empty",
+                    "type": "string",
+                    "enum": [
+                        "email",
+                        "sms",
+                        "push",
+                        "generic"
+                    ],
+                    "example": "email"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "cron": {
+                    "description": "Cron is a five field cron expression, exclusive with RRule",
+                    "type": "string",
+                    "example": "0 9 * * MON-FRI"
+                },
+                "end_at": {
+                    "description": "EndAt is when the schedule ends, never if empty",
+                    "type": "string"
+                },
+                "max_occurrences": {
+                    "description": "MaxOccurrences limits the number of messages created, 0 for no limit",
+                    "type": "integer",
+                    "example": 10
+                },
+                "priority": {
+                    "description": "Priority is one of critical, high, normal or low, normal if empty",
+                    "type": "string",
+                    "enum": [
+                        "critical",
+                        "high",
+                        "normal",
+                        "low"
+                    ],
+                    "example": "normal"
+                },
+                "provider": {
+                    "description": "Provider names the delivery provider, the channel's provider if empty",
+                    "type": "string",
+                    "example": "webhook"
+                },
+                "rrule": {
+                    "description": "RRule is an iCalendar recurrence rule, exclusive with Cron",
+                    "type": "string",
+                    "example": "FREQ=WEEKLY;BYDAY=MO,WE,FR;BYHOUR=9;BYMINUTE=0"
+                },
+                "start_at": {
+                    "description": "StartAt is when the schedule begins, now if empty",
+                    "type": "string"
+                },
+                "timezone": {
+                    "description": "Timezone is the IANA time zone the schedule is read in, UTC if empty",
+                    "type": "string",
+                    "example": "Europe/Berlin"
+                },
+                "to": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "description": "TTL is how long after its occurrence each message expires, never if empty",
+                    "type": "string",
+                    "example": "2h"
+                }
+            }
+        },
+        "controller.SentMessageResponse": {
+            "type": "object",
+            "properties": {
+                "message_id": {
+                    "type": "string"
+                },
+                "sent_at": {
+                    "type": "string"
+                },
+                "source": {
+                    "description": "Source is \"cache\" or \"database\" depending on where the answer came from",
+                    "type": "string"
+                }
+            }
+        },
+        "controller.SettingsResponse": {
+            "type": "object",
+            "properties": {
+                "batch_size": {
+                    "type": "integer"
+                },
+                "interval": {
+                    "type": "string"
+                },
+                "max_in_flight": {
+                    "type": "integer"
+                },
+                "rate_limit": {
+                    "type": "number"
+                }
+            }
+        },
+        "controller.StatsResponse": {
+            "type": "object",
+            "properties": {
+                "messages": {
+                    "description": "Messages counts the messages in every status, such as expired",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "sent_lookup_cache": {
+                    "$ref": "#/definitions/controller.CacheStats"
+                }
+            }
+        },
+        "controller.StatusResponse": {
+            "type": "object",
+            "properties": {
+                "paused": {
+                    "description": "Paused is true while the circuit of every provider is open, in which\ncase no messages are dispatched until ResumesAt",
+                    "type": "boolean"
+                },
+                "providers": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/client.BreakerStatus"
+                    }
+                },
+                "resumes_at": {
+                    "type": "string"
+                },
+                "running": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controller.UpdateSettingsRequest": {
+            "type": "object",
+            "properties": {
+                "batch_size": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "interval": {
+                    "type": "string",
+                    "example": "30s"
+                },
+                "max_in_flight": {
+                    "type": "integer",
+                    "example": 4
+                },
+                "rate_limit": {
+                    "type": "number",
+                    "example": 5
+                }
+            }
+        },
+        "model.Message": {
+            "type": "object",
+            "properties": {
+                "attempt_count": {
+                    "type": "integer"
+                },
+                "attempts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.MessageAttempt"
+                    }
+                },
+                "channel": {
+                    "type": "string"
+                },
+                "claimed_by": {
+                    "type": "string"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "delivered_by": {
+                    "type": "string"
+                },
+                "delivery_key": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "lease_expires_at": {
+                    "type": "string"
+                },
+                "local_time": {
+                    "description": "LocalTime and Timezone record a send time given as wall clock time,\nRecipientTimezone that the zone is the recipient's and is followed\nwhen it changes",
+                    "type": "string"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "next_attempt_at": {
+                    "type": "string"
+                },
+                "priority": {
+                    "type": "string"
+                },
+                "provider": {
+                    "type": "string"
+                },
+                "recipient_timezone": {
+                    "type": "boolean"
+                },
+                "schedule_id": {
+                    "type": "integer"
+                },
+                "scheduled_at": {
+                    "type": "string"
+                },
+                "sent_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "timezone": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.MessageAttempt": {
+            "type": "object",
+            "properties": {
+                "attempt": {
+                    "type": "integer"
+                },
+                "attempted_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "message_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.MessageEvent": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "actor_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "from_status": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "message_id": {
+                    "type": "integer"
+                },
+                "to_status": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.Recipient": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "timezone": {
+                    "description": "Timezone is the IANA time zone the recipient lives in",
+                    "type": "string"
+                },
+                "to": {
+                    "description": "To is the normalized address, lower case for email",
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RecurringSchedule": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string"
+                },
+                "content": {
+                    "description": "The message created for every occurrence",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "cron": {
+                    "description": "Exactly one of Cron and RRule is set",
+                    "type": "string"
+                },
+                "end_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "max_occurrences": {
+                    "type": "integer"
+                },
+                "next_run_at": {
+                    "description": "NextRunAt is the next occurrence that has no message yet",
+                    "type": "string"
+                },
+                "occurrences": {
+                    "description": "Occurrences counts the messages created so far",
+                    "type": "integer"
+                },
+                "priority": {
+                    "type": "string"
+                },
+                "provider": {
+                    "type": "string"
+                },
+                "rrule": {
+                    "type": "string"
+                },
+                "start_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "timezone": {
                     "type": "string"
                 },
                 "to": {
                     "type": "string"
                 },
+                "ttl": {
+                    "type": "string"
+                },
                 "updated_at": {
                     "type": "string"
                 }
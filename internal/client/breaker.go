@@ -0,0 +1,145 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// BreakerStatus is a snapshot of a circuit breaker
+type BreakerStatus struct {
+	State               string     `json:"state" example:"open"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	TotalFailures       int64      `json:"total_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	NextProbeAt         *time.Time `json:"next_probe_at,omitempty"`
+}
+
+// Breaker is a circuit breaker. It opens after threshold consecutive
+// failures and rejects calls until cooldown has passed. Then a single probe
+// is let through: if it succeeds the breaker closes, otherwise it opens again.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state     string
+	failures  int
+	total     int64
+	openedAt  time.Time
+	nextProbe time.Time
+	probing   bool
+}
+
+// NewBreaker creates a closed circuit breaker. Zero values fall back to the
+// defaults of 5 failures and a 30s cooldown.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow reports whether a call may go ahead. While half-open only the
+// first caller is allowed through as the probe.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Before(b.nextProbe) {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a successful call and closes the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records a failed call, opening the breaker once the threshold is
+// reached or when a probe fails
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.total++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		now := b.now()
+		if b.state == BreakerClosed {
+			b.openedAt = now
+		}
+		b.state = BreakerOpen
+		b.nextProbe = now.Add(b.cooldown)
+		b.probing = false
+	}
+}
+
+// Cancel gives up a call whose outcome is unknown, such as one interrupted
+// by shutdown, so that the next call can probe instead
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Status returns a snapshot of the breaker
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		TotalFailures:       b.total,
+	}
+	// An open breaker whose cooldown has passed admits the next call as a probe
+	if b.state == BreakerOpen && !b.now().Before(b.nextProbe) {
+		status.State = BreakerHalfOpen
+	}
+	if b.state != BreakerClosed {
+		openedAt, nextProbe := b.openedAt, b.nextProbe
+		status.OpenedAt = &openedAt
+		status.NextProbeAt = &nextProbe
+	}
+	return status
+}
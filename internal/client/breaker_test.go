@@ -0,0 +1,72 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	if !breaker.Allow() || breaker.Status().State != BreakerClosed {
+		t.Fatalf("Expected closed breaker below the threshold, got %+v", breaker.Status())
+	}
+
+	breaker.Failure()
+	status := breaker.Status()
+	if breaker.Allow() || status.State != BreakerOpen || status.ConsecutiveFailures != 2 {
+		t.Fatalf("Expected open breaker at the threshold, got %+v", status)
+	}
+	if status.NextProbeAt == nil || !status.NextProbeAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected next probe after the cooldown, got %v", status.NextProbeAt)
+	}
+
+	// After the cooldown a single probe is let through
+	now = now.Add(time.Minute)
+	if breaker.Status().State != BreakerHalfOpen {
+		t.Errorf("Expected half-open breaker after the cooldown, got %s", breaker.Status().State)
+	}
+	if !breaker.Allow() {
+		t.Fatal("Expected probe to be allowed")
+	}
+	if breaker.Allow() {
+		t.Error("Expected only one probe at a time")
+	}
+
+	// A failed probe reopens the breaker for another cooldown
+	breaker.Failure()
+	if breaker.Allow() || breaker.Status().State != BreakerOpen {
+		t.Fatalf("Expected breaker to reopen after a failed probe, got %+v", breaker.Status())
+	}
+
+	now = now.Add(time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("Expected probe to be allowed")
+	}
+	breaker.Success()
+	status = breaker.Status()
+	if !breaker.Allow() || status.State != BreakerClosed || status.ConsecutiveFailures != 0 || status.TotalFailures != 3 {
+		t.Errorf("Expected closed breaker after a successful probe, got %+v", status)
+	}
+}
+
+func TestBreaker_Cancel(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	now = now.Add(time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("Expected probe to be allowed")
+	}
+
+	// An interrupted probe frees the slot for the next caller
+	breaker.Cancel()
+	if !breaker.Allow() {
+		t.Error("Expected a new probe after cancelling the previous one")
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxErrorBodySize caps how much of a failed response is kept
+	maxErrorBodySize = 1024
+)
+
+// SendError describes a failed delivery attempt
+type SendError struct {
+	// StatusCode is the HTTP status returned by the provider, 0 if no response was received
+	StatusCode int
+	// Body is the start of the provider's response body
+	Body string
+	// RetryAfter is the delay the provider asked for, 0 if it did not ask for one
+	RetryAfter time.Duration
+	// Retryable reports whether sending the same message again may succeed
+	Retryable bool
+	// Err is the underlying transport error, if any
+	Err error
+}
+
+func (e *SendError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("delivery failed: %v", e.Err)
+	}
+	msg := fmt.Sprintf("unexpected response status: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent reports whether err is a delivery failure that will not go away
+// by retrying, such as a rejected payload, invalid credentials or a message
+// addressed to a provider that does not exist
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrUnknownProvider) {
+		return true
+	}
+	var sendErr *SendError
+	return errors.As(err, &sendErr) && !sendErr.Retryable
+}
+
+// RetryAfter returns the delay the provider asked for before the next attempt,
+// or 0 if err carries none
+func RetryAfter(err error) time.Duration {
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.RetryAfter
+	}
+	return 0
+}
+
+// newTransportError wraps an error raised before a response was received.
+// Timeouts, refused or reset connections and other network failures are
+// all considered transient.
+func newTransportError(err error) *SendError {
+	return &SendError{Retryable: true, Err: err}
+}
+
+// newStatusError builds the error for a non-2xx response. Server errors,
+// 408 and 429 are retryable, every other client error is permanent.
+func newStatusError(resp *http.Response) *SendError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+
+	err := &SendError{
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(body)),
+	}
+	switch {
+	case resp.StatusCode >= 500,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests:
+		err.Retryable = true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
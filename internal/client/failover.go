@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"auto-messaging/internal/model"
+)
+
+var (
+	ErrNoProviderAvailable = errors.New("no provider available, all circuits are open")
+)
+
+// Route returns the providers to try for a message, in order. A message that
+// names a provider is pinned to it, otherwise the providers of its channel
+// are used, or the default provider if the channel has none.
+func (r *Registry) Route(channel, provider string) []string {
+	if provider != "" {
+		return []string{provider}
+	}
+	if channel == "" {
+		channel = model.ChannelEmail
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if providers := r.channels[channel].Providers; len(providers) > 0 {
+		return append([]string(nil), providers...)
+	}
+	return []string{r.defaultName}
+}
+
+// Send delivers req through the first healthy provider in names and returns
+// the name of the provider that accepted it. Providers whose circuit is open
+// are skipped and a retryable failure moves on to the next provider. A
+// permanent failure is returned right away, since it concerns the message
+// rather than the provider.
+func (r *Registry) Send(ctx context.Context, names []string, req *model.WebhookRequest) (*model.WebhookResponse, string, error) {
+	var lastErr error
+	var nextProbe time.Time
+	for _, name := range names {
+		provider, err := r.Get(name)
+		if err != nil {
+			return nil, "", err
+		}
+		breaker := r.Breaker(name)
+
+		if !breaker.Allow() {
+			if probe := breaker.Status().NextProbeAt; probe != nil && (nextProbe.IsZero() || probe.Before(nextProbe)) {
+				nextProbe = *probe
+			}
+			continue
+		}
+
+		resp, err := provider.SendMessage(ctx, req)
+		switch {
+		case err == nil:
+			breaker.Success()
+			return resp, name, nil
+		case ctx.Err() != nil:
+			breaker.Cancel()
+			return nil, "", err
+		case IsPermanent(err):
+			// The provider answered, it is healthy
+			breaker.Success()
+			return nil, "", err
+		}
+
+		breaker.Failure()
+		lastErr = fmt.Errorf("provider %s: %w", name, err)
+		log.Printf("Provider %s failed, trying the next one: %v", name, err)
+	}
+
+	if lastErr != nil {
+		return nil, "", lastErr
+	}
+	// Every circuit was open, try again once the first one admits a probe
+	sendErr := &SendError{Retryable: true, Err: ErrNoProviderAvailable}
+	if !nextProbe.IsZero() {
+		sendErr.RetryAfter = time.Until(nextProbe)
+	}
+	return nil, "", sendErr
+}
+
+// Unavailable reports whether the circuit of every provider is open, in which
+// case nothing can be delivered until the returned time, when the first of
+// them admits a probe
+func (r *Registry) Unavailable() (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var until time.Time
+	for _, breaker := range r.breakers {
+		status := breaker.Status()
+		if status.State != BreakerOpen {
+			return time.Time{}, false
+		}
+		if until.IsZero() || status.NextProbeAt.Before(until) {
+			until = *status.NextProbeAt
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// NextProbe returns the earliest time an open circuit admits a probe, or the
+// zero time if no circuit is open
+func (r *Registry) NextProbe() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var next time.Time
+	for _, breaker := range r.breakers {
+		status := breaker.Status()
+		if status.State != BreakerOpen {
+			continue
+		}
+		if next.IsZero() || status.NextProbeAt.Before(next) {
+			next = *status.NextProbeAt
+		}
+	}
+	return next
+}
+
+// Breaker returns the circuit breaker of the provider called name
+func (r *Registry) Breaker(name string) *Breaker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.breakers[name]
+}
+
+// Breakers returns a snapshot of the circuit breaker of every provider
+func (r *Registry) Breakers() map[string]BreakerStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make(map[string]BreakerStatus, len(r.breakers))
+	for name, breaker := range r.breakers {
+		statuses[name] = breaker.Status()
+	}
+	return statuses
+}
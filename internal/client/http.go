@@ -0,0 +1,170 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"auto-messaging/config"
+	"auto-messaging/internal/model"
+)
+
+const (
+	defaultBodyTemplate   = `{"to": {{json .To}}, "content": {{json .Content}}}`
+	defaultMessageIDField = "message_id"
+)
+
+var (
+	ErrInvalidBody = errors.New("rendered request body is not valid JSON")
+)
+
+// templateFuncs are available in body templates. json encodes a value as a
+// JSON literal, so that message content is always escaped correctly.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// httpProvider sends messages with a request built from configuration
+type httpProvider struct {
+	url            string
+	method         string
+	headers        map[string]string
+	body           *template.Template
+	messageIDField []string
+	client         *http.Client
+}
+
+// NewHTTPProvider creates a provider that sends every message as an HTTP
+// request to cfg.URL. The JSON body is rendered from the cfg.Body template,
+// which is executed with the model.WebhookRequest of the message.
+func NewHTTPProvider(cfg config.Provider) (Provider, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+
+	method := strings.ToUpper(cfg.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	bodyTemplate := cfg.Body
+	if bodyTemplate == "" {
+		bodyTemplate = defaultBodyTemplate
+	}
+	body, err := template.New("body").Funcs(templateFuncs).Option("missingkey=error").Parse(bodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	messageIDField := cfg.MessageIDField
+	if messageIDField == "" {
+		messageIDField = defaultMessageIDField
+	}
+
+	httpClient, err := newHTTPClient(cfg.ConnectTimeout, cfg.TLSTimeout, cfg.Timeout, cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpProvider{
+		url:            cfg.URL,
+		method:         method,
+		headers:        cfg.Headers,
+		body:           body,
+		messageIDField: strings.Split(messageIDField, "."),
+		client:         httpClient,
+	}, nil
+}
+
+// SendMessage renders the request for req and sends it. A body that cannot
+// be rendered fails the same way on every attempt and every provider, so it
+// is a permanent error.
+func (p *httpProvider) SendMessage(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+	var body bytes.Buffer
+	if err := p.body.Execute(&body, req); err != nil {
+		return nil, &SendError{Err: fmt.Errorf("failed to render request body: %w", err)}
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, &SendError{Err: ErrInvalidBody}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, p.method, p.url, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", contentType)
+	for key, value := range p.headers {
+		httpReq.Header.Set(key, value)
+	}
+	if req.IdempotencyKey != "" {
+		httpReq.Header.Set(idempotencyHeaderKey, req.IdempotencyKey)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		log.Printf("Provider request to %s failed: %v", p.url, err)
+		return nil, newTransportError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newStatusError(resp)
+	}
+
+	// The provider accepted the message, sending it again would duplicate it
+	messageID, err := p.readMessageID(resp.Body)
+	if err != nil {
+		log.Printf("Provider %s accepted the message but its response could not be read: %v", p.url, err)
+	}
+	return &model.WebhookResponse{
+		Message:   resp.Status,
+		MessageID: messageID,
+	}, nil
+}
+
+// readMessageID extracts the provider message ID from a JSON response.
+// An empty body or a missing field yield an empty ID.
+func (p *httpProvider) readMessageID(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "", nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", fmt.Errorf("failed to decode provider response: %w", err)
+	}
+
+	for _, key := range p.messageIDField {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", nil
+		}
+		value = object[key]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		return "", nil
+	}
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"auto-messaging/config"
+	"auto-messaging/internal/model"
+)
+
+const (
+	// ProviderTypeWebhook sends messages with the original webhook protocol
+	ProviderTypeWebhook = "webhook"
+	// ProviderTypeHTTP sends messages with a templated HTTP request
+	ProviderTypeHTTP = "http"
+	// ProviderTypeSMTP sends messages as email through a mail server
+	ProviderTypeSMTP = "smtp"
+
+	// DefaultProviderName is the name under which the legacy webhook settings are registered
+	DefaultProviderName = "webhook"
+)
+
+var (
+	ErrUnknownProvider     = errors.New("unknown provider")
+	ErrUnknownProviderType = errors.New("unknown provider type")
+)
+
+// Provider delivers messages through an external service
+type Provider interface {
+	SendMessage(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error)
+}
+
+// Registry holds the configured providers by name and routes channels to them
+type Registry struct {
+	mu          sync.RWMutex
+	providers   map[string]Provider
+	breakers    map[string]*Breaker
+	channels    map[string]config.Channel
+	defaultName string
+	breakerCfg  config.CircuitBreaker
+}
+
+// NewRegistry creates an empty registry. Messages that do not name a
+// provider are sent through defaultName.
+func NewRegistry(defaultName string) *Registry {
+	return &Registry{
+		providers:   make(map[string]Provider),
+		breakers:    make(map[string]*Breaker),
+		channels:    make(map[string]config.Channel),
+		defaultName: defaultName,
+	}
+}
+
+// NewRegistryFromConfig builds a provider for every configured entry. The
+// legacy webhook settings are registered as "webhook" unless a provider of
+// that name is configured explicitly.
+func NewRegistryFromConfig(webhook config.Webhook, delivery config.Delivery) (*Registry, error) {
+	defaultName := delivery.DefaultProvider
+	if defaultName == "" {
+		defaultName = DefaultProviderName
+	}
+
+	registry := NewRegistry(defaultName)
+	registry.SetCircuitBreaker(delivery.CircuitBreaker)
+	if _, ok := delivery.Providers[DefaultProviderName]; !ok && webhook.URL != "" {
+		provider, err := NewWebhookClient(webhook)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", DefaultProviderName, err)
+		}
+		registry.Register(DefaultProviderName, provider)
+	}
+
+	for name, cfg := range delivery.Providers {
+		provider, err := NewProvider(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", name, err)
+		}
+		registry.Register(name, provider)
+	}
+
+	if !registry.Has(defaultName) {
+		return nil, fmt.Errorf("default provider %q: %w", defaultName, ErrUnknownProvider)
+	}
+
+	for channel, cfg := range delivery.Channels {
+		if !model.ValidChannel(channel) {
+			return nil, fmt.Errorf("channel %q: %w", channel, model.ErrUnknownChannel)
+		}
+		for _, name := range cfg.Providers {
+			if !registry.Has(name) {
+				return nil, fmt.Errorf("channel %q: %w: %s", channel, ErrUnknownProvider, name)
+			}
+		}
+		registry.SetChannel(channel, cfg)
+	}
+	return registry, nil
+}
+
+// NewProvider creates a provider of the configured type
+func NewProvider(cfg config.Provider) (Provider, error) {
+	switch cfg.Type {
+	case ProviderTypeWebhook, "":
+		return NewWebhookClient(config.Webhook{
+			URL:            cfg.URL,
+			AuthKey:        cfg.AuthKey,
+			ConnectTimeout: cfg.ConnectTimeout,
+			TLSTimeout:     cfg.TLSTimeout,
+			Timeout:        cfg.Timeout,
+			Signing:        cfg.Signing,
+			TLS:            cfg.TLS,
+		})
+	case ProviderTypeHTTP:
+		return NewHTTPProvider(cfg)
+	case ProviderTypeSMTP:
+		return NewSMTPProvider(cfg)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProviderType, cfg.Type)
+	}
+}
+
+// Register adds or replaces the provider called name. Each provider gets its
+// own circuit breaker.
+func (r *Registry) Register(name string, provider Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[name] = provider
+	r.breakers[name] = NewBreaker(r.breakerCfg.FailureThreshold, r.breakerCfg.Cooldown)
+}
+
+// SetCircuitBreaker sets the circuit breaker settings of providers registered afterwards
+func (r *Registry) SetCircuitBreaker(cfg config.CircuitBreaker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakerCfg = cfg
+}
+
+// Get returns the provider called name, or the default provider if name is empty
+func (r *Registry) Get(name string) (Provider, error) {
+	if name == "" {
+		name = r.defaultName
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+// Has reports whether a provider called name is registered. The empty name
+// refers to the default provider.
+func (r *Registry) Has(name string) bool {
+	_, err := r.Get(name)
+	return err == nil
+}
+
+// SetChannel sets the providers and content limit of channel
+func (r *Registry) SetChannel(channel string, cfg config.Channel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.channels[channel] = cfg
+}
+
+// MaxLength returns the content limit of channel, in characters
+func (r *Registry) MaxLength(channel string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if cfg := r.channels[channel]; cfg.MaxLength > 0 {
+		return cfg.MaxLength
+	}
+	return model.DefaultMaxLength(channel)
+}
+
+// Default returns the name of the default provider
+func (r *Registry) Default() string {
+	return r.defaultName
+}
+
+// Names returns the names of all registered providers in alphabetical order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
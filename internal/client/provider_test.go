@@ -0,0 +1,382 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auto-messaging/config"
+	"auto-messaging/internal/model"
+)
+
+func TestNewRegistryFromConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		webhook       config.Webhook
+		delivery      config.Delivery
+		expectedNames []string
+		expectedError error
+	}{
+		{
+			name:          "legacy webhook only",
+			webhook:       config.Webhook{URL: "http://localhost/webhook", AuthKey: "key"},
+			expectedNames: []string{"webhook"},
+		},
+		{
+			name:    "named providers next to the legacy webhook",
+			webhook: config.Webhook{URL: "http://localhost/webhook", AuthKey: "key"},
+			delivery: config.Delivery{
+				DefaultProvider: "vendor-b",
+				Providers: map[string]config.Provider{
+					"vendor-b": {Type: ProviderTypeHTTP, URL: "http://localhost/b"},
+				},
+			},
+			expectedNames: []string{"vendor-b", "webhook"},
+		},
+		{
+			name: "smtp provider as default",
+			delivery: config.Delivery{
+				DefaultProvider: "email",
+				Providers: map[string]config.Provider{
+					"email": {Type: ProviderTypeSMTP, Host: "localhost", From: "noreply@example.com"},
+				},
+			},
+			expectedNames: []string{"email"},
+		},
+		{
+			name: "unknown provider type",
+			delivery: config.Delivery{
+				DefaultProvider: "vendor-b",
+				Providers: map[string]config.Provider{
+					"vendor-b": {Type: "carrier-pigeon", URL: "http://localhost/b"},
+				},
+			},
+			expectedError: ErrUnknownProviderType,
+		},
+		{
+			name:    "channel routed to unknown provider",
+			webhook: config.Webhook{URL: "http://localhost/webhook", AuthKey: "key"},
+			delivery: config.Delivery{
+				Channels: map[string]config.Channel{"sms": {Providers: []string{"webhook", "sms-vendor"}}},
+			},
+			expectedError: ErrUnknownProvider,
+		},
+		{
+			name:    "unknown channel",
+			webhook: config.Webhook{URL: "http://localhost/webhook", AuthKey: "key"},
+			delivery: config.Delivery{
+				Channels: map[string]config.Channel{"fax": {MaxLength: 100}},
+			},
+			expectedError: model.ErrUnknownChannel,
+		},
+		{
+			name:          "default provider not configured",
+			delivery:      config.Delivery{DefaultProvider: "vendor-b"},
+			expectedError: ErrUnknownProvider,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := NewRegistryFromConfig(tt.webhook, tt.delivery)
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("Expected error %v, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewRegistryFromConfig() error = %v", err)
+			}
+
+			names := registry.Names()
+			if len(names) != len(tt.expectedNames) {
+				t.Fatalf("Expected providers %v, got %v", tt.expectedNames, names)
+			}
+			for i := range names {
+				if names[i] != tt.expectedNames[i] {
+					t.Errorf("Expected providers %v, got %v", tt.expectedNames, names)
+				}
+			}
+			if _, err := registry.Get(""); err != nil {
+				t.Errorf("Expected default provider to resolve, got %v", err)
+			}
+			if _, err := registry.Get("missing"); !errors.Is(err, ErrUnknownProvider) || !IsPermanent(err) {
+				t.Errorf("Expected permanent ErrUnknownProvider, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPProvider_SendMessage(t *testing.T) {
+	var gotMethod, gotAuth, gotIdempotencyKey string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotAuth = r.Header.Get("Authorization")
+		gotIdempotencyKey = r.Header.Get("Idempotency-Key")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &gotBody); err != nil {
+			t.Errorf("Request body is not JSON: %s", body)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"id": 12345, "state": "queued"}}`))
+	}))
+	defer server.Close()
+
+	provider, err := NewHTTPProvider(config.Provider{
+		Type:           ProviderTypeHTTP,
+		URL:            server.URL,
+		Method:         "put",
+		Headers:        map[string]string{"Authorization": "Bearer secret"},
+		Body:           `{"recipient": {"address": {{json .To}}}, "text": {{json .Content}}, "reference": {{json .IdempotencyKey}}}`,
+		MessageIDField: "data.id",
+	})
+	if err != nil {
+		t.Fatalf("NewHTTPProvider() error = %v", err)
+	}
+
+	resp, err := provider.SendMessage(context.Background(), &model.WebhookRequest{
+		Content:        `Say "hi"`,
+		To:             "test@example.com",
+		IdempotencyKey: "delivery-1",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+
+	if gotMethod != http.MethodPut {
+		t.Errorf("Expected method PUT, got %s", gotMethod)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Expected configured Authorization header, got %q", gotAuth)
+	}
+	if gotIdempotencyKey != "delivery-1" {
+		t.Errorf("Expected Idempotency-Key delivery-1, got %q", gotIdempotencyKey)
+	}
+	if gotBody["text"] != `Say "hi"` || gotBody["reference"] != "delivery-1" {
+		t.Errorf("Unexpected request body %v", gotBody)
+	}
+	if address, _ := gotBody["recipient"].(map[string]interface{}); address["address"] != "test@example.com" {
+		t.Errorf("Unexpected recipient %v", gotBody["recipient"])
+	}
+	if resp.MessageID != "12345" {
+		t.Errorf("Expected message ID 12345, got %q", resp.MessageID)
+	}
+}
+
+func TestHTTPProvider_InvalidBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request for a body that cannot be rendered")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "not JSON", body: `{"text": {{.Content}}}`},
+		{name: "missing field", body: `{"text": {{json .Subject}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := NewHTTPProvider(config.Provider{Type: ProviderTypeHTTP, URL: server.URL, Body: tt.body})
+			if err != nil {
+				t.Fatalf("NewHTTPProvider() error = %v", err)
+			}
+			_, err = provider.SendMessage(context.Background(), &model.WebhookRequest{Content: "Hello world", To: "test@example.com"})
+			if err == nil || !IsPermanent(err) {
+				t.Errorf("Expected permanent error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewHTTPProvider_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Provider
+	}{
+		{name: "missing url", cfg: config.Provider{Type: ProviderTypeHTTP}},
+		{name: "broken template", cfg: config.Provider{Type: ProviderTypeHTTP, URL: "http://localhost", Body: `{"to": {{json .To}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHTTPProvider(tt.cfg); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+// providerFunc adapts a function to the Provider interface
+type providerFunc func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error)
+
+func (f providerFunc) SendMessage(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+	return f(ctx, req)
+}
+
+func TestRegistry_Route(t *testing.T) {
+	registry := NewRegistry("webhook")
+	registry.SetChannel(model.ChannelSMS, config.Channel{Providers: []string{"sms-a", "sms-b"}})
+
+	tests := []struct {
+		name     string
+		channel  string
+		provider string
+		expected []string
+	}{
+		{name: "channel providers in order", channel: model.ChannelSMS, expected: []string{"sms-a", "sms-b"}},
+		{name: "pinned provider does not fail over", channel: model.ChannelSMS, provider: "sms-b", expected: []string{"sms-b"}},
+		{name: "channel without providers uses default", channel: model.ChannelPush, expected: []string{"webhook"}},
+		{name: "empty channel is email", expected: []string{"webhook"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := registry.Route(tt.channel, tt.provider)
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected route %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRegistry_Send(t *testing.T) {
+	ok := func(id string) Provider {
+		return providerFunc(func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			return &model.WebhookResponse{MessageID: id}, nil
+		})
+	}
+	failing := func(err error) Provider {
+		return providerFunc(func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			return nil, err
+		})
+	}
+	unavailable := &SendError{StatusCode: http.StatusServiceUnavailable, Retryable: true}
+	rejected := &SendError{StatusCode: http.StatusBadRequest}
+
+	t.Run("fails over on retryable error", func(t *testing.T) {
+		registry := NewRegistry("a")
+		registry.Register("a", failing(unavailable))
+		registry.Register("b", ok("b-1"))
+
+		resp, deliveredBy, err := registry.Send(context.Background(), []string{"a", "b"}, &model.WebhookRequest{})
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if deliveredBy != "b" || resp.MessageID != "b-1" {
+			t.Errorf("Expected delivery by b, got %q %+v", deliveredBy, resp)
+		}
+		if registry.Breakers()["a"].ConsecutiveFailures != 1 {
+			t.Errorf("Expected failure recorded for a, got %+v", registry.Breakers()["a"])
+		}
+	})
+
+	t.Run("permanent error does not fail over", func(t *testing.T) {
+		registry := NewRegistry("a")
+		registry.Register("a", failing(rejected))
+		registry.Register("b", ok("b-1"))
+
+		_, _, err := registry.Send(context.Background(), []string{"a", "b"}, &model.WebhookRequest{})
+		if !IsPermanent(err) {
+			t.Errorf("Expected permanent error, got %v", err)
+		}
+		if registry.Breakers()["a"].ConsecutiveFailures != 0 {
+			t.Errorf("Expected permanent error not to count against a, got %+v", registry.Breakers()["a"])
+		}
+	})
+
+	t.Run("all providers failing", func(t *testing.T) {
+		registry := NewRegistry("a")
+		registry.Register("a", failing(unavailable))
+		registry.Register("b", failing(unavailable))
+
+		_, _, err := registry.Send(context.Background(), []string{"a", "b"}, &model.WebhookRequest{})
+		if err == nil || IsPermanent(err) {
+			t.Errorf("Expected retryable error, got %v", err)
+		}
+	})
+
+	t.Run("all circuits open", func(t *testing.T) {
+		registry := NewRegistry("a")
+		registry.SetCircuitBreaker(config.CircuitBreaker{FailureThreshold: 1, Cooldown: time.Minute})
+		registry.Register("a", failing(unavailable))
+		registry.Breaker("a").Failure()
+
+		_, _, err := registry.Send(context.Background(), []string{"a"}, &model.WebhookRequest{})
+		if !errors.Is(err, ErrNoProviderAvailable) || IsPermanent(err) {
+			t.Fatalf("Expected retryable ErrNoProviderAvailable, got %v", err)
+		}
+		if delay := RetryAfter(err); delay <= 0 || delay > time.Minute {
+			t.Errorf("Expected retry once the circuit admits a probe, got %v", delay)
+		}
+	})
+
+	t.Run("unreadable response to an accepted message is not sent again", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Write([]byte(`<html>queued</html>`))
+		}))
+		defer server.Close()
+		webhook := mustWebhookClient(t, config.Webhook{URL: server.URL})
+		templated, err := NewHTTPProvider(config.Provider{Type: ProviderTypeHTTP, URL: server.URL})
+		if err != nil {
+			t.Fatalf("NewHTTPProvider() error = %v", err)
+		}
+
+		for name, provider := range map[string]Provider{"webhook": webhook, "http": templated} {
+			calls.Store(0)
+			registry := NewRegistry("a")
+			registry.Register("a", provider)
+			registry.Register("b", failing(errors.New("b must not be called")))
+
+			resp, deliveredBy, err := registry.Send(context.Background(), []string{"a", "b"}, &model.WebhookRequest{Content: "Test", To: "test@example.com"})
+			if err != nil || deliveredBy != "a" {
+				t.Fatalf("%s: expected delivery by a, got %q %v", name, deliveredBy, err)
+			}
+			if resp.MessageID != "" {
+				t.Errorf("%s: expected no message ID, got %q", name, resp.MessageID)
+			}
+			if calls.Load() != 1 {
+				t.Errorf("%s: expected a single request, got %d", name, calls.Load())
+			}
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		registry := NewRegistry("a")
+		_, _, err := registry.Send(context.Background(), []string{"missing"}, &model.WebhookRequest{})
+		if !errors.Is(err, ErrUnknownProvider) {
+			t.Errorf("Expected ErrUnknownProvider, got %v", err)
+		}
+	})
+}
+
+func TestRegistry_Unavailable(t *testing.T) {
+	registry := NewRegistry("a")
+	registry.SetCircuitBreaker(config.CircuitBreaker{FailureThreshold: 1, Cooldown: time.Minute})
+	registry.Register("a", providerFunc(nil))
+	registry.Register("b", providerFunc(nil))
+
+	registry.Breaker("a").Failure()
+	if _, paused := registry.Unavailable(); paused {
+		t.Error("Expected registry to be available while b is closed")
+	}
+
+	registry.Breaker("b").Failure()
+	until, paused := registry.Unavailable()
+	if !paused || until.IsZero() {
+		t.Errorf("Expected registry to be unavailable with every circuit open, got %v %v", until, paused)
+	}
+}
@@ -0,0 +1,328 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"auto-messaging/config"
+	"auto-messaging/internal/model"
+)
+
+const (
+	defaultSMTPPort = 587
+
+	authMechanismPlain = "plain"
+	authMechanismLogin = "login"
+
+	startTLSRequired = "required"
+	startTLSOptional = "optional"
+	startTLSDisabled = "disabled"
+)
+
+var (
+	ErrStartTLSUnavailable = errors.New("mail server does not support STARTTLS")
+	ErrAuthUnavailable     = errors.New("mail server does not support authentication")
+	ErrInvalidRecipient    = errors.New("recipient is not a valid email address")
+)
+
+// smtpProvider sends messages as plain text email
+type smtpProvider struct {
+	host           string
+	addr           string
+	from           *mail.Address
+	subject        *template.Template
+	username       string
+	password       string
+	authMechanism  string
+	startTLS       string
+	connectTimeout time.Duration
+	timeout        time.Duration
+}
+
+// NewSMTPProvider creates a provider that delivers messages through the mail
+// server at cfg.Host. The subject line is rendered from the cfg.Subject
+// template, which is executed with the model.WebhookRequest of the message.
+func NewSMTPProvider(cfg config.Provider) (Provider, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	subject, err := template.New("subject").Option("missingkey=error").Parse(cfg.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+
+	authMechanism := strings.ToLower(cfg.AuthMechanism)
+	switch authMechanism {
+	case "":
+		authMechanism = authMechanismPlain
+	case authMechanismPlain, authMechanismLogin:
+	default:
+		return nil, fmt.Errorf("unsupported auth mechanism %q", cfg.AuthMechanism)
+	}
+
+	startTLS := strings.ToLower(cfg.StartTLS)
+	switch startTLS {
+	case "":
+		startTLS = startTLSRequired
+	case startTLSRequired, startTLSOptional, startTLSDisabled:
+	default:
+		return nil, fmt.Errorf("unsupported starttls mode %q", cfg.StartTLS)
+	}
+
+	connectTimeout := cfg.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &smtpProvider{
+		host:           cfg.Host,
+		addr:           net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		from:           from,
+		subject:        subject,
+		username:       cfg.Username,
+		password:       cfg.Password,
+		authMechanism:  authMechanism,
+		startTLS:       startTLS,
+		connectTimeout: connectTimeout,
+		timeout:        timeout,
+	}, nil
+}
+
+// SendMessage sends req as an email. The returned message ID is the
+// Message-ID header, which is derived from the idempotency key so that
+// receivers can recognise redelivered messages.
+func (p *smtpProvider) SendMessage(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+	to, err := mail.ParseAddress(req.To)
+	if err != nil {
+		return nil, &SendError{Err: fmt.Errorf("%w: %v", ErrInvalidRecipient, err)}
+	}
+
+	messageID := p.messageID(req.IdempotencyKey)
+	msg, err := p.buildMessage(req, to, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: p.connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, newTransportError(err)
+	}
+	defer conn.Close()
+
+	// The deadline bounds the whole conversation, cancelling ctx cuts it short
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := p.send(conn, to.Address, msg); err != nil {
+		if ctx.Err() != nil {
+			return nil, newTransportError(ctx.Err())
+		}
+		return nil, newSMTPError(err)
+	}
+
+	return &model.WebhookResponse{
+		Message:   "Message accepted by mail server",
+		MessageID: messageID,
+	}, nil
+}
+
+// send runs the SMTP conversation for a single message over conn
+func (p *smtpProvider) send(conn net.Conn, to string, msg []byte) error {
+	c, err := smtp.NewClient(conn, p.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if p.startTLS != startTLSDisabled {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: p.host}); err != nil {
+				return err
+			}
+		} else if p.startTLS == startTLSRequired {
+			return ErrStartTLSUnavailable
+		}
+	}
+
+	if p.username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return ErrAuthUnavailable
+		}
+		if err := c.Auth(p.auth()); err != nil {
+			return commandError(err)
+		}
+	}
+
+	if err := c.Mail(p.from.Address); err != nil {
+		return commandError(err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return commandError(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// The server took responsibility for the message when it accepted the
+	// data, a failed QUIT must not get it sent again
+	c.Quit()
+	return nil
+}
+
+// commandError marks an error of an SMTP command as permanent if net/smtp
+// raised it before anything went over the wire, such as PlainAuth refusing
+// to send credentials over an unencrypted connection or an address with a
+// line break in it. Such errors recur on every attempt. Replies of the
+// server and network errors are left for newSMTPError to classify.
+func commandError(err error) error {
+	var reply *textproto.Error
+	var netErr net.Error
+	if errors.As(err, &reply) || errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return &SendError{Err: err}
+}
+
+func (p *smtpProvider) auth() smtp.Auth {
+	if p.authMechanism == authMechanismLogin {
+		return &loginAuth{host: p.host, username: p.username, password: p.password}
+	}
+	return smtp.PlainAuth("", p.username, p.password, p.host)
+}
+
+// messageID builds the Message-ID for a delivery, without angle brackets
+func (p *smtpProvider) messageID(key string) string {
+	if key == "" {
+		key = model.NewDeliveryKey()
+	}
+	domain := p.host
+	if at := strings.LastIndex(p.from.Address, "@"); at >= 0 {
+		domain = p.from.Address[at+1:]
+	}
+	return key + "@" + domain
+}
+
+// buildMessage renders the headers and quoted-printable body of the email
+func (p *smtpProvider) buildMessage(req *model.WebhookRequest, to *mail.Address, messageID string) ([]byte, error) {
+	var subject bytes.Buffer
+	if err := p.subject.Execute(&subject, req); err != nil {
+		return nil, fmt.Errorf("failed to render subject: %w", err)
+	}
+
+	var msg bytes.Buffer
+	header := func(key, value string) {
+		msg.WriteString(key + ": " + value + "\r\n")
+	}
+	header("From", p.from.String())
+	header("To", to.String())
+	if s := strings.Join(strings.Fields(subject.String()), " "); s != "" {
+		header("Subject", mime.QEncoding.Encode("utf-8", s))
+	}
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+messageID+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=UTF-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	msg.WriteString("\r\n")
+
+	body := quotedprintable.NewWriter(&msg)
+	if _, err := body.Write([]byte(req.Content)); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// newSMTPError classifies a failed SMTP conversation. Transient (4xx) replies
+// and network errors are retryable, permanent (5xx) replies are not.
+func newSMTPError(err error) *SendError {
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr
+	}
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return &SendError{Retryable: reply.Code < 500, Err: err}
+	}
+	if errors.Is(err, ErrStartTLSUnavailable) || errors.Is(err, ErrAuthUnavailable) {
+		return &SendError{Err: err}
+	}
+	return newTransportError(err)
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which net/smtp
+// does not provide. Like smtp.PlainAuth it refuses to send credentials over
+// an unencrypted connection, except to localhost.
+type loginAuth struct {
+	host     string
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
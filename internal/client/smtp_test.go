@@ -0,0 +1,319 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+
+	"auto-messaging/config"
+	"auto-messaging/internal/model"
+)
+
+// smtpStub is a minimal in-process SMTP server that records what it receives
+type smtpStub struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	auth     []string
+	from     string
+	rcpt     []string
+	data     string
+	rejectTo string
+	// hangUpOnQuit closes the connection instead of answering QUIT
+	hangUpOnQuit bool
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	return startSMTPStub(t, listener)
+}
+
+func startSMTPStub(t *testing.T, listener net.Listener) *smtpStub {
+	t.Helper()
+	s := &smtpStub{listener: listener}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *smtpStub) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *smtpStub) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	readLine := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err == nil
+	}
+
+	reply("220 stub ESMTP")
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := strings.TrimSpace(strings.TrimPrefix(line, strings.SplitN(line, " ", 2)[0]))
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-stub")
+			reply("250 AUTH PLAIN LOGIN")
+		case "AUTH":
+			fields := strings.Fields(arg)
+			switch strings.ToUpper(fields[0]) {
+			case "PLAIN":
+				decoded, _ := base64.StdEncoding.DecodeString(fields[1])
+				s.record(func() { s.auth = append(s.auth, "PLAIN", string(decoded)) })
+			case "LOGIN":
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				user, _ := readLine()
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				pass, _ := readLine()
+				u, _ := base64.StdEncoding.DecodeString(user)
+				p, _ := base64.StdEncoding.DecodeString(pass)
+				s.record(func() { s.auth = append(s.auth, "LOGIN", string(u)+":"+string(p)) })
+			}
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			s.record(func() { s.from = arg })
+			reply("250 2.1.0 OK")
+		case "RCPT":
+			s.mu.Lock()
+			reject := s.rejectTo != "" && strings.Contains(arg, s.rejectTo)
+			s.mu.Unlock()
+			if reject {
+				reply("550 5.1.1 Mailbox unavailable")
+				continue
+			}
+			s.record(func() { s.rcpt = append(s.rcpt, arg) })
+			reply("250 2.1.5 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				line, ok := readLine()
+				if !ok || line == "." {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, ".") + "\r\n")
+			}
+			s.record(func() { s.data = data.String() })
+			reply("250 2.0.0 Queued")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			s.mu.Lock()
+			hangUp := s.hangUpOnQuit
+			s.mu.Unlock()
+			if !hangUp {
+				reply("221 Bye")
+			}
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpStub) record(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+func TestSMTPProvider_SendMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		mechanism    string
+		expectedAuth []string
+	}{
+		{name: "plain auth", mechanism: "plain", expectedAuth: []string{"PLAIN", "\x00mailer\x00secret"}},
+		{name: "login auth", mechanism: "login", expectedAuth: []string{"LOGIN", "mailer:secret"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newSMTPStub(t)
+			provider, err := NewSMTPProvider(config.Provider{
+				Type:          ProviderTypeSMTP,
+				Host:          "127.0.0.1",
+				Port:          stub.port(),
+				Username:      "mailer",
+				Password:      "secret",
+				AuthMechanism: tt.mechanism,
+				StartTLS:      "optional",
+				From:          "Auto Messaging <noreply@example.com>",
+				Subject:       "Message for {{.To}}",
+			})
+			if err != nil {
+				t.Fatalf("NewSMTPProvider() error = %v", err)
+			}
+
+			resp, err := provider.SendMessage(context.Background(), &model.WebhookRequest{
+				Content:        "Héllo from the stub test",
+				To:             "test@example.com",
+				IdempotencyKey: "delivery-1",
+			})
+			if err != nil {
+				t.Fatalf("SendMessage() error = %v", err)
+			}
+			if resp.MessageID != "delivery-1@example.com" {
+				t.Errorf("Expected message ID derived from delivery key, got %q", resp.MessageID)
+			}
+
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if len(stub.auth) != 2 || stub.auth[0] != tt.expectedAuth[0] || stub.auth[1] != tt.expectedAuth[1] {
+				t.Errorf("Expected auth %q, got %q", tt.expectedAuth, stub.auth)
+			}
+			if stub.from != "FROM:<noreply@example.com>" {
+				t.Errorf("Unexpected MAIL command argument %q", stub.from)
+			}
+			if len(stub.rcpt) != 1 || stub.rcpt[0] != "TO:<test@example.com>" {
+				t.Errorf("Unexpected RCPT command arguments %q", stub.rcpt)
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(stub.data))
+			if err != nil {
+				t.Fatalf("Failed to parse received message: %v", err)
+			}
+			if got := msg.Header.Get("Subject"); got != "Message for test@example.com" {
+				t.Errorf("Unexpected subject %q", got)
+			}
+			if got := msg.Header.Get("Message-ID"); got != "<delivery-1@example.com>" {
+				t.Errorf("Unexpected Message-ID %q", got)
+			}
+			body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+			if strings.TrimRight(string(body), "\r\n") != "Héllo from the stub test" {
+				t.Errorf("Unexpected body %q", body)
+			}
+		})
+	}
+}
+
+func TestSMTPProvider_Errors(t *testing.T) {
+	stub := newSMTPStub(t)
+	stub.rejectTo = "unknown@example.com"
+
+	newProvider := func(startTLS string) Provider {
+		provider, err := NewSMTPProvider(config.Provider{
+			Type:     ProviderTypeSMTP,
+			Host:     "127.0.0.1",
+			Port:     stub.port(),
+			StartTLS: startTLS,
+			From:     "noreply@example.com",
+		})
+		if err != nil {
+			t.Fatalf("NewSMTPProvider() error = %v", err)
+		}
+		return provider
+	}
+
+	t.Run("rejected recipient is permanent", func(t *testing.T) {
+		_, err := newProvider("disabled").SendMessage(context.Background(), &model.WebhookRequest{Content: "Test", To: "unknown@example.com"})
+		if err == nil || !IsPermanent(err) {
+			t.Errorf("Expected permanent error, got %v", err)
+		}
+	})
+
+	t.Run("invalid recipient is permanent", func(t *testing.T) {
+		_, err := newProvider("disabled").SendMessage(context.Background(), &model.WebhookRequest{Content: "Test", To: "not an address\r\nBcc: x@example.com"})
+		if !errors.Is(err, ErrInvalidRecipient) || !IsPermanent(err) {
+			t.Errorf("Expected permanent ErrInvalidRecipient, got %v", err)
+		}
+	})
+
+	t.Run("required STARTTLS not offered", func(t *testing.T) {
+		_, err := newProvider("required").SendMessage(context.Background(), &model.WebhookRequest{Content: "Test", To: "test@example.com"})
+		if !errors.Is(err, ErrStartTLSUnavailable) {
+			t.Errorf("Expected ErrStartTLSUnavailable, got %v", err)
+		}
+	})
+
+	t.Run("credentials refused locally are permanent", func(t *testing.T) {
+		// PlainAuth only sends credentials unencrypted to localhost
+		listener, err := net.Listen("tcp", "127.0.0.2:0")
+		if err != nil {
+			t.Skipf("127.0.0.2 is not available: %v", err)
+		}
+		remote := startSMTPStub(t, listener)
+		provider, _ := NewSMTPProvider(config.Provider{
+			Host:     "127.0.0.2",
+			Port:     remote.port(),
+			StartTLS: "disabled",
+			From:     "noreply@example.com",
+			Username: "user",
+			Password: "secret",
+		})
+		_, err = provider.SendMessage(context.Background(), &model.WebhookRequest{Content: "Test", To: "test@example.com"})
+		if err == nil || !IsPermanent(err) {
+			t.Errorf("Expected permanent error, got %v", err)
+		}
+		remote.record(func() {
+			if len(remote.auth) != 0 {
+				t.Errorf("Expected no credentials to be sent, got %v", remote.auth)
+			}
+		})
+	})
+
+	t.Run("failed QUIT after accepted data is delivered", func(t *testing.T) {
+		stub.record(func() { stub.hangUpOnQuit = true })
+		defer stub.record(func() { stub.hangUpOnQuit = false })
+
+		resp, err := newProvider("disabled").SendMessage(context.Background(), &model.WebhookRequest{Content: "Test", To: "test@example.com"})
+		if err != nil || resp.MessageID == "" {
+			t.Errorf("Expected the message to be delivered, got %v", err)
+		}
+	})
+
+	t.Run("unreachable server is retryable", func(t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		provider, _ := NewSMTPProvider(config.Provider{Host: "127.0.0.1", Port: port, From: "noreply@example.com"})
+		_, err := provider.SendMessage(context.Background(), &model.WebhookRequest{Content: "Test", To: "test@example.com"})
+		if err == nil || IsPermanent(err) {
+			t.Errorf("Expected retryable error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"auto-messaging/config"
+)
+
+// tlsReloadInterval is how often the certificate files are checked for changes
+const tlsReloadInterval = 10 * time.Second
+
+// tlsVersions are the accepted minimum TLS versions. Older versions are
+// deprecated and not offered, so that a typo cannot downgrade the connection.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsEnabled reports whether cfg changes anything about the default TLS setup
+func tlsEnabled(cfg config.TLS) bool {
+	return cfg != config.TLS{}
+}
+
+// newTLSConfig builds the client TLS configuration described by cfg, reading
+// the certificate files from disk
+func newTLSConfig(cfg config.TLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: cfg.ServerName}
+
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version %q", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be configured together")
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// reloadingTransport is an http.RoundTripper that rebuilds its transport
+// whenever the certificate, key or CA file changes on disk, so that rotated
+// certificates are picked up without a restart. A change that fails to load,
+// for example a certificate written before its key, keeps the previous
+// transport until the files are consistent again.
+type reloadingTransport struct {
+	cfg      config.TLS
+	build    func(*tls.Config) *http.Transport
+	interval time.Duration
+
+	mu        sync.Mutex
+	transport *http.Transport
+	modTimes  []time.Time
+	checkedAt time.Time
+}
+
+// newReloadingTransport loads the files of cfg and returns a transport built
+// with build from the resulting TLS configuration
+func newReloadingTransport(cfg config.TLS, build func(*tls.Config) *http.Transport) (*reloadingTransport, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadingTransport{
+		cfg:       cfg,
+		build:     build,
+		interval:  tlsReloadInterval,
+		transport: build(tlsConfig),
+		modTimes:  modTimes(cfg),
+		checkedAt: time.Now(),
+	}, nil
+}
+
+// RoundTrip sends req with the current transport
+func (t *reloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current().RoundTrip(req)
+}
+
+// current returns the transport to use, reloading the certificate files first
+// if they changed since the last check
+func (t *reloadingTransport) current() *http.Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.checkedAt) < t.interval {
+		return t.transport
+	}
+	t.checkedAt = now
+
+	current := modTimes(t.cfg)
+	if equalTimes(current, t.modTimes) {
+		return t.transport
+	}
+
+	tlsConfig, err := newTLSConfig(t.cfg)
+	if err != nil {
+		log.Printf("Failed to reload TLS certificates, keeping the previous ones: %v", err)
+		return t.transport
+	}
+	log.Printf("Reloaded TLS certificates")
+
+	// Connections made with the old certificates are closed once idle
+	t.transport.CloseIdleConnections()
+	t.transport = t.build(tlsConfig)
+	t.modTimes = current
+	return t.transport
+}
+
+// CloseIdleConnections closes the idle connections of the current transport
+func (t *reloadingTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.transport.CloseIdleConnections()
+}
+
+// modTimes returns the modification times of the files of cfg, zero for
+// files that are not configured or cannot be read
+func modTimes(cfg config.TLS) []time.Time {
+	files := []string{cfg.CertFile, cfg.KeyFile, cfg.CAFile}
+	times := make([]time.Time, len(files))
+	for i, file := range files {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
+
+func equalTimes(a, b []time.Time) bool {
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"auto-messaging/config"
+	"auto-messaging/internal/model"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for commonName, valid for the DNS
+// name dnsName if it is not empty
+func (ca *testCA) issue(t *testing.T, commonName, dnsName string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if dnsName != "" {
+		template.DNSNames = []string{dnsName}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookClient_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	// The gateway only accepts clients with a certificate from the CA and
+	// presents a certificate for its internal name only
+	serverCert, serverKey := ca.issue(t, "gateway", "gateway.internal", x509.ExtKeyUsageServerAuth)
+	serverPair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clientName string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientName = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.Write([]byte(`{"messageId": "mtls-1"}`))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MaxVersion:   tls.VersionTLS12,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	modTime := time.Now().Add(-time.Minute)
+	certPEM, keyPEM := ca.issue(t, "client-1", "", x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, certPEM, modTime)
+	writeFile(t, keyFile, keyPEM, modTime)
+	writeFile(t, caFile, ca.pem, modTime)
+
+	tlsCfg := config.TLS{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ServerName: "gateway.internal"}
+	req := &model.WebhookRequest{Content: "Test message", To: "test@example.com"}
+
+	client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-key", TLS: tlsCfg})
+	if _, err := client.SendMessage(context.Background(), req); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if clientName != "client-1" {
+		t.Errorf("Expected client certificate client-1, got %q", clientName)
+	}
+
+	rejected := []struct {
+		name string
+		tls  config.TLS
+	}{
+		{name: "no client certificate", tls: config.TLS{CAFile: caFile, ServerName: "gateway.internal"}},
+		{name: "server name mismatch", tls: config.TLS{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}},
+		{name: "unknown CA", tls: config.TLS{CertFile: certFile, KeyFile: keyFile, ServerName: "gateway.internal"}},
+		{name: "minimum version not offered", tls: config.TLS{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ServerName: "gateway.internal", MinVersion: "1.3"}},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-key", TLS: tt.tls})
+			if _, err := client.SendMessage(context.Background(), req); err == nil {
+				t.Error("Expected TLS handshake to fail")
+			}
+		})
+	}
+
+	t.Run("rotated certificate is reloaded", func(t *testing.T) {
+		transport := client.(*webhookClient).client.Transport.(*reloadingTransport)
+		transport.interval = 0
+
+		certPEM, keyPEM := ca.issue(t, "client-2", "", x509.ExtKeyUsageClientAuth)
+		writeFile(t, certFile, certPEM, modTime.Add(time.Second))
+		writeFile(t, keyFile, keyPEM, modTime.Add(time.Second))
+
+		if _, err := client.SendMessage(context.Background(), req); err != nil {
+			t.Fatalf("SendMessage() error = %v", err)
+		}
+		if clientName != "client-2" {
+			t.Errorf("Expected rotated client certificate client-2, got %q", clientName)
+		}
+
+		// A broken file keeps the certificate that was loaded last
+		writeFile(t, certFile, []byte("not a certificate"), modTime.Add(2*time.Second))
+		if _, err := client.SendMessage(context.Background(), req); err != nil {
+			t.Fatalf("SendMessage() error = %v", err)
+		}
+		if clientName != "client-2" {
+			t.Errorf("Expected client certificate client-2 to be kept, got %q", clientName)
+		}
+	})
+}
+
+func TestNewWebhookClient_InvalidTLS(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		tls  config.TLS
+	}{
+		{name: "certificate without key", tls: config.TLS{CertFile: filepath.Join(dir, "client.crt")}},
+		{name: "missing files", tls: config.TLS{CertFile: filepath.Join(dir, "client.crt"), KeyFile: filepath.Join(dir, "client.key")}},
+		{name: "missing CA bundle", tls: config.TLS{CAFile: filepath.Join(dir, "ca.crt")}},
+		{name: "unsupported version", tls: config.TLS{MinVersion: "2.0"}},
+		{name: "deprecated version", tls: config.TLS{MinVersion: "1.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWebhookClient(config.Webhook{URL: "https://localhost", TLS: tt.tls}); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
@@ -2,60 +2,133 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"log"
+	"net"
 	"net/http"
+	"time"
 
+	"auto-messaging/config"
 	"auto-messaging/internal/model"
+	"auto-messaging/pkg/signature"
 )
 
 const (
-	authHeaderKey = "x-ins-auth-key"
-	contentType   = "application/json"
+	authHeaderKey        = "x-ins-auth-key"
+	idempotencyHeaderKey = "Idempotency-Key"
+	contentType          = "application/json"
+
+	defaultConnectTimeout = 5 * time.Second
+	defaultTLSTimeout     = 5 * time.Second
+	defaultTimeout        = 30 * time.Second
+)
+
+var (
+	ErrSigningSecretMissing = errors.New("signing has a key_id but no secret")
 )
 
 // WebhookClient defines the interface for webhook operations
 type WebhookClient interface {
-	SendMessage(req *model.WebhookRequest) (*model.WebhookResponse, error)
+	SendMessage(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error)
 }
 
 // webhookClient implements WebhookClient interface
 type webhookClient struct {
 	url     string
 	authKey string
+	signer  *signature.Signer
 	client  *http.Client
 }
 
-// NewWebhookClient creates a new webhook client
-func NewWebhookClient(url, authKey string) WebhookClient {
-	return &webhookClient{
-		url:     url,
-		authKey: authKey,
-		client:  &http.Client{},
+// NewWebhookClient creates a new webhook client. Zero timeouts in cfg fall
+// back to the defaults, so that a hung provider can never block a send forever.
+// Requests are signed if cfg.Signing has a secret. Signing configured with a
+// key ID but without a secret is an error rather than silently left off.
+func NewWebhookClient(cfg config.Webhook) (WebhookClient, error) {
+	if cfg.Signing.KeyID != "" && cfg.Signing.Secret == "" {
+		return nil, ErrSigningSecretMissing
+	}
+	httpClient, err := newHTTPClient(cfg.ConnectTimeout, cfg.TLSTimeout, cfg.Timeout, cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	c := &webhookClient{
+		url:     cfg.URL,
+		authKey: cfg.AuthKey,
+		client:  httpClient,
+	}
+	if cfg.Signing.Secret != "" {
+		c.signer = signature.NewSigner(cfg.Signing.KeyID, cfg.Signing.Secret)
+	}
+	return c, nil
+}
+
+// newHTTPClient creates an HTTP client with the given timeouts, using the
+// defaults for zero values. With TLS settings the client presents and
+// verifies certificates as configured and reloads them when they change.
+func newHTTPClient(connectTimeout, tlsTimeout, timeout time.Duration, tlsCfg config.TLS) (*http.Client, error) {
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	if tlsTimeout <= 0 {
+		tlsTimeout = defaultTLSTimeout
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	build := func(tlsConfig *tls.Config) *http.Transport {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = tlsTimeout
+		transport.TLSClientConfig = tlsConfig
+		return transport
+	}
+
+	if !tlsEnabled(tlsCfg) {
+		return &http.Client{Transport: build(nil), Timeout: timeout}, nil
+	}
+	transport, err := newReloadingTransport(tlsCfg, build)
+	if err != nil {
+		return nil, err
 	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
 }
 
-// SendMessage sends a message to the webhook
-func (c *webhookClient) SendMessage(req *model.WebhookRequest) (*model.WebhookResponse, error) {
+// SendMessage sends a message to the webhook. The request is aborted when ctx
+// is cancelled or the configured timeout elapses. Failed calls return a
+// *SendError telling whether the message may be sent again.
+func (c *webhookClient) SendMessage(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequest(http.MethodPost, c.url, bytes.NewBuffer(body))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
 
 	httpReq.Header.Set("Content-Type", contentType)
 	httpReq.Header.Set(authHeaderKey, c.authKey)
+	if req.IdempotencyKey != "" {
+		httpReq.Header.Set(idempotencyHeaderKey, req.IdempotencyKey)
+	}
+	if c.signer != nil {
+		c.signer.Sign(httpReq.Header, body, time.Now())
+	}
 
 	log.Printf("Sending webhook request to %s with body: %s", c.url, string(body))
 	resp, err := c.client.Do(httpReq)
 	if err != nil {
 		log.Printf("Webhook request failed: %v", err)
-		return nil, err
+		return nil, newTransportError(err)
 	}
 	defer resp.Body.Close()
 
@@ -63,21 +136,22 @@ func (c *webhookClient) SendMessage(req *model.WebhookRequest) (*model.WebhookRe
 
 	// Accept any 2xx status code
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, errors.New("unexpected response status: " + resp.Status)
+		return nil, newStatusError(resp)
 	}
 
 	// For 202 Accepted, we don't need to parse the response body
 	if resp.StatusCode == http.StatusAccepted {
 		return &model.WebhookResponse{
 			Message:   "Message accepted",
-			MessageID: "accepted",
+			MessageID: model.AcceptedMessageID,
 		}, nil
 	}
 
+	// The webhook accepted the message, sending it again would duplicate it
 	var response model.WebhookResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		log.Printf("Failed to decode webhook response: %v", err)
-		return nil, err
+		log.Printf("Webhook accepted the message but its response could not be decoded: %v", err)
+		return &model.WebhookResponse{Message: resp.Status}, nil
 	}
 
 	log.Printf("Webhook response: %+v", response)
@@ -1,12 +1,17 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"auto-messaging/config"
 	"auto-messaging/internal/model"
+	"auto-messaging/pkg/signature"
 )
 
 func TestWebhookClient_SendMessage(t *testing.T) {
@@ -51,6 +56,7 @@ func TestWebhookClient_SendMessage(t *testing.T) {
 			expectedError: true,
 		},
 		{
+			// The message was accepted, failing would get it sent again
 			name: "invalid response format",
 			handler: func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusOK)
@@ -60,7 +66,7 @@ func TestWebhookClient_SendMessage(t *testing.T) {
 				Content: "Test message",
 				To:      "test@example.com",
 			},
-			expectedError: true,
+			expectedError: false,
 		},
 	}
 
@@ -71,10 +77,10 @@ func TestWebhookClient_SendMessage(t *testing.T) {
 			defer server.Close()
 
 			// Create webhook client with test server URL
-			client := NewWebhookClient(server.URL, "test-auth-key")
+			client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-auth-key"})
 
 			// Send message
-			resp, err := client.SendMessage(tt.req)
+			resp, err := client.SendMessage(context.Background(), tt.req)
 
 			// Check error
 			if (err != nil) != tt.expectedError {
@@ -110,13 +116,16 @@ func TestWebhookClient_Headers(t *testing.T) {
 		if r.Header.Get("x-ins-auth-key") != "test-key" {
 			t.Errorf("Expected x-ins-auth-key: test-key, got %s", r.Header.Get("x-ins-auth-key"))
 		}
+		if r.Header.Get("Idempotency-Key") != "delivery-1" {
+			t.Errorf("Expected Idempotency-Key: delivery-1, got %s", r.Header.Get("Idempotency-Key"))
+		}
 
 		// Read and verify request body
 		var reqBody map[string]string
 		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
 			t.Errorf("Failed to decode request body: %v", err)
 		}
-		if reqBody["to"] != "test@example.com" || reqBody["content"] != "Test message" {
+		if reqBody["to"] != "test@example.com" || reqBody["content"] != "Test message" || reqBody["idempotency_key"] != "delivery-1" {
 			t.Errorf("Unexpected request body: %v", reqBody)
 		}
 
@@ -130,16 +139,17 @@ func TestWebhookClient_Headers(t *testing.T) {
 	defer server.Close()
 
 	// Create webhook client
-	client := NewWebhookClient(server.URL, "test-key")
+	client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-key"})
 
 	// Create test request
 	request := &model.WebhookRequest{
-		Content: "Test message",
-		To:      "test@example.com",
+		Content:        "Test message",
+		To:             "test@example.com",
+		IdempotencyKey: "delivery-1",
 	}
 
 	// Send message
-	response, err := client.SendMessage(request)
+	response, err := client.SendMessage(context.Background(), request)
 	if err != nil {
 		t.Errorf("SendMessage() error = %v", err)
 	}
@@ -147,3 +157,200 @@ func TestWebhookClient_Headers(t *testing.T) {
 		t.Errorf("Expected response message 'Message sent successfully', got '%s'", response.Message)
 	}
 }
+
+func TestWebhookClient_Timeouts(t *testing.T) {
+	// A provider that never answers
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	request := &model.WebhookRequest{Content: "Test message", To: "test@example.com"}
+
+	t.Run("overall timeout", func(t *testing.T) {
+		client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-key", Timeout: 50 * time.Millisecond})
+
+		start := time.Now()
+		if _, err := client.SendMessage(context.Background(), request); err == nil {
+			t.Fatal("Expected timeout error")
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected request to time out quickly, took %s", elapsed)
+		}
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-key"})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err := client.SendMessage(ctx, request)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestWebhookClient_ErrorClassification(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		retryAfter       string
+		body             string
+		expectRetryable  bool
+		expectRetryAfter time.Duration
+	}{
+		{name: "bad request", status: http.StatusBadRequest, body: `{"error":"invalid recipient"}`},
+		{name: "unauthorized", status: http.StatusUnauthorized},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "unprocessable entity", status: http.StatusUnprocessableEntity},
+		{name: "internal server error", status: http.StatusInternalServerError, expectRetryable: true},
+		{name: "bad gateway", status: http.StatusBadGateway, expectRetryable: true},
+		{
+			name:             "too many requests with delay in seconds",
+			status:           http.StatusTooManyRequests,
+			retryAfter:       "120",
+			expectRetryable:  true,
+			expectRetryAfter: 2 * time.Minute,
+		},
+		{
+			name:            "service unavailable with invalid retry-after",
+			status:          http.StatusServiceUnavailable,
+			retryAfter:      "soon",
+			expectRetryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-key"})
+			_, err := client.SendMessage(context.Background(), &model.WebhookRequest{Content: "Test message", To: "test@example.com"})
+
+			var sendErr *SendError
+			if !errors.As(err, &sendErr) {
+				t.Fatalf("Expected *SendError, got %v", err)
+			}
+			if sendErr.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, sendErr.StatusCode)
+			}
+			if sendErr.Body != tt.body {
+				t.Errorf("Expected body %q, got %q", tt.body, sendErr.Body)
+			}
+			if IsPermanent(err) == tt.expectRetryable {
+				t.Errorf("Expected retryable = %v, got permanent = %v", tt.expectRetryable, IsPermanent(err))
+			}
+			if got := RetryAfter(err); got != tt.expectRetryAfter {
+				t.Errorf("Expected Retry-After %s, got %s", tt.expectRetryAfter, got)
+			}
+		})
+	}
+
+	t.Run("connection refused", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		url := server.URL
+		server.Close()
+
+		client := mustWebhookClient(t, config.Webhook{URL: url, AuthKey: "test-key"})
+		_, err := client.SendMessage(context.Background(), &model.WebhookRequest{Content: "Test message", To: "test@example.com"})
+		if err == nil || IsPermanent(err) {
+			t.Errorf("Expected retryable transport error, got %v", err)
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 4, 26, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "", expected: 0},
+		{value: "30", expected: 30 * time.Second},
+		{value: "-5", expected: 0},
+		{value: "Fri, 26 Apr 2024 10:01:30 GMT", expected: 90 * time.Second},
+		{value: "Fri, 26 Apr 2024 09:59:00 GMT", expected: 0},
+		{value: "later", expected: 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.expected {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestWebhookClient_SignedRequests(t *testing.T) {
+	// The receiver knows the previous and the current key of a rotation
+	verifier := signature.NewVerifier(map[string]string{"2024-04": "old-secret", "2024-05": "new-secret"}, time.Minute)
+
+	var verifyErr error
+	var gotKeyID string
+	var gotBody model.WebhookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKeyID = r.Header.Get(signature.KeyIDHeader)
+		body, err := verifier.VerifyRequest(r)
+		if verifyErr = err; err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &gotBody)
+		w.Write([]byte(`{"messageId": "signed-1"}`))
+	}))
+	defer server.Close()
+
+	req := &model.WebhookRequest{Content: "Test message", To: "test@example.com", IdempotencyKey: "delivery-1"}
+
+	for _, signing := range []config.Signing{{KeyID: "2024-04", Secret: "old-secret"}, {KeyID: "2024-05", Secret: "new-secret"}} {
+		client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-key", Signing: signing})
+		resp, err := client.SendMessage(context.Background(), req)
+		if err != nil {
+			t.Fatalf("SendMessage() with key %s error = %v (verification: %v)", signing.KeyID, err, verifyErr)
+		}
+		if resp.MessageID != "signed-1" || gotKeyID != signing.KeyID || gotBody.IdempotencyKey != "delivery-1" {
+			t.Errorf("Unexpected signed delivery with key %s: %+v, key %q, body %+v", signing.KeyID, resp, gotKeyID, gotBody)
+		}
+	}
+
+	// A secret the receiver does not know is rejected, as are unsigned requests
+	for _, signing := range []config.Signing{{KeyID: "2024-05", Secret: "guessed"}, {}} {
+		client := mustWebhookClient(t, config.Webhook{URL: server.URL, AuthKey: "test-key", Signing: signing})
+		if _, err := client.SendMessage(context.Background(), req); !IsPermanent(err) {
+			t.Errorf("Expected request to be rejected, got %v", err)
+		}
+		if verifyErr == nil {
+			t.Error("Expected verification to fail")
+		}
+	}
+}
+
+func TestNewWebhookClient_SigningWithoutSecret(t *testing.T) {
+	_, err := NewWebhookClient(config.Webhook{URL: "https://localhost", Signing: config.Signing{KeyID: "2024-05"}})
+	if !errors.Is(err, ErrSigningSecretMissing) {
+		t.Errorf("Expected ErrSigningSecretMissing, got %v", err)
+	}
+}
+
+func mustWebhookClient(t *testing.T, cfg config.Webhook) WebhookClient {
+	t.Helper()
+	client, err := NewWebhookClient(cfg)
+	if err != nil {
+		t.Fatalf("NewWebhookClient() error = %v", err)
+	}
+	return client
+}
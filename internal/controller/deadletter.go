@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auto-messaging/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidLimit = errors.New("limit must be between 0 and 1000")
+)
+
+const (
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+// DeadLetterFilterRequest represents the filters for listing or requeueing failed messages
+type DeadLetterFilterRequest struct {
+	To            string     `json:"to,omitempty" form:"to"`
+	ErrorContains string     `json:"error_contains,omitempty" form:"error_contains"`
+	FailedAfter   *time.Time `json:"failed_after,omitempty" form:"failed_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	FailedBefore  *time.Time `json:"failed_before,omitempty" form:"failed_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit         int        `json:"limit,omitempty" form:"limit"`
+}
+
+// RequeueResponse represents the result of a bulk requeue
+type RequeueResponse struct {
+	Requeued int64 `json:"requeued"`
+}
+
+func (r DeadLetterFilterRequest) toFilter() (repository.DeadLetterFilter, error) {
+	if r.Limit < 0 || r.Limit > maxDeadLetterLimit {
+		return repository.DeadLetterFilter{}, ErrInvalidLimit
+	}
+
+	filter := repository.DeadLetterFilter{
+		To:            r.To,
+		ErrorContains: r.ErrorContains,
+		Limit:         r.Limit,
+	}
+	if r.FailedAfter != nil {
+		filter.FailedAfter = *r.FailedAfter
+	}
+	if r.FailedBefore != nil {
+		filter.FailedBefore = *r.FailedBefore
+	}
+	return filter, nil
+}
+
+// @Summary List dead-letter messages
+// @Description Get permanently failed messages with their failure reason and attempt history
+// @Tags dead-letter
+// @Produce json
+// @Param to query string false "Recipient"
+// @Param error_contains query string false "Substring of the last error"
+// @Param failed_after query string false "Only messages that failed at or after this time (RFC 3339)"
+// @Param failed_before query string false "Only messages that failed before this time (RFC 3339)"
+// @Param limit query int false "Maximum number of messages (default 100)"
+// @Success 200 {array} model.Message
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /messages/dead-letter [get]
+func (c *MessageController) GetDeadLetters(ctx *gin.Context) {
+	var req DeadLetterFilterRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	filter, err := req.toFilter()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultDeadLetterLimit
+	}
+
+	messages, err := c.repo.FindDeadLetters(ctx.Request.Context(), filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get dead-letter messages"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, messages)
+}
+
+// @Summary Requeue a dead-letter message
+// @Description Move a failed message back to pending and reset its retry state. An expiry that has passed is dropped.
+// @Tags dead-letter
+// @Produce json
+// @Param id path int true "Message ID"
+// @Success 200 {object} model.Message
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /messages/{id}/requeue [post]
+func (c *MessageController) RequeueMessage(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid message ID"})
+		return
+	}
+
+	if _, err := c.repo.FindByID(ctx.Request.Context(), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Message not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get message"})
+		return
+	}
+
+	if err := c.repo.RequeueDeadLetter(apiContext(ctx), uint(id)); err != nil {
+		if errors.Is(err, repository.ErrNotDeadLetter) {
+			ctx.JSON(http.StatusConflict, ErrorResponse{Error: "Only failed messages can be requeued"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to requeue message"})
+		return
+	}
+	c.schedule(time.Now())
+
+	message, err := c.repo.FindByID(ctx.Request.Context(), uint(id))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get message"})
+		return
+	}
+
+	c.logger.Printf("Message %d requeued from dead-letter queue", id)
+	ctx.JSON(http.StatusOK, message)
+}
+
+// @Summary Requeue dead-letter messages in bulk
+// @Description Move all failed messages matching the filters back to pending and reset their retry state. Expiries that have passed are dropped.
+// @Tags dead-letter
+// @Accept json
+// @Produce json
+// @Param filter body DeadLetterFilterRequest false "Filters, omit to requeue every failed message"
+// @Success 200 {object} RequeueResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /messages/dead-letter/requeue [post]
+func (c *MessageController) RequeueDeadLetters(ctx *gin.Context) {
+	var req DeadLetterFilterRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	filter, err := req.toFilter()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	requeued, err := c.repo.RequeueDeadLetters(apiContext(ctx), filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to requeue messages"})
+		return
+	}
+
+	if requeued > 0 {
+		c.schedule(time.Now())
+	}
+	c.logger.Printf("Requeued %d messages from dead-letter queue", requeued)
+	ctx.JSON(http.StatusOK, RequeueResponse{Requeued: requeued})
+}
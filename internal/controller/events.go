@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"auto-messaging/internal/model"
+	"auto-messaging/internal/repository"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// apiContext returns the request context tagged with the calling client,
+// so that status changes made through the API are attributed to it
+func apiContext(ctx *gin.Context) context.Context {
+	return repository.WithActor(ctx.Request.Context(), model.ActorAPI, ctx.ClientIP())
+}
+
+// @Summary Get message status history
+// @Description Get every status change of a message, oldest first, with who made it and the error if any
+// @Tags messages
+// @Produce json
+// @Param id path int true "Message ID"
+// @Success 200 {array} model.MessageEvent
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /messages/{id}/events [get]
+func (c *MessageController) GetMessageEvents(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid message ID"})
+		return
+	}
+
+	if _, err := c.repo.FindByID(ctx.Request.Context(), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Message not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get message"})
+		return
+	}
+
+	events, err := c.repo.FindEvents(ctx.Request.Context(), uint(id))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get message events"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, events)
+}
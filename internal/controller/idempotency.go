@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"auto-messaging/pkg/cache"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyKeyTTL         = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+
+	// idempotencyLockTTL bounds how long a key stays reserved by a request
+	// that never completes it, for example because its replica crashed
+	idempotencyLockTTL = time.Minute
+)
+
+var (
+	ErrIdempotencyKeyTooLong    = errors.New("Idempotency-Key must be at most 255 characters")
+	ErrIdempotencyKeyReused     = errors.New("Idempotency-Key was already used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this Idempotency-Key is still being processed")
+)
+
+// requestHash fingerprints a decoded request so that formatting differences
+// in the JSON body do not count as a different request
+func requestHash(req interface{}) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// beginIdempotent reserves the request's Idempotency-Key, if any. It returns the
+// key to complete once the request is done, or an empty key if the request has
+// no key. When handled is true a response has already been written, either a
+// replay of the original response or an error.
+func (c *MessageController) beginIdempotent(ctx *gin.Context, req interface{}) (key string, hash string, handled bool) {
+	key = ctx.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		return "", "", false
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: ErrIdempotencyKeyTooLong.Error()})
+		return "", "", true
+	}
+
+	hash, err := requestHash(req)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to hash request"})
+		return "", "", true
+	}
+
+	existing, err := c.cache.ReserveIdempotencyKey(context.WithoutCancel(ctx.Request.Context()), key, hash, idempotencyLockTTL)
+	if err != nil {
+		c.logger.Printf("Failed to reserve idempotency key: %v", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to check Idempotency-Key"})
+		return "", "", true
+	}
+	if existing == nil {
+		return key, hash, false
+	}
+
+	switch {
+	case existing.RequestHash != hash:
+		ctx.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: ErrIdempotencyKeyReused.Error()})
+	case existing.StatusCode == 0:
+		ctx.JSON(http.StatusConflict, ErrorResponse{Error: ErrIdempotencyKeyInProgress.Error()})
+	default:
+		ctx.Header(idempotencyReplayedHeader, "true")
+		ctx.Data(existing.StatusCode, "application/json; charset=utf-8", existing.Body)
+	}
+	return "", "", true
+}
+
+// completeIdempotent stores a successful response for key so that replays
+// return it, or releases key so that a failed or rejected request can be
+// retried, possibly after fixing it. It runs even if the client went away.
+func (c *MessageController) completeIdempotent(ctx *gin.Context, key, hash string, status int, body interface{}) {
+	if key == "" {
+		return
+	}
+	reqCtx := context.WithoutCancel(ctx.Request.Context())
+
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		if err := c.cache.ReleaseIdempotencyKey(reqCtx, key); err != nil {
+			c.logger.Printf("Failed to release idempotency key: %v", err)
+		}
+		return
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		c.logger.Printf("Failed to encode idempotent response: %v", err)
+		return
+	}
+	record := cache.IdempotencyRecord{RequestHash: hash, StatusCode: status, Body: raw}
+	if err := c.cache.CompleteIdempotencyKey(reqCtx, key, record, idempotencyKeyTTL); err != nil {
+		c.logger.Printf("Failed to store idempotent response: %v", err)
+	}
+}
@@ -8,54 +8,177 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 
+	"auto-messaging/config"
 	"auto-messaging/internal/client"
 	"auto-messaging/internal/model"
 	"auto-messaging/internal/repository"
 	"auto-messaging/pkg/cache"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 var (
 	ErrContentTooLong = errors.New("message content exceeds maximum length")
-)
-
-const (
-	maxContentLength = 500
-	batchSize        = 2
-	processInterval  = 2 * time.Minute
+	ErrInvalidTTL     = errors.New("ttl must be a positive duration")
+	ErrInvalidExpiry  = errors.New("expires_at must be after scheduled_at")
+	ErrExpiryConflict = errors.New("only one of expires_at and ttl may be set")
+	ErrExpired        = errors.New("message expired before it could be sent")
+	ErrUnavailable    = errors.New("send deferred until a provider of the message admits a probe")
+
+	ErrMissingSendTime     = errors.New("one of scheduled_at and local_time is required")
+	ErrSendTimeConflict    = errors.New("only one of scheduled_at and local_time may be set")
+	ErrTimezoneNeedsLocal  = errors.New("timezone requires local_time")
+	ErrNoRecipientTimezone = errors.New("recipient has no time zone, set one under /recipients or pass timezone")
+
+	// errRecipientLookup wraps database errors met while looking up the
+	// recipient's time zone, which are not the client's fault
+	errRecipientLookup = errors.New("failed to look up recipient")
 )
 
 // MessageController handles HTTP requests for messages
 type MessageController struct {
-	repo    repository.MessageRepository
-	webhook client.WebhookClient
-	cache   cache.MessageCache
-	stopCh  chan struct{}
-	logger  *log.Logger
+	repo       repository.MessageRepository
+	providers  *client.Registry
+	cache      cache.MessageCache
+	stats      stats
+	settingsCh chan struct{}
+	wakeCh     chan struct{}
+	logger     *log.Logger
+
+	runMu  sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// planMu guards plannedRun, the time the dispatcher sleeps until
+	planMu     sync.Mutex
+	plannedRun time.Time
+
+	mu       sync.RWMutex
+	settings config.Dispatcher
+	throttle throttle
 }
 
 // NewMessageController creates a new MessageController
-func NewMessageController(repo repository.MessageRepository, webhook client.WebhookClient, cache cache.MessageCache, logger *log.Logger) *MessageController {
+func NewMessageController(repo repository.MessageRepository, providers *client.Registry, cache cache.MessageCache, settings config.Dispatcher, logger *log.Logger) *MessageController {
 	if logger == nil {
 		logger = log.New(os.Stdout, "[MessageController] ", log.LstdFlags)
 	}
 	return &MessageController{
-		repo:    repo,
-		webhook: webhook,
-		cache:   cache,
-		stopCh:  make(chan struct{}),
-		logger:  logger,
+		repo:       repo,
+		providers:  providers,
+		cache:      cache,
+		settingsCh: make(chan struct{}, 1),
+		wakeCh:     make(chan struct{}, 1),
+		logger:     logger,
+		settings:   withDefaults(settings),
 	}
 }
 
 // CreateMessageRequest represents the request body for creating a message
 type CreateMessageRequest struct {
-	Content     string    `json:"content" binding:"required"`
-	To          string    `json:"to" binding:"required,email"`
-	ScheduledAt time.Time `json:"scheduled_at" binding:"required"`
+	Content string `json:"content" binding:"required"`
+	To      string `json:"to" binding:"required"`
+	// ScheduledAt is when to send the message, exclusive with LocalTime
+	ScheduledAt time.Time `json:"scheduled_at"`
+	// LocalTime is when to send the message as wall clock time without UTC
+	// offset, read in Timezone or, if that is empty, in the recipient's time
+	// zone. Messages in the recipient's time zone follow it when it changes.
+	LocalTime string `json:"local_time,omitempty" example:"2024-04-26T09:00:00"`
+	// Timezone is the IANA time zone of LocalTime
+	Timezone string `json:"timezone,omitempty" example:"America/New_York"`
+	// Channel is one of email, sms, push or generic, email if empty
+	Channel string `json:"channel,omitempty" enums:"email,sms,push,generic" example:"email"`
+	// Provider names the delivery provider, the channel's provider if empty
+	Provider string `json:"provider,omitempty" example:"webhook"`
+	// Priority is one of critical, high, normal or low, normal if empty
+	Priority string `json:"priority,omitempty" enums:"critical,high,normal,low" example:"high"`
+	// ExpiresAt is when the message stops being worth sending, it never expires if empty
+	ExpiresAt *time.Time `json:"expires_at,omitempty" example:"2024-04-26T12:00:00Z"`
+	// TTL sets ExpiresAt relative to ScheduledAt, as a duration such as 6h
+	TTL string `json:"ttl,omitempty" example:"2h"`
+
+	// recipientTimezone is set once LocalTime was resolved in the recipient's time zone
+	recipientTimezone bool
+}
+
+// validate checks the parts of a request that binding cannot and
+// normalizes its channel, recipient, priority, send time and expiry
+func (c *MessageController) validate(ctx context.Context, req *CreateMessageRequest) error {
+	if req.Channel == "" {
+		req.Channel = model.ChannelEmail
+	}
+	if !model.ValidChannel(req.Channel) {
+		return fmt.Errorf("%w: %s", model.ErrUnknownChannel, req.Channel)
+	}
+
+	to, err := model.NormalizeRecipient(req.Channel, req.To)
+	if err != nil {
+		return err
+	}
+	req.To = to
+
+	priority, err := model.NormalizePriority(req.Priority)
+	if err != nil {
+		return err
+	}
+	req.Priority = priority
+
+	if err := c.resolveLocalTime(ctx, req); err != nil {
+		return err
+	}
+
+	if req.TTL != "" {
+		if req.ExpiresAt != nil {
+			return ErrExpiryConflict
+		}
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return ErrInvalidTTL
+		}
+		expiresAt := req.ScheduledAt.Add(ttl)
+		req.ExpiresAt = &expiresAt
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(req.ScheduledAt) {
+		return ErrInvalidExpiry
+	}
+
+	if limit := c.providers.MaxLength(req.Channel); utf8.RuneCountInString(req.Content) > limit {
+		return fmt.Errorf("%w: %d characters allowed on %s", ErrContentTooLong, limit, req.Channel)
+	}
+	if req.Provider != "" && !c.providers.Has(req.Provider) {
+		return fmt.Errorf("%w: %s", client.ErrUnknownProvider, req.Provider)
+	}
+	return nil
+}
+
+// validateRequest validates req, answering with an error if it is invalid
+func (c *MessageController) validateRequest(ctx *gin.Context, req *CreateMessageRequest) bool {
+	status, resp := c.validationError(ctx.Request.Context(), req)
+	if resp != nil {
+		ctx.JSON(status, *resp)
+		return false
+	}
+	return true
+}
+
+// validationError validates req and returns the status and error to answer
+// with if it is invalid, or a nil error if it is valid
+func (c *MessageController) validationError(ctx context.Context, req *CreateMessageRequest) (int, *ErrorResponse) {
+	err := c.validate(ctx, req)
+	switch {
+	case err == nil:
+		return http.StatusOK, nil
+	case errors.Is(err, errRecipientLookup):
+		c.logger.Printf("%v", err)
+		return http.StatusInternalServerError, &ErrorResponse{Error: "Failed to look up recipient"}
+	default:
+		return http.StatusBadRequest, &ErrorResponse{Error: err.Error()}
+	}
 }
 
 // @Summary Create a new message
@@ -63,9 +186,12 @@ type CreateMessageRequest struct {
 // @Tags messages
 // @Accept json
 // @Produce json
+// @Param Idempotency-Key header string false "Key that makes retries of this request return the original response"
 // @Param message body CreateMessageRequest true "Message details"
 // @Success 201 {object} model.Message
 // @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /messages [post]
 func (c *MessageController) CreateMessage(ctx *gin.Context) {
@@ -75,23 +201,44 @@ func (c *MessageController) CreateMessage(ctx *gin.Context) {
 		return
 	}
 
-	if len(req.Content) > maxContentLength {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: ErrContentTooLong.Error()})
+	// Replays of a request with the same Idempotency-Key get the original
+	// response, even if the recipient it was validated against changed since
+	key, hash, handled := c.beginIdempotent(ctx, req)
+	if handled {
+		return
+	}
+
+	if status, resp := c.validationError(ctx.Request.Context(), &req); resp != nil {
+		c.completeIdempotent(ctx, key, hash, status, resp)
+		ctx.JSON(status, *resp)
 		return
 	}
 
 	message := &model.Message{
 		Content:     req.Content,
 		To:          req.To,
+		Channel:     req.Channel,
 		ScheduledAt: req.ScheduledAt,
+		Provider:    req.Provider,
+		Priority:    req.Priority,
+		ExpiresAt:   req.ExpiresAt,
 		Status:      model.MessageStatusPending,
+
+		LocalTime:         req.LocalTime,
+		Timezone:          req.Timezone,
+		RecipientTimezone: req.recipientTimezone,
 	}
 
-	if err := c.repo.Create(context.Background(), message); err != nil {
+	// A client that gives up waiting must not cut the creation short and
+	// leave its Idempotency-Key without the response to replay
+	if err := c.repo.Create(context.WithoutCancel(apiContext(ctx)), message); err != nil {
+		c.completeIdempotent(ctx, key, hash, http.StatusInternalServerError, nil)
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create message"})
 		return
 	}
+	c.schedule(message.ScheduledAt)
 
+	c.completeIdempotent(ctx, key, hash, http.StatusCreated, message)
 	ctx.JSON(http.StatusCreated, message)
 }
 
@@ -128,7 +275,7 @@ func (c *MessageController) GetMessage(ctx *gin.Context) {
 		return
 	}
 
-	message, err := c.repo.FindByID(context.Background(), uint(id))
+	message, err := c.repo.FindByID(ctx.Request.Context(), uint(id))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Message not found"})
 		return
@@ -147,6 +294,7 @@ func (c *MessageController) GetMessage(ctx *gin.Context) {
 // @Success 200 {object} model.Message
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /messages/{id} [put]
 func (c *MessageController) UpdateMessage(ctx *gin.Context) {
@@ -162,18 +310,39 @@ func (c *MessageController) UpdateMessage(ctx *gin.Context) {
 		return
 	}
 
-	message, err := c.repo.FindByID(context.Background(), uint(id))
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Message not found"})
+	if !c.validateRequest(ctx, &req) {
 		return
 	}
 
-	message.Content = req.Content
-	message.To = req.To
-	message.ScheduledAt = req.ScheduledAt
+	changes := &model.Message{
+		Content:     req.Content,
+		To:          req.To,
+		Channel:     req.Channel,
+		ScheduledAt: req.ScheduledAt,
+		Provider:    req.Provider,
+		Priority:    req.Priority,
+		ExpiresAt:   req.ExpiresAt,
 
-	if err := c.repo.UpdateStatus(context.Background(), uint(id), message.Status); err != nil {
-		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update message"})
+		LocalTime:         req.LocalTime,
+		Timezone:          req.Timezone,
+		RecipientTimezone: req.recipientTimezone,
+	}
+	if err := c.repo.UpdatePending(apiContext(ctx), uint(id), changes); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Message not found"})
+		case errors.Is(err, repository.ErrNotPending):
+			ctx.JSON(http.StatusConflict, ErrorResponse{Error: "Only pending messages can be updated"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update message"})
+		}
+		return
+	}
+	c.schedule(changes.ScheduledAt)
+
+	message, err := c.repo.FindByID(ctx.Request.Context(), uint(id))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get message"})
 		return
 	}
 
@@ -188,6 +357,7 @@ func (c *MessageController) UpdateMessage(ctx *gin.Context) {
 // @Success 204 "No Content"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Router /messages/{id} [delete]
 func (c *MessageController) DeleteMessage(ctx *gin.Context) {
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
@@ -196,8 +366,16 @@ func (c *MessageController) DeleteMessage(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.repo.UpdateStatus(context.Background(), uint(id), model.MessageStatusCancelled); err != nil {
-		ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Message not found"})
+	if err := c.repo.UpdateStatus(apiContext(ctx), uint(id), model.MessageStatusCancelled); err != nil {
+		var transitionErr *model.InvalidTransitionError
+		switch {
+		case errors.As(err, &transitionErr):
+			ctx.JSON(http.StatusConflict, ErrorResponse{Error: transitionErr.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Message not found"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to cancel message"})
+		}
 		return
 	}
 
@@ -234,99 +412,244 @@ func (c *MessageController) StopMessaging(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, MessageResponse{Message: "Messaging stopped"})
 }
 
-// Start begins processing scheduled messages
+// Start begins processing scheduled messages. Starting a running dispatcher is a no-op.
 func (c *MessageController) Start() error {
-	// Create a channel to track goroutine startup
-	started := make(chan struct{})
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
 
-	go func() {
-		// Signal that the goroutine has started
-		close(started)
+	if c.cancel != nil {
+		return nil
+	}
 
-		// Process messages immediately when started
-		if err := c.processMessages(); err != nil {
-			c.logger.Printf("Error processing messages: %v", err)
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	c.cancel = cancel
+	c.done = done
 
-		ticker := time.NewTicker(processInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				if err := c.processMessages(); err != nil {
-					c.logger.Printf("Error processing messages: %v", err)
-				}
-			case <-c.stopCh:
-				return
-			}
-		}
+	go func() {
+		defer close(done)
+		c.run(ctx)
 	}()
 
-	// Wait for goroutine to start
-	<-started
 	return nil
 }
 
-// processMessages handles the message processing logic
-func (c *MessageController) processMessages() error {
-	messages, err := c.repo.FindPendingBefore(context.Background(), time.Now(), batchSize)
+// processMessages claims one batch of due messages and sends it
+func (c *MessageController) processMessages(ctx context.Context) (batchResult, error) {
+	settings := c.Settings()
+	ctx = repository.WithActor(ctx, model.ActorDispatcher, settings.InstanceID)
+
+	// Hand back messages stranded by replicas that died mid-send
+	recovered, err := c.repo.RecoverExpiredLeases(ctx, time.Now())
 	if err != nil {
-		return fmt.Errorf("error finding pending messages: %v", err)
+		return batchResult{}, fmt.Errorf("error recovering expired leases: %v", err)
 	}
-
-	for _, msg := range messages {
-		if err := c.processMessage(msg); err != nil {
-			c.logger.Printf("Failed to process message %d: %v", msg.ID, err)
-			continue
-		}
+	if recovered > 0 {
+		c.logger.Printf("Recovered %d messages with expired leases", recovered)
 	}
-	return nil
-}
 
-// processMessage handles the message processing logic for a single message
-func (c *MessageController) processMessage(msg *model.Message) error {
-	// Check if message is already processed
-	if msg.Status != model.MessageStatusPending {
-		return nil
+	// Overdue messages are expired even while no provider is available
+	expired, err := c.repo.ExpireOverdue(ctx, time.Now())
+	if err != nil {
+		return batchResult{}, fmt.Errorf("error expiring overdue messages: %v", err)
+	}
+	if expired > 0 {
+		c.logger.Printf("Expired %d messages that were not sent in time", expired)
 	}
 
-	// Send message via webhook
-	req := &model.WebhookRequest{
-		Content: msg.Content,
-		To:      msg.To,
+	c.materializeSchedules(ctx, time.Now())
+
+	// Claiming messages only to skip them would just churn the database
+	if until, paused := c.providers.Unavailable(); paused {
+		c.logger.Printf("Dispatch paused, every provider circuit is open until %s", until.Format(time.RFC3339))
+		return batchResult{}, nil
 	}
-	resp, err := c.webhook.SendMessage(req)
+
+	messages, err := c.repo.ClaimPending(ctx, settings.InstanceID, time.Now(), settings.BatchSize,
+		fairSlots(settings.BatchSize, settings.FairShare), settings.LeaseDuration)
 	if err != nil {
-		return fmt.Errorf("failed to send message: %v", err)
+		return batchResult{}, fmt.Errorf("error claiming pending messages: %v", err)
+	}
+	if len(messages) == 0 {
+		return batchResult{}, nil
 	}
 
-	// Update message ID
-	if err := c.repo.UpdateMessageID(context.Background(), msg.ID, resp.MessageID); err != nil {
-		return fmt.Errorf("failed to update message ID: %v", err)
+	result := c.sendBatch(ctx, messages, settings.MaxInFlight, settings.RateLimit)
+	c.logger.Printf("Processed batch of %d messages in %s: %d sent, %d failed, %d deferred, %d expired, %d skipped",
+		len(messages), result.Duration.Round(time.Millisecond), result.Sent, result.Failed, result.Deferred, result.Expired, result.Skipped)
+	return result, nil
+}
+
+// processMessage handles the message processing logic for a single message.
+// Cancelling ctx interrupts the send; the message is then left claimed and
+// ctx.Err() is returned so that the caller can release it. The same applies
+// when every provider of the message has an open circuit and none is about to
+// admit a probe, in which case client.ErrNoProviderAvailable is returned;
+// otherwise the message is deferred until the first probe and ErrUnavailable
+// is returned. A message over its rate limits is deferred until it may be sent
+// and ErrThrottled is returned. A message whose
+// lease was lost is not sent and repository.ErrLeaseLost is returned.
+func (c *MessageController) processMessage(ctx context.Context, msg *model.Message) error {
+	// Only messages claimed by this dispatcher are sent
+	if msg.Status != model.MessageStatusProcessing {
+		return nil
+	}
+	ctx = repository.WithActor(ctx, model.ActorDispatcher, msg.ClaimedBy)
+
+	// A previous run may have been acknowledged by the provider but crashed
+	// before recording it, in which case the message must not be sent again
+	var ack *cache.DeliveryAck
+	var err error
+	if msg.DeliveryKey != "" {
+		ack, err = c.cache.GetDeliveryAck(ctx, msg.DeliveryKey)
+		if err != nil {
+			c.logger.Printf("Failed to look up delivery acknowledgement for message %d: %v", msg.ID, err)
+		}
 	}
 
-	// Update message status
+	var resp *model.WebhookResponse
+	var deliveredBy string
 	now := time.Now()
-	if err := c.repo.UpdateStatus(context.Background(), msg.ID, model.MessageStatusSent); err != nil {
-		return fmt.Errorf("failed to update message status: %v", err)
+	if ack != nil {
+		c.logger.Printf("Message %d was already delivered as %s, skipping resend", msg.ID, ack.MessageID)
+		resp = &model.WebhookResponse{MessageID: ack.MessageID}
+		deliveredBy = ack.Provider
+		now = ack.SentAt
+	} else {
+		// The message may have expired while it waited in its batch
+		if msg.ExpiresAt != nil && !now.Before(*msg.ExpiresAt) {
+			if err := c.repo.MarkExpired(ctx, msg.ID, msg.ClaimedBy); err != nil {
+				return fmt.Errorf("failed to mark message expired: %w", err)
+			}
+			c.logger.Printf("Message %d expired at %s before it could be sent", msg.ID, msg.ExpiresAt.Format(time.RFC3339))
+			return ErrExpired
+		}
+
+		// Sends over the shared quotas wait for their turn instead of failing
+		if wait := c.waitForQuota(ctx, msg); wait > 0 {
+			if err := c.repo.DeferClaim(ctx, msg.ID, msg.ClaimedBy, time.Now().Add(wait), ErrThrottled.Error()); err != nil {
+				return fmt.Errorf("failed to defer rate limited message: %w", err)
+			}
+			return ErrThrottled
+		}
+
+		// The batch may have outlasted the lease, in which case another
+		// replica may have taken the message over and must be left to send it
+		if err := c.renewLease(ctx, msg); err != nil {
+			return err
+		}
+
+		// Send message via the first healthy provider of its route
+		req := &model.WebhookRequest{
+			Content:        msg.Content,
+			To:             msg.To,
+			Channel:        msg.Channel,
+			IdempotencyKey: msg.DeliveryKey,
+		}
+		resp, deliveredBy, err = c.providers.Send(ctx, c.providers.Route(msg.Channel, msg.Provider), req)
+		if err != nil {
+			// An interrupted send is not the provider's fault and does not use up an attempt
+			if ctx.Err() != nil {
+				return fmt.Errorf("send interrupted: %w", ctx.Err())
+			}
+			// Neither is a send that no provider was available for
+			if errors.Is(err, client.ErrNoProviderAvailable) {
+				return c.deferUnavailable(ctx, msg, err)
+			}
+			if failErr := c.handleSendFailure(ctx, msg, err); failErr != nil {
+				c.logger.Printf("Failed to record failed attempt for message %d: %v", msg.ID, failErr)
+			}
+			return fmt.Errorf("failed to send message: %v", err)
+		}
+
+		// The provider has accepted the message, so the bookkeeping below must
+		// not be abandoned half way if the dispatcher is stopped meanwhile
+		ctx = context.WithoutCancel(ctx)
+		// Postgres keeps microseconds, round so that the cache has the same time
+		now = time.Now().Truncate(time.Microsecond)
+		if msg.DeliveryKey != "" {
+			ack := cache.DeliveryAck{MessageID: resp.MessageID, Provider: deliveredBy, SentAt: now}
+			if err := c.cache.StoreDeliveryAck(ctx, msg.DeliveryKey, ack); err != nil {
+				c.logger.Printf("Failed to store delivery acknowledgement for message %d: %v", msg.ID, err)
+			}
+		}
 	}
 
-	// Update sent time
-	if err := c.repo.UpdateSentAt(context.Background(), msg.ID, now); err != nil {
-		return fmt.Errorf("failed to update sent time: %v", err)
+	// Record message ID, provider, sent status and sent time in one write
+	if err := c.repo.MarkSent(ctx, msg.ID, msg.ClaimedBy, resp.MessageID, deliveredBy, now); err != nil {
+		return fmt.Errorf("failed to mark message as sent: %w", err)
+	}
+
+	// Keep the sent time at hand for lookups by provider message ID
+	if resp.HasMessageID() {
+		if err := c.cache.StoreMessageID(ctx, resp.MessageID, now); err != nil {
+			c.logger.Printf("Failed to cache sent time of message %d: %v", msg.ID, err)
+		}
 	}
 
 	return nil
 }
 
-// Stop halts message processing
+// Stop halts message processing. It cancels all sends, including the ones in
+// flight, and waits for the dispatcher to release their messages.
 func (c *MessageController) Stop() error {
-	c.stopCh <- struct{}{}
+	c.runMu.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel, c.done = nil, nil
+	c.runMu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	<-done
 	return nil
 }
 
+// Running reports whether the dispatcher loop is active
+func (c *MessageController) Running() bool {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	return c.cancel != nil
+}
+
 // GetSentMessages retrieves all sent messages
 func (c *MessageController) GetSentMessages() ([]*model.Message, error) {
 	return c.repo.FindByStatus("sent")
 }
+
+// renewLease extends the lease of msg before it is sent once less than half of
+// the lease is left, so that it cannot run out while the send is in flight
+func (c *MessageController) renewLease(ctx context.Context, msg *model.Message) error {
+	if msg.LeaseExpiresAt == nil {
+		return nil
+	}
+	lease := c.Settings().LeaseDuration
+	now := time.Now()
+	if msg.LeaseExpiresAt.Sub(now) >= lease/2 {
+		return nil
+	}
+
+	until := now.Add(lease)
+	if err := c.repo.RenewLease(ctx, msg.ID, msg.ClaimedBy, until); err != nil {
+		return fmt.Errorf("failed to renew lease: %w", err)
+	}
+	msg.LeaseExpiresAt = &until
+	return nil
+}
+
+// deferUnavailable hands a message whose providers all have an open circuit
+// back to pending until the first of them admits a probe, so that it is not
+// claimed and released again on every run meanwhile. Without a probe time err
+// is returned as is.
+func (c *MessageController) deferUnavailable(ctx context.Context, msg *model.Message, err error) error {
+	var sendErr *client.SendError
+	if !errors.As(err, &sendErr) || sendErr.RetryAfter <= 0 {
+		return err
+	}
+	if deferErr := c.repo.DeferClaim(ctx, msg.ID, msg.ClaimedBy, time.Now().Add(sendErr.RetryAfter), err.Error()); deferErr != nil {
+		return fmt.Errorf("failed to defer message without available provider: %w", deferErr)
+	}
+	return ErrUnavailable
+}
@@ -2,22 +2,43 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"auto-messaging/config"
+	"auto-messaging/internal/client"
 	"auto-messaging/internal/model"
+	"auto-messaging/internal/repository"
+	"auto-messaging/pkg/cache"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // MockWebhookClient implements the WebhookClient interface for testing
 type mockWebhookClient struct {
-	sendMessageFunc func(req *model.WebhookRequest) (*model.WebhookResponse, error)
+	sendMessageFunc func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error)
+}
+
+func (m *mockWebhookClient) SendMessage(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+	return m.sendMessageFunc(ctx, req)
 }
 
-func (m *mockWebhookClient) SendMessage(req *model.WebhookRequest) (*model.WebhookResponse, error) {
-	return m.sendMessageFunc(req)
+// testProviders returns a registry with p as its only, default provider
+func testProviders(p client.Provider) *client.Registry {
+	providers := client.NewRegistry(client.DefaultProviderName)
+	providers.Register(client.DefaultProviderName, p)
+	return providers
 }
 
 // MockMessageRepository implements the MessageRepository interface for testing
@@ -27,9 +48,35 @@ type mockMessageRepository struct {
 	findByIDFunc          func(ctx context.Context, id uint) (*model.Message, error)
 	updateStatusFunc      func(ctx context.Context, id uint, status string) error
 	findPendingBeforeFunc func(ctx context.Context, before time.Time, limit int) ([]*model.Message, error)
+	nextDueAtFunc         func(ctx context.Context) (*time.Time, error)
 	findByStatusFunc      func(status string) ([]*model.Message, error)
-	updateMessageIDFunc   func(ctx context.Context, id uint, messageID string) error
-	updateSentAtFunc      func(ctx context.Context, id uint, sentAt time.Time) error
+	findByMessageIDFunc   func(ctx context.Context, messageID string) (*model.Message, error)
+	updatePendingFunc     func(ctx context.Context, id uint, changes *model.Message) error
+	markSentFunc          func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error
+	claimPendingFunc      func(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error)
+	releaseClaimsFunc     func(ctx context.Context, owner string, ids []uint) error
+	renewLeaseFunc        func(ctx context.Context, id uint, owner string, until time.Time) error
+	rescheduleAttemptFunc func(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error
+	deferClaimFunc        func(ctx context.Context, id uint, owner string, until time.Time, reason string) error
+	markFailedFunc        func(ctx context.Context, id uint, owner string, lastError string) error
+	markExpiredFunc       func(ctx context.Context, id uint, owner string) error
+	expireOverdueFunc     func(ctx context.Context, now time.Time) (int64, error)
+	countByStatusFunc     func(ctx context.Context) (map[string]int64, error)
+	findDeadLettersFunc   func(ctx context.Context, filter repository.DeadLetterFilter) ([]*model.Message, error)
+	requeueDeadLetterFunc func(ctx context.Context, id uint) error
+	requeueDeadLettersFn  func(ctx context.Context, filter repository.DeadLetterFilter) (int64, error)
+	findEventsFunc        func(ctx context.Context, messageID uint) ([]model.MessageEvent, error)
+	createScheduleFunc    func(ctx context.Context, schedule *model.RecurringSchedule) error
+	findSchedulesFunc     func(ctx context.Context) ([]*model.RecurringSchedule, error)
+	findScheduleByIDFunc  func(ctx context.Context, id uint) (*model.RecurringSchedule, error)
+	updateScheduleFunc    func(ctx context.Context, schedule *model.RecurringSchedule, reason string) error
+	deleteScheduleFunc    func(ctx context.Context, id uint) error
+	findDueSchedulesFunc  func(ctx context.Context, before time.Time, limit int) ([]*model.RecurringSchedule, error)
+	advanceScheduleFunc   func(ctx context.Context, schedule *model.RecurringSchedule, messages []*model.Message) error
+	findRecipientsFunc    func(ctx context.Context) ([]*model.Recipient, error)
+	findRecipientFunc     func(ctx context.Context, channel, to string) (*model.Recipient, error)
+	saveRecipientFunc     func(ctx context.Context, recipient *model.Recipient) (int64, error)
+	deleteRecipientFunc   func(ctx context.Context, id uint) error
 	messages              map[uint]*model.Message
 }
 
@@ -56,30 +103,229 @@ func (m *mockMessageRepository) FindPendingBefore(ctx context.Context, before ti
 	return []*model.Message{}, nil
 }
 
+func (m *mockMessageRepository) NextDueAt(ctx context.Context) (*time.Time, error) {
+	if m.nextDueAtFunc != nil {
+		return m.nextDueAtFunc(ctx)
+	}
+	return nil, nil
+}
+
 func (m *mockMessageRepository) FindByStatus(status string) ([]*model.Message, error) {
 	return m.findByStatusFunc(status)
 }
 
-func (m *mockMessageRepository) UpdateMessageID(ctx context.Context, id uint, messageID string) error {
-	return m.updateMessageIDFunc(ctx, id, messageID)
+func (m *mockMessageRepository) FindByMessageID(ctx context.Context, messageID string) (*model.Message, error) {
+	return m.findByMessageIDFunc(ctx, messageID)
+}
+
+func (m *mockMessageRepository) UpdatePending(ctx context.Context, id uint, changes *model.Message) error {
+	return m.updatePendingFunc(ctx, id, changes)
+}
+
+func (m *mockMessageRepository) MarkSent(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+	return m.markSentFunc(ctx, id, owner, messageID, deliveredBy, sentAt)
+}
+
+func (m *mockMessageRepository) ClaimPending(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error) {
+	if m.claimPendingFunc != nil {
+		return m.claimPendingFunc(ctx, owner, before, limit, fair, lease)
+	}
+	return []*model.Message{}, nil
+}
+
+func (m *mockMessageRepository) ReleaseClaims(ctx context.Context, owner string, ids []uint) error {
+	if m.releaseClaimsFunc != nil {
+		return m.releaseClaimsFunc(ctx, owner, ids)
+	}
+	return nil
+}
+
+func (m *mockMessageRepository) RenewLease(ctx context.Context, id uint, owner string, until time.Time) error {
+	if m.renewLeaseFunc != nil {
+		return m.renewLeaseFunc(ctx, id, owner, until)
+	}
+	return nil
+}
+
+func (m *mockMessageRepository) RecoverExpiredLeases(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockMessageRepository) RescheduleAttempt(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error {
+	if m.rescheduleAttemptFunc != nil {
+		return m.rescheduleAttemptFunc(ctx, id, owner, lastError, nextAttemptAt)
+	}
+	return nil
+}
+
+func (m *mockMessageRepository) DeferClaim(ctx context.Context, id uint, owner string, until time.Time, reason string) error {
+	if m.deferClaimFunc != nil {
+		return m.deferClaimFunc(ctx, id, owner, until, reason)
+	}
+	return nil
+}
+
+func (m *mockMessageRepository) MarkExpired(ctx context.Context, id uint, owner string) error {
+	if m.markExpiredFunc != nil {
+		return m.markExpiredFunc(ctx, id, owner)
+	}
+	return nil
+}
+
+func (m *mockMessageRepository) ExpireOverdue(ctx context.Context, now time.Time) (int64, error) {
+	if m.expireOverdueFunc != nil {
+		return m.expireOverdueFunc(ctx, now)
+	}
+	return 0, nil
+}
+
+func (m *mockMessageRepository) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	if m.countByStatusFunc != nil {
+		return m.countByStatusFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *mockMessageRepository) MarkFailed(ctx context.Context, id uint, owner string, lastError string) error {
+	if m.markFailedFunc != nil {
+		return m.markFailedFunc(ctx, id, owner, lastError)
+	}
+	return nil
+}
+
+func (m *mockMessageRepository) FindDeadLetters(ctx context.Context, filter repository.DeadLetterFilter) ([]*model.Message, error) {
+	return m.findDeadLettersFunc(ctx, filter)
+}
+
+func (m *mockMessageRepository) RequeueDeadLetter(ctx context.Context, id uint) error {
+	return m.requeueDeadLetterFunc(ctx, id)
+}
+
+func (m *mockMessageRepository) RequeueDeadLetters(ctx context.Context, filter repository.DeadLetterFilter) (int64, error) {
+	return m.requeueDeadLettersFn(ctx, filter)
+}
+
+func (m *mockMessageRepository) FindEvents(ctx context.Context, messageID uint) ([]model.MessageEvent, error) {
+	return m.findEventsFunc(ctx, messageID)
+}
+
+func (m *mockMessageRepository) CreateSchedule(ctx context.Context, schedule *model.RecurringSchedule) error {
+	return m.createScheduleFunc(ctx, schedule)
+}
+
+func (m *mockMessageRepository) FindSchedules(ctx context.Context) ([]*model.RecurringSchedule, error) {
+	return m.findSchedulesFunc(ctx)
+}
+
+func (m *mockMessageRepository) FindScheduleByID(ctx context.Context, id uint) (*model.RecurringSchedule, error) {
+	return m.findScheduleByIDFunc(ctx, id)
+}
+
+func (m *mockMessageRepository) UpdateSchedule(ctx context.Context, schedule *model.RecurringSchedule, reason string) error {
+	return m.updateScheduleFunc(ctx, schedule, reason)
+}
+
+func (m *mockMessageRepository) DeleteSchedule(ctx context.Context, id uint) error {
+	return m.deleteScheduleFunc(ctx, id)
+}
+
+func (m *mockMessageRepository) FindDueSchedules(ctx context.Context, before time.Time, limit int) ([]*model.RecurringSchedule, error) {
+	if m.findDueSchedulesFunc != nil {
+		return m.findDueSchedulesFunc(ctx, before, limit)
+	}
+	return nil, nil
+}
+
+func (m *mockMessageRepository) AdvanceSchedule(ctx context.Context, schedule *model.RecurringSchedule, messages []*model.Message) error {
+	if m.advanceScheduleFunc != nil {
+		return m.advanceScheduleFunc(ctx, schedule, messages)
+	}
+	return nil
+}
+
+func (m *mockMessageRepository) FindRecipients(ctx context.Context) ([]*model.Recipient, error) {
+	return m.findRecipientsFunc(ctx)
+}
+
+func (m *mockMessageRepository) FindRecipient(ctx context.Context, channel, to string) (*model.Recipient, error) {
+	if m.findRecipientFunc != nil {
+		return m.findRecipientFunc(ctx, channel, to)
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockMessageRepository) SaveRecipient(ctx context.Context, recipient *model.Recipient) (int64, error) {
+	return m.saveRecipientFunc(ctx, recipient)
 }
 
-func (m *mockMessageRepository) UpdateSentAt(ctx context.Context, id uint, sentAt time.Time) error {
-	return m.updateSentAtFunc(ctx, id, sentAt)
+func (m *mockMessageRepository) DeleteRecipient(ctx context.Context, id uint) error {
+	return m.deleteRecipientFunc(ctx, id)
 }
 
 // MockMessageCache implements the MessageCache interface for testing
 type mockMessageCache struct {
 	storeMessageIDFunc     func(ctx context.Context, messageID string, sentAt time.Time) error
 	getMessageSentTimeFunc func(ctx context.Context, messageID string) (*time.Time, error)
+	idempotency            map[string]cache.IdempotencyRecord
+	deliveryAcks           map[string]cache.DeliveryAck
+	takeTokensFunc         func(ctx context.Context, buckets []cache.RateBucket) (time.Duration, error)
 }
 
 func (m *mockMessageCache) StoreMessageID(ctx context.Context, messageID string, sentAt time.Time) error {
-	return m.storeMessageIDFunc(ctx, messageID, sentAt)
+	if m.storeMessageIDFunc != nil {
+		return m.storeMessageIDFunc(ctx, messageID, sentAt)
+	}
+	return nil
 }
 
 func (m *mockMessageCache) GetMessageSentTime(ctx context.Context, messageID string) (*time.Time, error) {
-	return m.getMessageSentTimeFunc(ctx, messageID)
+	if m.getMessageSentTimeFunc != nil {
+		return m.getMessageSentTimeFunc(ctx, messageID)
+	}
+	return nil, nil
+}
+
+func (m *mockMessageCache) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, ttl time.Duration) (*cache.IdempotencyRecord, error) {
+	if m.idempotency == nil {
+		m.idempotency = make(map[string]cache.IdempotencyRecord)
+	}
+	if record, ok := m.idempotency[key]; ok {
+		return &record, nil
+	}
+	m.idempotency[key] = cache.IdempotencyRecord{RequestHash: requestHash}
+	return nil, nil
+}
+
+func (m *mockMessageCache) CompleteIdempotencyKey(ctx context.Context, key string, record cache.IdempotencyRecord, ttl time.Duration) error {
+	m.idempotency[key] = record
+	return nil
+}
+
+func (m *mockMessageCache) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	delete(m.idempotency, key)
+	return nil
+}
+
+func (m *mockMessageCache) StoreDeliveryAck(ctx context.Context, deliveryKey string, ack cache.DeliveryAck) error {
+	if m.deliveryAcks == nil {
+		m.deliveryAcks = make(map[string]cache.DeliveryAck)
+	}
+	m.deliveryAcks[deliveryKey] = ack
+	return nil
+}
+
+func (m *mockMessageCache) GetDeliveryAck(ctx context.Context, deliveryKey string) (*cache.DeliveryAck, error) {
+	if ack, ok := m.deliveryAcks[deliveryKey]; ok {
+		return &ack, nil
+	}
+	return nil, nil
+}
+
+func (m *mockMessageCache) TakeTokens(ctx context.Context, buckets []cache.RateBucket) (time.Duration, error) {
+	if m.takeTokensFunc != nil {
+		return m.takeTokensFunc(ctx, buckets)
+	}
+	return 0, nil
 }
 
 func TestMessageController_CreateMessage(t *testing.T) {
@@ -123,8 +369,9 @@ func TestMessageController_CreateMessage(t *testing.T) {
 
 			controller := NewMessageController(
 				repo,
-				&mockWebhookClient{},
+				testProviders(&mockWebhookClient{}),
 				&mockMessageCache{},
+				config.Dispatcher{},
 				nil,
 			)
 
@@ -150,7 +397,7 @@ func TestMessageController_ProcessMessage(t *testing.T) {
 				ID:          1,
 				Content:     "Test message",
 				To:          "test@example.com",
-				Status:      model.MessageStatusPending,
+				Status:      model.MessageStatusProcessing,
 				ScheduledAt: time.Now().Add(-1 * time.Hour), // Past time
 			},
 			webhookResp: &model.WebhookResponse{
@@ -165,7 +412,7 @@ func TestMessageController_ProcessMessage(t *testing.T) {
 				ID:          1,
 				Content:     "Test message",
 				To:          "test@example.com",
-				Status:      model.MessageStatusPending,
+				Status:      model.MessageStatusProcessing,
 				ScheduledAt: time.Now().Add(-1 * time.Hour),
 			},
 			webhookResp:   nil,
@@ -177,31 +424,26 @@ func TestMessageController_ProcessMessage(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			webhookClient := &mockWebhookClient{
-				sendMessageFunc: func(req *model.WebhookRequest) (*model.WebhookResponse, error) {
+				sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
 					return tt.webhookResp, tt.webhookErr
 				},
 			}
 
 			repo := &mockMessageRepository{
-				updateStatusFunc: func(ctx context.Context, id uint, status string) error {
-					return nil
-				},
-				updateMessageIDFunc: func(ctx context.Context, id uint, messageID string) error {
-					return nil
-				},
-				updateSentAtFunc: func(ctx context.Context, id uint, sentAt time.Time) error {
+				markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
 					return nil
 				},
 			}
 
 			controller := NewMessageController(
 				repo,
-				webhookClient,
+				testProviders(webhookClient),
 				&mockMessageCache{},
+				config.Dispatcher{},
 				nil,
 			)
 
-			err := controller.processMessage(tt.message)
+			err := controller.processMessage(context.Background(), tt.message)
 			if (err != nil) != tt.expectedError {
 				t.Errorf("ProcessMessage() error = %v, expectedError %v", err, tt.expectedError)
 			}
@@ -213,7 +455,7 @@ func TestMessageController_StartStop(t *testing.T) {
 	// Create mock repository
 	mockRepo := &mockMessageRepository{
 		messages: make(map[uint]*model.Message),
-		findPendingBeforeFunc: func(ctx context.Context, before time.Time, limit int) ([]*model.Message, error) {
+		claimPendingFunc: func(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error) {
 			return []*model.Message{}, nil
 		},
 	}
@@ -228,7 +470,7 @@ func TestMessageController_StartStop(t *testing.T) {
 	logger := log.New(os.Stdout, "", log.LstdFlags)
 
 	// Create controller
-	controller := NewMessageController(mockRepo, mockWebhook, mockCache, logger)
+	controller := NewMessageController(mockRepo, testProviders(mockWebhook), mockCache, config.Dispatcher{}, logger)
 
 	// Test Start
 	err := controller.Start()
@@ -245,3 +487,2191 @@ func TestMessageController_StartStop(t *testing.T) {
 		t.Errorf("Stop() error = %v", err)
 	}
 }
+
+func TestMessageController_UpdateSettings(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	strPtr := func(v string) *string { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name          string
+		req           UpdateSettingsRequest
+		expected      config.Dispatcher
+		expectedError error
+	}{
+		{
+			name: "partial update keeps other values",
+			req:  UpdateSettingsRequest{BatchSize: intPtr(10)},
+			expected: config.Dispatcher{
+				BatchSize:   10,
+				Interval:    2 * time.Minute,
+				MaxInFlight: 1,
+			},
+		},
+		{
+			name: "full update",
+			req: UpdateSettingsRequest{
+				BatchSize:   intPtr(50),
+				Interval:    strPtr("15s"),
+				MaxInFlight: intPtr(8),
+				RateLimit:   floatPtr(2.5),
+			},
+			expected: config.Dispatcher{
+				BatchSize:   50,
+				Interval:    15 * time.Second,
+				MaxInFlight: 8,
+				RateLimit:   2.5,
+			},
+		},
+		{
+			name:          "invalid batch size",
+			req:           UpdateSettingsRequest{BatchSize: intPtr(0)},
+			expectedError: ErrInvalidBatchSize,
+		},
+		{
+			name:          "interval too short",
+			req:           UpdateSettingsRequest{Interval: strPtr("10ms")},
+			expectedError: ErrInvalidInterval,
+		},
+		{
+			name:          "unparsable interval",
+			req:           UpdateSettingsRequest{Interval: strPtr("soon")},
+			expectedError: ErrInvalidInterval,
+		},
+		{
+			name:          "negative rate limit",
+			req:           UpdateSettingsRequest{RateLimit: floatPtr(-1)},
+			expectedError: ErrInvalidRateLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewMessageController(
+				&mockMessageRepository{},
+				testProviders(&mockWebhookClient{}),
+				&mockMessageCache{},
+				config.Dispatcher{},
+				nil,
+			)
+
+			settings, err := controller.UpdateSettings(tt.req)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("UpdateSettings() error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if tt.expectedError != nil {
+				if controller.Settings() != withDefaults(config.Dispatcher{}) {
+					t.Errorf("Settings changed after failed update: %+v", controller.Settings())
+				}
+				return
+			}
+			if newSettingsResponse(settings) != newSettingsResponse(tt.expected) {
+				t.Errorf("Expected settings %+v, got %+v", tt.expected, settings)
+			}
+			select {
+			case <-controller.settingsCh:
+			default:
+				t.Error("Expected dispatcher to be notified of new settings")
+			}
+		})
+	}
+}
+
+func TestThrottle_Wait(t *testing.T) {
+	var th throttle
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := th.wait(context.Background(), 20); err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+	}
+	// Three sends at 20/s need at least two 50ms gaps
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected sends to be spaced out, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	th.next = time.Now().Add(time.Hour)
+	if err := th.wait(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMessageController_SendBatch(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			if req.To == "fail@example.com" {
+				return nil, errors.New("webhook error")
+			}
+			return &model.WebhookResponse{MessageID: "id"}, nil
+		},
+	}
+	repo := &mockMessageRepository{
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(webhookClient), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	messages := make([]*model.Message, 8)
+	for i := range messages {
+		messages[i] = &model.Message{ID: uint(i + 1), To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: "test"}
+	}
+	messages[3].To = "fail@example.com"
+
+	result := controller.sendBatch(context.Background(), messages, 3, 0)
+	if result.Sent != 7 || result.Failed != 1 || result.Skipped != 0 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if got := maxInFlight.Load(); got < 2 || got > 3 {
+		t.Errorf("Expected between 2 and 3 sends in flight, got %d", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var released []uint
+	repo.releaseClaimsFunc = func(ctx context.Context, owner string, ids []uint) error {
+		released = append(released, ids...)
+		return nil
+	}
+	result = controller.sendBatch(ctx, messages, 3, 0)
+	if result.Sent != 0 || result.Skipped != len(messages) {
+		t.Errorf("Expected all messages to be skipped after cancel, got %+v", result)
+	}
+	if len(released) != len(messages) {
+		t.Errorf("Expected skipped messages to be released, got %v", released)
+	}
+}
+
+func TestMessageController_SendBatchLeaseExpires(t *testing.T) {
+	var sentTo []string
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			sentTo = append(sentTo, req.To)
+			return &model.WebhookResponse{MessageID: "id"}, nil
+		},
+	}
+
+	// Message 3 was recovered and claimed by another replica while the batch ran
+	var renewed []uint
+	repo := &mockMessageRepository{
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+			return nil
+		},
+		renewLeaseFunc: func(ctx context.Context, id uint, owner string, until time.Time) error {
+			renewed = append(renewed, id)
+			if id == 3 {
+				return repository.ErrLeaseLost
+			}
+			return nil
+		},
+		releaseClaimsFunc: func(ctx context.Context, owner string, ids []uint) error {
+			t.Errorf("Expected no message to be released, got %v", ids)
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(webhookClient), &mockMessageCache{}, config.Dispatcher{LeaseDuration: time.Minute}, nil)
+
+	now := time.Now()
+	leases := []time.Time{now.Add(time.Minute), now.Add(10 * time.Second), now.Add(-time.Second)}
+	messages := make([]*model.Message, len(leases))
+	for i := range leases {
+		messages[i] = &model.Message{ID: uint(i + 1), To: fmt.Sprintf("test%d@example.com", i+1), Status: model.MessageStatusProcessing, ClaimedBy: "test", LeaseExpiresAt: &leases[i]}
+	}
+
+	result := controller.sendBatch(context.Background(), messages, 1, 0)
+	if result.Sent != 2 || result.Skipped != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	// Only leases with less than half of their duration left are renewed
+	if len(renewed) != 2 || renewed[0] != 2 || renewed[1] != 3 {
+		t.Errorf("Expected leases of messages 2 and 3 to be renewed, got %v", renewed)
+	}
+	if len(sentTo) != 2 || sentTo[1] != "test2@example.com" {
+		t.Errorf("Expected messages 1 and 2 to be sent, got %v", sentTo)
+	}
+	if messages[1].LeaseExpiresAt.Sub(now) < 50*time.Second {
+		t.Errorf("Expected renewed lease of message 2, got %s", messages[1].LeaseExpiresAt)
+	}
+}
+
+func TestMessageController_StopInterruptsSend(t *testing.T) {
+	started := make(chan struct{})
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			// A provider that hangs until the request is cancelled
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	var released []uint
+	var releaseErr error
+	repo := &mockMessageRepository{
+		claimPendingFunc: func(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error) {
+			if len(released) > 0 {
+				return []*model.Message{}, nil
+			}
+			return []*model.Message{{ID: 1, To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: owner}}, nil
+		},
+		releaseClaimsFunc: func(ctx context.Context, owner string, ids []uint) error {
+			releaseErr = ctx.Err()
+			released = append(released, ids...)
+			return nil
+		},
+		rescheduleAttemptFunc: func(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error {
+			t.Error("Interrupted send must not count as a failed attempt")
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(webhookClient), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	if err := controller.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		controller.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop() did not interrupt the hung send")
+	}
+
+	if len(released) != 1 || released[0] != 1 {
+		t.Errorf("Expected interrupted message to be released, got %v", released)
+	}
+	if releaseErr != nil {
+		t.Errorf("Expected release to use a live context, got %v", releaseErr)
+	}
+}
+
+func TestMessageController_HandleSendFailure(t *testing.T) {
+	tests := []struct {
+		name             string
+		attemptCount     int
+		expectReschedule bool
+		expectFailed     bool
+	}{
+		{
+			name:             "first failure is retried",
+			attemptCount:     0,
+			expectReschedule: true,
+		},
+		{
+			name:             "failure before the last attempt is retried",
+			attemptCount:     3,
+			expectReschedule: true,
+		},
+		{
+			name:         "last attempt marks message failed",
+			attemptCount: 4,
+			expectFailed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rescheduledAt time.Time
+			var failed bool
+			repo := &mockMessageRepository{
+				rescheduleAttemptFunc: func(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error {
+					if lastError != "webhook error" {
+						t.Errorf("Expected last error %q, got %q", "webhook error", lastError)
+					}
+					rescheduledAt = nextAttemptAt
+					return nil
+				},
+				markFailedFunc: func(ctx context.Context, id uint, owner string, lastError string) error {
+					failed = true
+					return nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{
+				Retry: config.Retry{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Hour},
+			}, nil)
+
+			msg := &model.Message{ID: 1, Status: model.MessageStatusProcessing, ClaimedBy: "test", AttemptCount: tt.attemptCount}
+			if err := controller.handleSendFailure(context.Background(), msg, errors.New("webhook error")); err != nil {
+				t.Fatalf("handleSendFailure() error = %v", err)
+			}
+
+			if tt.expectReschedule && rescheduledAt.Before(time.Now()) {
+				t.Errorf("Expected message to be rescheduled in the future, got %v", rescheduledAt)
+			}
+			if !tt.expectReschedule && !rescheduledAt.IsZero() {
+				t.Error("Expected message not to be rescheduled")
+			}
+			if failed != tt.expectFailed {
+				t.Errorf("Expected failed = %v, got %v", tt.expectFailed, failed)
+			}
+		})
+	}
+}
+
+func TestMessageController_HandleSendFailureClassification(t *testing.T) {
+	tests := []struct {
+		name          string
+		sendErr       error
+		expectFailed  bool
+		expectAtLeast time.Duration
+	}{
+		{
+			name:         "permanent error fails fast",
+			sendErr:      &client.SendError{StatusCode: http.StatusUnprocessableEntity, Body: "invalid recipient"},
+			expectFailed: true,
+		},
+		{
+			name:          "retryable error is rescheduled",
+			sendErr:       &client.SendError{StatusCode: http.StatusBadGateway, Retryable: true},
+			expectAtLeast: 0,
+		},
+		{
+			name:          "retry-after is honored",
+			sendErr:       &client.SendError{StatusCode: http.StatusTooManyRequests, Retryable: true, RetryAfter: 2 * time.Hour},
+			expectAtLeast: 2 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rescheduledAt time.Time
+			var failedWith string
+			repo := &mockMessageRepository{
+				rescheduleAttemptFunc: func(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error {
+					rescheduledAt = nextAttemptAt
+					return nil
+				},
+				markFailedFunc: func(ctx context.Context, id uint, owner string, lastError string) error {
+					failedWith = lastError
+					return nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{
+				Retry: config.Retry{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute},
+			}, nil)
+
+			start := time.Now()
+			msg := &model.Message{ID: 1, Status: model.MessageStatusProcessing, ClaimedBy: "test"}
+			if err := controller.handleSendFailure(context.Background(), msg, tt.sendErr); err != nil {
+				t.Fatalf("handleSendFailure() error = %v", err)
+			}
+
+			if tt.expectFailed {
+				if failedWith != tt.sendErr.Error() {
+					t.Errorf("Expected message failed with %q, got %q", tt.sendErr.Error(), failedWith)
+				}
+				if !rescheduledAt.IsZero() {
+					t.Error("Expected permanent failure not to be rescheduled")
+				}
+				return
+			}
+			if failedWith != "" {
+				t.Errorf("Expected retryable failure not to be marked failed, got %q", failedWith)
+			}
+			if rescheduledAt.Before(start.Add(tt.expectAtLeast)) {
+				t.Errorf("Expected retry no sooner than %s, got %s", tt.expectAtLeast, rescheduledAt.Sub(start))
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	base := 10 * time.Second
+	maxDelay := time.Minute
+
+	tests := []struct {
+		attempt int
+		window  time.Duration
+	}{
+		{attempt: 1, window: 10 * time.Second},
+		{attempt: 2, window: 20 * time.Second},
+		{attempt: 3, window: 40 * time.Second},
+		{attempt: 4, window: time.Minute},
+		{attempt: 10, window: time.Minute},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			delay := retryDelay(tt.attempt, base, maxDelay)
+			if delay < tt.window/2 || delay > tt.window {
+				t.Fatalf("retryDelay(%d) = %v, expected within [%v, %v]", tt.attempt, delay, tt.window/2, tt.window)
+			}
+		}
+	}
+}
+
+// newTestContext builds a gin context for calling controller handlers directly
+func ptrTime(t time.Time) *time.Time {
+	return &t
+}
+
+func newTestContext(method, target, body string, params gin.Params) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		ctx.Request.Header.Set("Content-Type", "application/json")
+	}
+	ctx.Params = params
+	return ctx, w
+}
+
+func TestMessageController_RequeueMessage(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		findErr        error
+		requeueErr     error
+		expectedStatus int
+	}{
+		{
+			name:           "failed message is requeued",
+			id:             "1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid id",
+			id:             "abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown message",
+			id:             "1",
+			findErr:        gorm.ErrRecordNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "lookup fails",
+			id:             "1",
+			findErr:        errors.New("connection refused"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "message is not failed",
+			id:             "1",
+			requeueErr:     repository.ErrNotDeadLetter,
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockMessageRepository{
+				findByIDFunc: func(ctx context.Context, id uint) (*model.Message, error) {
+					if tt.findErr != nil {
+						return nil, tt.findErr
+					}
+					return &model.Message{ID: id, Status: model.MessageStatusPending}, nil
+				},
+				requeueDeadLetterFunc: func(ctx context.Context, id uint) error {
+					return tt.requeueErr
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			ctx, w := newTestContext(http.MethodPost, "/api/v1/messages/"+tt.id+"/requeue", "", gin.Params{{Key: "id", Value: tt.id}})
+			controller.RequeueMessage(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMessageController_RequeueDeadLetters(t *testing.T) {
+	var got repository.DeadLetterFilter
+	repo := &mockMessageRepository{
+		requeueDeadLettersFn: func(ctx context.Context, filter repository.DeadLetterFilter) (int64, error) {
+			got = filter
+			return 3, nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	body := `{"to": "test@example.com", "error_contains": "timeout", "failed_after": "2024-04-26T10:00:00Z"}`
+	ctx, w := newTestContext(http.MethodPost, "/api/v1/messages/dead-letter/requeue", body, nil)
+	controller.RequeueDeadLetters(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"requeued":3`) {
+		t.Errorf("Unexpected response body %s", w.Body.String())
+	}
+	if got.To != "test@example.com" || got.ErrorContains != "timeout" || got.FailedAfter.IsZero() {
+		t.Errorf("Filter not passed to repository: %+v", got)
+	}
+
+	// An empty body requeues everything
+	ctx, w = newTestContext(http.MethodPost, "/api/v1/messages/dead-letter/requeue", "", nil)
+	controller.RequeueDeadLetters(ctx)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for empty body, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMessageController_CreateMessageIdempotency(t *testing.T) {
+	var created int
+	repo := &mockMessageRepository{
+		createFunc: func(ctx context.Context, message *model.Message) error {
+			created++
+			message.ID = uint(created)
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", body, nil)
+		if key != "" {
+			ctx.Request.Header.Set("Idempotency-Key", key)
+		}
+		controller.CreateMessage(ctx)
+		return w
+	}
+
+	body := `{"content": "Hello", "to": "test@example.com", "scheduled_at": "2024-04-26T10:00:00Z"}`
+
+	first := post("key-1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", first.Code, first.Body.String())
+	}
+
+	// Same key and same request, formatted differently
+	replay := post("key-1", `{"to":"test@example.com","content":"Hello","scheduled_at":"2024-04-26T10:00:00Z"}`)
+	if replay.Code != http.StatusCreated {
+		t.Errorf("Expected replayed status 201, got %d", replay.Code)
+	}
+	if replay.Body.String() != first.Body.String() {
+		t.Errorf("Expected replayed body %s, got %s", first.Body.String(), replay.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Expected Idempotent-Replayed header on replay")
+	}
+	if created != 1 {
+		t.Errorf("Expected 1 message to be created, got %d", created)
+	}
+
+	// Same key with a different request
+	conflict := post("key-1", `{"content": "Other", "to": "test@example.com", "scheduled_at": "2024-04-26T10:00:00Z"}`)
+	if conflict.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", conflict.Code)
+	}
+
+	// Requests without a key are never deduplicated
+	post("", body)
+	post("", body)
+	if created != 3 {
+		t.Errorf("Expected 3 messages to be created, got %d", created)
+	}
+
+	// Rejected requests free their key, so that they can be fixed and retried
+	invalid := `{"content": "Hello", "to": "not an address", "scheduled_at": "2024-04-26T10:00:00Z"}`
+	if w := post("key-2", invalid); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", w.Code)
+	}
+	if w := post("key-2", body); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected fixed request to be created, got %d", w.Code)
+	}
+}
+
+func TestMessageController_CreateMessageClientGoesAway(t *testing.T) {
+	reqCtx, cancel := context.WithCancel(context.Background())
+	repo := &mockMessageRepository{
+		createFunc: func(ctx context.Context, message *model.Message) error {
+			// The client disconnects while the message is being created
+			cancel()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			message.ID = 1
+			return nil
+		},
+	}
+	messageCache := &idempotencyTTLCache{}
+	controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), messageCache, config.Dispatcher{}, nil)
+
+	ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", `{"content": "Hello", "to": "test@example.com", "scheduled_at": "2024-04-26T10:00:00Z"}`, nil)
+	ctx.Request = ctx.Request.WithContext(reqCtx)
+	ctx.Request.Header.Set("Idempotency-Key", "key-1")
+	controller.CreateMessage(ctx)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	// The key is reserved briefly and the response kept for a day
+	if ttls := messageCache.ttls; len(ttls) != 2 || ttls[0] != idempotencyLockTTL || ttls[1] != idempotencyKeyTTL {
+		t.Errorf("Expected TTLs %s and %s, got %v", idempotencyLockTTL, idempotencyKeyTTL, ttls)
+	}
+	if record := messageCache.idempotency["key-1"]; record.StatusCode != http.StatusCreated {
+		t.Errorf("Expected the response to be stored for replays, got %+v", record)
+	}
+}
+
+// idempotencyTTLCache records the TTLs idempotency keys are stored with and
+// fails like Redis does when the request context is cancelled
+type idempotencyTTLCache struct {
+	mockMessageCache
+	ttls []time.Duration
+}
+
+func (m *idempotencyTTLCache) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, ttl time.Duration) (*cache.IdempotencyRecord, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.ttls = append(m.ttls, ttl)
+	return m.mockMessageCache.ReserveIdempotencyKey(ctx, key, requestHash, ttl)
+}
+
+func (m *idempotencyTTLCache) CompleteIdempotencyKey(ctx context.Context, key string, record cache.IdempotencyRecord, ttl time.Duration) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	m.ttls = append(m.ttls, ttl)
+	return m.mockMessageCache.CompleteIdempotencyKey(ctx, key, record, ttl)
+}
+
+func TestMessageController_CreateMessageReplayNotValidatedAgain(t *testing.T) {
+	var lookups int
+	recipient := &model.Recipient{Channel: model.ChannelEmail, To: "test@example.com", Timezone: "Europe/Berlin"}
+	repo := &mockMessageRepository{
+		createFunc: func(ctx context.Context, message *model.Message) error {
+			message.ID = 1
+			return nil
+		},
+		findRecipientFunc: func(ctx context.Context, channel, to string) (*model.Recipient, error) {
+			lookups++
+			if recipient == nil {
+				return nil, gorm.ErrRecordNotFound
+			}
+			return recipient, nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	post := func() *httptest.ResponseRecorder {
+		ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", `{"content": "Hello", "to": "test@example.com", "local_time": "2024-04-26T09:00:00"}`, nil)
+		ctx.Request.Header.Set("Idempotency-Key", "key-1")
+		controller.CreateMessage(ctx)
+		return w
+	}
+
+	first := post()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", first.Code, first.Body.String())
+	}
+
+	// The recipient's time zone is gone, the replay still gets the original response
+	recipient = nil
+	replay := post()
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("Expected replayed status 201 with %s, got %d %s", first.Body.String(), replay.Code, replay.Body.String())
+	}
+	if lookups != 1 {
+		t.Errorf("Expected the recipient to be looked up once, got %d", lookups)
+	}
+}
+
+func TestMessageController_ProcessMessageSkipsAcknowledgedDelivery(t *testing.T) {
+	var sends int
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			sends++
+			if req.IdempotencyKey != "key-1" {
+				t.Errorf("Expected idempotency key %q, got %q", "key-1", req.IdempotencyKey)
+			}
+			return &model.WebhookResponse{MessageID: "provider-1"}, nil
+		},
+	}
+
+	var recordedID string
+	repo := &mockMessageRepository{
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+			if owner != "test" {
+				t.Errorf("Expected MarkSent by owner %q, got %q", "test", owner)
+			}
+			recordedID = messageID
+			return nil
+		},
+	}
+	messageCache := &mockMessageCache{}
+	controller := NewMessageController(repo, testProviders(webhookClient), messageCache, config.Dispatcher{}, nil)
+
+	newMessage := func() *model.Message {
+		return &model.Message{ID: 1, To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: "test", DeliveryKey: "key-1"}
+	}
+
+	if err := controller.processMessage(context.Background(), newMessage()); err != nil {
+		t.Fatalf("processMessage() error = %v", err)
+	}
+	if _, ok := messageCache.deliveryAcks["key-1"]; !ok {
+		t.Fatal("Expected delivery acknowledgement to be stored")
+	}
+
+	// The same message claimed again, e.g. after a crash before the status update
+	recordedID = ""
+	if err := controller.processMessage(context.Background(), newMessage()); err != nil {
+		t.Fatalf("processMessage() error = %v", err)
+	}
+	if sends != 1 {
+		t.Errorf("Expected 1 webhook call, got %d", sends)
+	}
+	if recordedID != "provider-1" {
+		t.Errorf("Expected acknowledged message ID to be recorded, got %q", recordedID)
+	}
+}
+
+func TestMessageController_ProcessMessageMarksSentOnce(t *testing.T) {
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			return &model.WebhookResponse{MessageID: "provider-1"}, nil
+		},
+	}
+
+	var calls int
+	repo := &mockMessageRepository{
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+			calls++
+			if owner != "test" || messageID != "provider-1" || sentAt.IsZero() {
+				t.Errorf("Unexpected MarkSent(%d, %q, %q, %v)", id, owner, messageID, sentAt)
+			}
+			if calls > 1 {
+				return &model.InvalidTransitionError{ID: id, From: model.MessageStatusSent, To: model.MessageStatusSent}
+			}
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(webhookClient), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	msg := &model.Message{ID: 1, To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: "test"}
+	if err := controller.processMessage(context.Background(), msg); err != nil {
+		t.Fatalf("processMessage() error = %v", err)
+	}
+
+	// A message that already left processing is reported, not silently overwritten
+	var transitionErr *model.InvalidTransitionError
+	if err := controller.processMessage(context.Background(), msg); !errors.As(err, &transitionErr) {
+		t.Errorf("Expected InvalidTransitionError, got %v", err)
+	}
+}
+
+func TestMessageController_ProcessMessageCachesSentTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		messageID string
+		cached    bool
+	}{
+		{name: "provider message ID", messageID: "provider-1", cached: true},
+		{name: "accepted without an ID of its own", messageID: model.AcceptedMessageID},
+		{name: "no message ID", messageID: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhookClient := &mockWebhookClient{
+				sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+					return &model.WebhookResponse{MessageID: tt.messageID}, nil
+				},
+			}
+			var markedAt time.Time
+			repo := &mockMessageRepository{
+				markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+					markedAt = sentAt
+					return nil
+				},
+			}
+			cached := make(map[string]time.Time)
+			messageCache := &mockMessageCache{
+				storeMessageIDFunc: func(ctx context.Context, messageID string, at time.Time) error {
+					cached[messageID] = at
+					return nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(webhookClient), messageCache, config.Dispatcher{}, nil)
+
+			msg := &model.Message{ID: 1, To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: "test"}
+			if err := controller.processMessage(context.Background(), msg); err != nil {
+				t.Fatalf("processMessage() error = %v", err)
+			}
+
+			at, ok := cached[tt.messageID]
+			if ok != tt.cached || len(cached) > 1 {
+				t.Fatalf("Expected cached %v, got %v", tt.cached, cached)
+			}
+			// The cache answers with the time the database has, to the microsecond
+			if ok && (!at.Equal(markedAt) || !at.Equal(at.Truncate(time.Microsecond))) {
+				t.Errorf("Expected cached sent time %s rounded to microseconds, got %s", markedAt, at)
+			}
+		})
+	}
+}
+
+func TestMessageController_UpdateMessage(t *testing.T) {
+	tests := []struct {
+		name           string
+		updateErr      error
+		expectedStatus int
+	}{
+		{
+			name:           "pending message is updated",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unknown message",
+			updateErr:      gorm.ErrRecordNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "message already picked up",
+			updateErr:      repository.ErrNotPending,
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockMessageRepository{
+				updatePendingFunc: func(ctx context.Context, id uint, changes *model.Message) error {
+					return tt.updateErr
+				},
+				findByIDFunc: func(ctx context.Context, id uint) (*model.Message, error) {
+					return &model.Message{ID: id, Status: model.MessageStatusPending}, nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			body := `{"content": "Updated", "to": "test@example.com", "scheduled_at": "2024-04-26T10:00:00Z"}`
+			ctx, w := newTestContext(http.MethodPut, "/api/v1/messages/1/status", body, gin.Params{{Key: "id", Value: "1"}})
+			controller.UpdateMessage(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMessageController_GetSentMessage(t *testing.T) {
+	sentAt := time.Date(2024, 4, 26, 10, 0, 0, 0, time.UTC)
+
+	cached := make(map[string]time.Time)
+	messageCache := &mockMessageCache{
+		storeMessageIDFunc: func(ctx context.Context, messageID string, at time.Time) error {
+			cached[messageID] = at
+			return nil
+		},
+		getMessageSentTimeFunc: func(ctx context.Context, messageID string) (*time.Time, error) {
+			if at, ok := cached[messageID]; ok {
+				return &at, nil
+			}
+			return nil, nil
+		},
+	}
+	repo := &mockMessageRepository{
+		findByMessageIDFunc: func(ctx context.Context, messageID string) (*model.Message, error) {
+			switch messageID {
+			case "sent-1":
+				return &model.Message{ID: 1, MessageID: messageID, Status: model.MessageStatusSent, SentAt: sentAt}, nil
+			default:
+				return nil, gorm.ErrRecordNotFound
+			}
+		},
+	}
+	controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), messageCache, config.Dispatcher{}, nil)
+
+	get := func(messageID string) *httptest.ResponseRecorder {
+		ctx, w := newTestContext(http.MethodGet, "/api/v1/messaging/sent/"+messageID, "", gin.Params{{Key: "messageId", Value: messageID}})
+		controller.GetSentMessage(ctx)
+		return w
+	}
+
+	// First lookup falls back to the database and warms the cache
+	w := get("sent-1")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" || !strings.Contains(w.Body.String(), `"source":"database"`) {
+		t.Errorf("Expected database answer on cache miss, got %d %s %s", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	w = get("sent-1")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" || !strings.Contains(w.Body.String(), `"source":"cache"`) {
+		t.Errorf("Expected cache answer on second lookup, got %d %s %s", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	if w := get("unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown message, got %d", w.Code)
+	}
+
+	// Every message a webhook answered 202 for shares this ID, it is not looked up
+	if w := get(model.AcceptedMessageID); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for %q, got %d", model.AcceptedMessageID, w.Code)
+	}
+
+	ctx, w := newTestContext(http.MethodGet, "/api/v1/messaging/stats", "", nil)
+	controller.GetStats(ctx)
+	if !strings.Contains(w.Body.String(), `"sent_lookup_cache":{"hits":1,"misses":2}`) {
+		t.Errorf("Unexpected stats %s", w.Body.String())
+	}
+}
+
+func TestMessageController_GetMessageEvents(t *testing.T) {
+	events := []model.MessageEvent{
+		{ID: 1, MessageID: 1, ToStatus: model.MessageStatusPending, Actor: model.ActorAPI, ActorID: "192.0.2.1"},
+		{ID: 2, MessageID: 1, FromStatus: model.MessageStatusPending, ToStatus: model.MessageStatusProcessing, Actor: model.ActorDispatcher, ActorID: "replica-1"},
+		{ID: 3, MessageID: 1, FromStatus: model.MessageStatusProcessing, ToStatus: model.MessageStatusPending, Actor: model.ActorDispatcher, ActorID: "replica-1", Error: "webhook returned 503"},
+	}
+
+	tests := []struct {
+		name           string
+		id             string
+		findErr        error
+		expectedStatus int
+		expectedEvents int
+	}{
+		{
+			name:           "history of a message",
+			id:             "1",
+			expectedStatus: http.StatusOK,
+			expectedEvents: len(events),
+		},
+		{
+			name:           "invalid id",
+			id:             "abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown message",
+			id:             "2",
+			findErr:        gorm.ErrRecordNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockMessageRepository{
+				findByIDFunc: func(ctx context.Context, id uint) (*model.Message, error) {
+					if tt.findErr != nil {
+						return nil, tt.findErr
+					}
+					return &model.Message{ID: id}, nil
+				},
+				findEventsFunc: func(ctx context.Context, messageID uint) ([]model.MessageEvent, error) {
+					return events, nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			ctx, w := newTestContext(http.MethodGet, "/api/v1/messages/"+tt.id+"/events", "", gin.Params{{Key: "id", Value: tt.id}})
+			controller.GetMessageEvents(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var got []model.MessageEvent
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(got) != tt.expectedEvents {
+				t.Fatalf("Expected %d events, got %d", tt.expectedEvents, len(got))
+			}
+			if got[2].Error != "webhook returned 503" {
+				t.Errorf("Expected error details to be returned, got %q", got[2].Error)
+			}
+		})
+	}
+}
+
+func TestMessageController_ProviderRouting(t *testing.T) {
+	var sentBy []string
+	provider := func(name string) client.Provider {
+		return &mockWebhookClient{
+			sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+				sentBy = append(sentBy, name)
+				return &model.WebhookResponse{MessageID: name + "-1"}, nil
+			},
+		}
+	}
+	providers := client.NewRegistry("webhook")
+	providers.Register("webhook", provider("webhook"))
+	providers.Register("vendor-b", provider("vendor-b"))
+
+	var failedWith string
+	repo := &mockMessageRepository{
+		createFunc: func(ctx context.Context, message *model.Message) error { return nil },
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+			return nil
+		},
+		markFailedFunc: func(ctx context.Context, id uint, owner string, lastError string) error {
+			failedWith = lastError
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, providers, &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	t.Run("create rejects unknown provider", func(t *testing.T) {
+		body := `{"content": "Test message", "to": "test@example.com", "scheduled_at": "2024-04-26T10:00:00Z", "provider": "missing"}`
+		ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", body, nil)
+		controller.CreateMessage(ctx)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("messages are sent through their provider", func(t *testing.T) {
+		for _, name := range []string{"", "vendor-b"} {
+			msg := &model.Message{ID: 1, To: "test@example.com", Status: model.MessageStatusProcessing, Provider: name}
+			if err := controller.processMessage(context.Background(), msg); err != nil {
+				t.Fatalf("processMessage() error = %v", err)
+			}
+		}
+		if len(sentBy) != 2 || sentBy[0] != "webhook" || sentBy[1] != "vendor-b" {
+			t.Errorf("Expected sends through webhook and vendor-b, got %v", sentBy)
+		}
+	})
+
+	t.Run("unknown provider fails the message", func(t *testing.T) {
+		msg := &model.Message{ID: 2, To: "test@example.com", Status: model.MessageStatusProcessing, Provider: "removed"}
+		if err := controller.processMessage(context.Background(), msg); err == nil {
+			t.Fatal("Expected error for unknown provider")
+		}
+		if !strings.Contains(failedWith, "unknown provider") {
+			t.Errorf("Expected message to be marked failed, got %q", failedWith)
+		}
+	})
+}
+
+func TestMessageController_CreateMessageChannels(t *testing.T) {
+	tests := []struct {
+		name           string
+		channel        string
+		to             string
+		content        string
+		expectedStatus int
+		expectedTo     string
+	}{
+		{
+			name:           "email is the default channel",
+			to:             "Test User <test@example.com>",
+			expectedStatus: http.StatusCreated,
+			expectedTo:     "test@example.com",
+		},
+		{
+			name:           "invalid email",
+			channel:        "email",
+			to:             "not-an-email",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "sms number is normalized to E.164",
+			channel:        "sms",
+			to:             "+44 (20) 7946-0958",
+			expectedStatus: http.StatusCreated,
+			expectedTo:     "+442079460958",
+		},
+		{
+			name:           "sms number with 00 prefix",
+			channel:        "sms",
+			to:             "0049 30 901820",
+			expectedStatus: http.StatusCreated,
+			expectedTo:     "+4930901820",
+		},
+		{
+			name:           "sms number without country code",
+			channel:        "sms",
+			to:             "020 7946 0958",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "push token",
+			channel:        "push",
+			to:             "fcm:dGVzdC1kZXZpY2UtdG9rZW4",
+			expectedStatus: http.StatusCreated,
+			expectedTo:     "fcm:dGVzdC1kZXZpY2UtdG9rZW4",
+		},
+		{
+			name:           "unknown channel",
+			channel:        "fax",
+			to:             "+442079460958",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "sms allows longer content than email",
+			channel:        "sms",
+			to:             "+442079460958",
+			content:        strings.Repeat("a", 1000),
+			expectedStatus: http.StatusCreated,
+			expectedTo:     "+442079460958",
+		},
+		{
+			name:           "content over the email limit",
+			channel:        "email",
+			to:             "test@example.com",
+			content:        strings.Repeat("a", 501),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "limit counts characters, not bytes",
+			channel:        "email",
+			to:             "test@example.com",
+			content:        strings.Repeat("ü", 500),
+			expectedStatus: http.StatusCreated,
+			expectedTo:     "test@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created *model.Message
+			repo := &mockMessageRepository{
+				createFunc: func(ctx context.Context, message *model.Message) error {
+					created = message
+					return nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			content := tt.content
+			if content == "" {
+				content = "Test message"
+			}
+			body, _ := json.Marshal(map[string]string{
+				"content":      content,
+				"to":           tt.to,
+				"channel":      tt.channel,
+				"scheduled_at": "2024-04-26T10:00:00Z",
+			})
+			ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", string(body), nil)
+			controller.CreateMessage(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+			if created.To != tt.expectedTo {
+				t.Errorf("Expected recipient %q, got %q", tt.expectedTo, created.To)
+			}
+			expectedChannel := tt.channel
+			if expectedChannel == "" {
+				expectedChannel = model.ChannelEmail
+			}
+			if created.Channel != expectedChannel {
+				t.Errorf("Expected channel %q, got %q", expectedChannel, created.Channel)
+			}
+		})
+	}
+}
+
+func TestMessageController_ChannelRouting(t *testing.T) {
+	var sentBy []string
+	provider := func(name string) client.Provider {
+		return &mockWebhookClient{
+			sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+				sentBy = append(sentBy, name+":"+req.Channel)
+				return &model.WebhookResponse{MessageID: name + "-1"}, nil
+			},
+		}
+	}
+	providers := client.NewRegistry("webhook")
+	providers.Register("webhook", provider("webhook"))
+	providers.Register("sms-vendor", provider("sms-vendor"))
+	providers.SetChannel(model.ChannelSMS, config.Channel{Providers: []string{"sms-vendor"}})
+
+	deliveredBy := map[uint]string{}
+	repo := &mockMessageRepository{
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, provider string, sentAt time.Time) error {
+			deliveredBy[id] = provider
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, providers, &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	messages := []*model.Message{
+		{ID: 1, To: "test@example.com", Channel: model.ChannelEmail, Status: model.MessageStatusProcessing},
+		{ID: 2, To: "+442079460958", Channel: model.ChannelSMS, Status: model.MessageStatusProcessing},
+		{ID: 3, To: "+442079460958", Channel: model.ChannelSMS, Provider: "webhook", Status: model.MessageStatusProcessing},
+	}
+	for _, msg := range messages {
+		if err := controller.processMessage(context.Background(), msg); err != nil {
+			t.Fatalf("processMessage() error = %v", err)
+		}
+	}
+
+	expected := []string{"webhook:email", "sms-vendor:sms", "webhook:sms"}
+	if strings.Join(sentBy, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected sends %v, got %v", expected, sentBy)
+	}
+	if deliveredBy[1] != "webhook" || deliveredBy[2] != "sms-vendor" || deliveredBy[3] != "webhook" {
+		t.Errorf("Unexpected delivering providers %v", deliveredBy)
+	}
+}
+
+func TestMessageController_ProviderFailover(t *testing.T) {
+	var attempts []string
+	primary := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			attempts = append(attempts, "primary")
+			return nil, &client.SendError{StatusCode: http.StatusServiceUnavailable, Retryable: true}
+		},
+	}
+	backup := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			attempts = append(attempts, "backup")
+			return &model.WebhookResponse{MessageID: "backup-1"}, nil
+		},
+	}
+	providers := client.NewRegistry("primary")
+	providers.SetCircuitBreaker(config.CircuitBreaker{FailureThreshold: 1, Cooldown: time.Minute})
+	providers.Register("primary", primary)
+	providers.Register("backup", backup)
+	providers.SetChannel(model.ChannelEmail, config.Channel{Providers: []string{"primary", "backup"}})
+
+	var deliveredBy []string
+	repo := &mockMessageRepository{
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, provider string, sentAt time.Time) error {
+			deliveredBy = append(deliveredBy, provider+":"+messageID)
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, providers, &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	for id := uint(1); id <= 2; id++ {
+		msg := &model.Message{ID: id, To: "test@example.com", Channel: model.ChannelEmail, Status: model.MessageStatusProcessing}
+		if err := controller.processMessage(context.Background(), msg); err != nil {
+			t.Fatalf("processMessage() error = %v", err)
+		}
+	}
+
+	// The primary circuit opens after its first failure and is skipped afterwards
+	expected := []string{"primary", "backup", "backup"}
+	if strings.Join(attempts, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected attempts %v, got %v", expected, attempts)
+	}
+	if strings.Join(deliveredBy, ",") != "backup:backup-1,backup:backup-1" {
+		t.Errorf("Expected both messages delivered by backup, got %v", deliveredBy)
+	}
+	if state := providers.Breakers()["primary"].State; state != client.BreakerOpen {
+		t.Errorf("Expected primary circuit open, got %s", state)
+	}
+}
+
+func TestMessageController_PausesWhileCircuitOpen(t *testing.T) {
+	var sends int
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			sends++
+			return nil, &client.SendError{StatusCode: http.StatusBadGateway, Retryable: true}
+		},
+	}
+	providers := client.NewRegistry(client.DefaultProviderName)
+	providers.SetCircuitBreaker(config.CircuitBreaker{FailureThreshold: 1, Cooldown: time.Minute})
+	providers.Register(client.DefaultProviderName, webhookClient)
+
+	var claims, rescheduled int
+	var released, deferred []uint
+	var deferredUntil time.Time
+	repo := &mockMessageRepository{
+		claimPendingFunc: func(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error) {
+			claims++
+			return []*model.Message{
+				{ID: 1, To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: owner},
+				{ID: 2, To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: owner},
+			}, nil
+		},
+		rescheduleAttemptFunc: func(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error {
+			rescheduled++
+			return nil
+		},
+		releaseClaimsFunc: func(ctx context.Context, owner string, ids []uint) error {
+			released = append(released, ids...)
+			return nil
+		},
+		deferClaimFunc: func(ctx context.Context, id uint, owner string, until time.Time, reason string) error {
+			deferred = append(deferred, id)
+			deferredUntil = until
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, providers, &mockMessageCache{}, config.Dispatcher{BatchSize: 2}, nil)
+
+	// The first failure opens the circuit, the second message is not sent
+	// and waits for the probe without using up an attempt
+	result, err := controller.processMessages(context.Background())
+	if err != nil {
+		t.Fatalf("processMessages() error = %v", err)
+	}
+	if sends != 1 || rescheduled != 1 {
+		t.Errorf("Expected one send and one failed attempt, got %d sends and %d attempts", sends, rescheduled)
+	}
+	if len(deferred) != 1 || deferred[0] != 2 || len(released) != 0 || result.Deferred != 1 {
+		t.Errorf("Expected second message to be deferred, got deferred %v and released %v", deferred, released)
+	}
+	if wait := time.Until(deferredUntil); wait < 50*time.Second || wait > time.Minute {
+		t.Errorf("Expected second message to wait for the probe in a minute, got %s", wait)
+	}
+
+	// While the circuit is open nothing is claimed
+	if _, err := controller.processMessages(context.Background()); err != nil {
+		t.Fatalf("processMessages() error = %v", err)
+	}
+	if claims != 1 {
+		t.Errorf("Expected dispatch to pause while the circuit is open, got %d claims", claims)
+	}
+
+	ctx, w := newTestContext(http.MethodGet, "/api/v1/messaging/status", "", nil)
+	controller.GetStatus(ctx)
+
+	var status StatusResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	breaker := status.Providers[client.DefaultProviderName]
+	if !status.Paused || status.ResumesAt == nil || breaker.State != client.BreakerOpen || breaker.ConsecutiveFailures != 1 || breaker.NextProbeAt == nil {
+		t.Errorf("Expected paused dispatcher with open webhook circuit, got %s", w.Body.String())
+	}
+}
+
+func TestMessageController_DefersMessagesPinnedToOpenCircuit(t *testing.T) {
+	providers := client.NewRegistry(client.DefaultProviderName)
+	providers.SetCircuitBreaker(config.CircuitBreaker{FailureThreshold: 1, Cooldown: time.Minute})
+	providers.Register(client.DefaultProviderName, &mockWebhookClient{})
+	providers.Register("sms", &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			t.Error("Expected no send through the provider with an open circuit")
+			return nil, nil
+		},
+	})
+	providers.Breaker("sms").Failure()
+
+	var deferred []uint
+	repo := &mockMessageRepository{
+		deferClaimFunc: func(ctx context.Context, id uint, owner string, until time.Time, reason string) error {
+			deferred = append(deferred, id)
+			return nil
+		},
+		releaseClaimsFunc: func(ctx context.Context, owner string, ids []uint) error {
+			t.Errorf("Expected no message to be released, got %v", ids)
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, providers, &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	// Another provider is healthy, so dispatch goes on, but the pinned message
+	// is not claimed again before the probe
+	msg := &model.Message{ID: 1, To: "+15550100", Channel: model.ChannelSMS, Provider: "sms", Status: model.MessageStatusProcessing, ClaimedBy: "test"}
+	result := controller.sendBatch(context.Background(), []*model.Message{msg}, 1, 0)
+	if result.Deferred != 1 || result.Skipped != 0 || len(deferred) != 1 {
+		t.Errorf("Expected the pinned message to be deferred, got %+v", result)
+	}
+}
+
+func TestMessageController_SendsAtScheduledTime(t *testing.T) {
+	// An in-memory queue of pending messages, shared with the dispatcher goroutine
+	var mu sync.Mutex
+	var pending []*model.Message
+	sent := make(chan time.Time, 2)
+
+	repo := &mockMessageRepository{
+		createFunc: func(ctx context.Context, message *model.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			message.ID = uint(len(pending) + 1)
+			stored := *message
+			pending = append(pending, &stored)
+			return nil
+		},
+		nextDueAtFunc: func(ctx context.Context) (*time.Time, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			var next *time.Time
+			for _, msg := range pending {
+				if msg.Status == model.MessageStatusPending && (next == nil || msg.ScheduledAt.Before(*next)) {
+					next = &msg.ScheduledAt
+				}
+			}
+			return next, nil
+		},
+		claimPendingFunc: func(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			var claimed []*model.Message
+			for _, msg := range pending {
+				if msg.Status == model.MessageStatusPending && !msg.ScheduledAt.After(before) {
+					msg.Status = model.MessageStatusProcessing
+					claimed = append(claimed, &model.Message{ID: msg.ID, To: msg.To, Status: msg.Status, ClaimedBy: owner})
+				}
+			}
+			return claimed, nil
+		},
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+			sent <- time.Now()
+			return nil
+		},
+	}
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			return &model.WebhookResponse{MessageID: "scheduled"}, nil
+		},
+	}
+	// The sweep interval is far longer than the test, so only the scheduler can send on time
+	controller := NewMessageController(repo, testProviders(webhookClient), &mockMessageCache{}, config.Dispatcher{Interval: time.Hour}, nil)
+
+	create := func(scheduledAt time.Time) {
+		body := fmt.Sprintf(`{"content": "Test", "to": "test@example.com", "scheduled_at": %q}`, scheduledAt.Format(time.RFC3339Nano))
+		ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", body, nil)
+		controller.CreateMessage(ctx)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("CreateMessage() status = %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	later := time.Now().Add(400 * time.Millisecond)
+	create(later)
+	if err := controller.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer controller.Stop()
+
+	// A message created while the dispatcher sleeps until the later one wakes it early
+	sooner := time.Now().Add(150 * time.Millisecond)
+	create(sooner)
+
+	for _, scheduledAt := range []time.Time{sooner, later} {
+		select {
+		case at := <-sent:
+			if delay := at.Sub(scheduledAt); delay < 0 || delay > 100*time.Millisecond {
+				t.Errorf("Expected message scheduled at %s to go out on time, sent %s late", scheduledAt.Format(time.StampMilli), delay)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Scheduled message was not sent")
+		}
+	}
+}
+
+func TestQuotaBuckets(t *testing.T) {
+	quotas := config.Quotas{
+		Global:       config.Quota{Rate: 50, Burst: 100},
+		PerRecipient: config.Quota{Rate: 0.1},
+		PerDomain:    config.Quota{Rate: 10, Burst: 20},
+	}
+
+	tests := []struct {
+		name     string
+		quotas   config.Quotas
+		msg      *model.Message
+		expected []cache.RateBucket
+	}{
+		{
+			name:   "email counts against every quota",
+			quotas: quotas,
+			msg:    &model.Message{To: "Test@Example.com"},
+			expected: []cache.RateBucket{
+				{Key: "global", Rate: 50, Burst: 100},
+				{Key: "recipient:email:test@example.com", Rate: 0.1, Burst: 1},
+				{Key: "domain:example.com", Rate: 10, Burst: 20},
+			},
+		},
+		{
+			name:   "sms has no domain",
+			quotas: quotas,
+			msg:    &model.Message{To: "+442079460958", Channel: model.ChannelSMS},
+			expected: []cache.RateBucket{
+				{Key: "global", Rate: 50, Burst: 100},
+				{Key: "recipient:sms:+442079460958", Rate: 0.1, Burst: 1},
+			},
+		},
+		{
+			name: "no quotas configured",
+			msg:  &model.Message{To: "test@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := quotaBuckets(tt.quotas, tt.msg)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected buckets %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMessageController_DefersThrottledMessages(t *testing.T) {
+	var sends []uint
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			return &model.WebhookResponse{MessageID: "sent"}, nil
+		},
+	}
+
+	// One token per recipient bucket, the second send to a recipient has to wait
+	taken := map[string]bool{}
+	messageCache := &mockMessageCache{
+		takeTokensFunc: func(ctx context.Context, buckets []cache.RateBucket) (time.Duration, error) {
+			for _, bucket := range buckets {
+				if taken[bucket.Key] {
+					return 10 * time.Second, nil
+				}
+			}
+			for _, bucket := range buckets {
+				taken[bucket.Key] = true
+			}
+			return 0, nil
+		},
+	}
+
+	var deferred []uint
+	var deferredUntil time.Time
+	repo := &mockMessageRepository{
+		claimPendingFunc: func(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error) {
+			return []*model.Message{
+				{ID: 1, To: "a@example.com", Status: model.MessageStatusProcessing, ClaimedBy: owner},
+				{ID: 2, To: "a@example.com", Status: model.MessageStatusProcessing, ClaimedBy: owner},
+				{ID: 3, To: "b@example.com", Status: model.MessageStatusProcessing, ClaimedBy: owner},
+			}, nil
+		},
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+			sends = append(sends, id)
+			return nil
+		},
+		deferClaimFunc: func(ctx context.Context, id uint, owner string, until time.Time, reason string) error {
+			deferred = append(deferred, id)
+			deferredUntil = until
+			return nil
+		},
+		rescheduleAttemptFunc: func(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error {
+			t.Errorf("Throttled message %d must not use up an attempt", id)
+			return nil
+		},
+	}
+	settings := config.Dispatcher{BatchSize: 3, Quotas: config.Quotas{PerRecipient: config.Quota{Rate: 0.1}}}
+	controller := NewMessageController(repo, testProviders(webhookClient), messageCache, settings, nil)
+
+	result, err := controller.processMessages(context.Background())
+	if err != nil {
+		t.Fatalf("processMessages() error = %v", err)
+	}
+	if result.Sent != 2 || result.Deferred != 1 || result.Failed != 0 {
+		t.Errorf("Expected 2 sent and 1 deferred, got %+v", result)
+	}
+	if len(sends) != 2 || sends[0] != 1 || sends[1] != 3 {
+		t.Errorf("Expected messages 1 and 3 to be sent, got %v", sends)
+	}
+	if len(deferred) != 1 || deferred[0] != 2 || time.Until(deferredUntil) < 9*time.Second {
+		t.Errorf("Expected message 2 to be deferred by the limiter's wait, got %v until %s", deferred, deferredUntil)
+	}
+
+	// Without Redis the quotas are not enforced
+	messageCache.takeTokensFunc = func(ctx context.Context, buckets []cache.RateBucket) (time.Duration, error) {
+		return 0, errors.New("connection refused")
+	}
+	sends, deferred = nil, nil
+	if _, err := controller.processMessages(context.Background()); err != nil {
+		t.Fatalf("processMessages() error = %v", err)
+	}
+	if len(sends) != 3 || len(deferred) != 0 {
+		t.Errorf("Expected every message to be sent while Redis is down, got %v sent and %v deferred", sends, deferred)
+	}
+}
+
+func TestMessageController_CreateMessagePriority(t *testing.T) {
+	tests := []struct {
+		name             string
+		priority         string
+		expectedStatus   int
+		expectedPriority string
+	}{
+		{name: "normal is the default priority", expectedStatus: http.StatusCreated, expectedPriority: model.PriorityNormal},
+		{name: "critical", priority: "critical", expectedStatus: http.StatusCreated, expectedPriority: model.PriorityCritical},
+		{name: "priority is case-insensitive", priority: "Low", expectedStatus: http.StatusCreated, expectedPriority: model.PriorityLow},
+		{name: "unknown priority", priority: "urgent", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created *model.Message
+			repo := &mockMessageRepository{
+				createFunc: func(ctx context.Context, message *model.Message) error {
+					created = message
+					return nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			body, _ := json.Marshal(map[string]string{
+				"content":      "Your code is 123456",
+				"to":           "test@example.com",
+				"priority":     tt.priority,
+				"scheduled_at": "2024-04-26T10:00:00Z",
+			})
+			ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", string(body), nil)
+			controller.CreateMessage(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus == http.StatusCreated && created.Priority != tt.expectedPriority {
+				t.Errorf("Expected priority %q, got %q", tt.expectedPriority, created.Priority)
+			}
+		})
+	}
+}
+
+func TestFairSlots(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		share     float64
+		min, max  int
+	}{
+		{name: "disabled", batchSize: 10, share: 0, min: 0, max: 0},
+		{name: "whole slots", batchSize: 10, share: 0.2, min: 2, max: 2},
+		{name: "fractional slot", batchSize: 10, share: 0.25, min: 2, max: 3},
+		{name: "batch of one", batchSize: 1, share: 0.2, min: 0, max: 1},
+		{name: "whole batch", batchSize: 4, share: 1, min: 4, max: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := fairSlots(tt.batchSize, tt.share); got < tt.min || got > tt.max {
+					t.Fatalf("fairSlots(%d, %g) = %d, expected between %d and %d", tt.batchSize, tt.share, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+
+	// A batch of one message still leaves room for low priorities in about share of the runs
+	fair := 0
+	for i := 0; i < 1000; i++ {
+		fair += fairSlots(1, 0.2)
+	}
+	if fair < 100 || fair > 300 {
+		t.Errorf("Expected about 200 fair slots in 1000 batches of one, got %d", fair)
+	}
+}
+
+func TestMessageController_CreateMessageExpiry(t *testing.T) {
+	scheduledAt := time.Date(2024, 4, 26, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name            string
+		fields          map[string]string
+		expectedStatus  int
+		expectedExpires *time.Time
+	}{
+		{name: "never expires by default", expectedStatus: http.StatusCreated},
+		{
+			name:            "ttl counts from the scheduled time",
+			fields:          map[string]string{"ttl": "6h"},
+			expectedStatus:  http.StatusCreated,
+			expectedExpires: ptrTime(scheduledAt.Add(6 * time.Hour)),
+		},
+		{
+			name:            "explicit expiry",
+			fields:          map[string]string{"expires_at": "2024-04-26T12:00:00Z"},
+			expectedStatus:  http.StatusCreated,
+			expectedExpires: ptrTime(scheduledAt.Add(3 * time.Hour)),
+		},
+		{name: "expiry before the scheduled time", fields: map[string]string{"expires_at": "2024-04-26T08:00:00Z"}, expectedStatus: http.StatusBadRequest},
+		{name: "invalid ttl", fields: map[string]string{"ttl": "-1h"}, expectedStatus: http.StatusBadRequest},
+		{name: "both expiry and ttl", fields: map[string]string{"ttl": "1h", "expires_at": "2024-04-26T12:00:00Z"}, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created *model.Message
+			repo := &mockMessageRepository{
+				createFunc: func(ctx context.Context, message *model.Message) error {
+					created = message
+					return nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			fields := map[string]string{
+				"content":      "Your appointment is at 10:00",
+				"to":           "test@example.com",
+				"scheduled_at": scheduledAt.Format(time.RFC3339),
+			}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			body, _ := json.Marshal(fields)
+			ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", string(body), nil)
+			controller.CreateMessage(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+			if (created.ExpiresAt == nil) != (tt.expectedExpires == nil) ||
+				(created.ExpiresAt != nil && !created.ExpiresAt.Equal(*tt.expectedExpires)) {
+				t.Errorf("Expected expiry %v, got %v", tt.expectedExpires, created.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestMessageController_ExpiresOverdueMessages(t *testing.T) {
+	var sends []string
+	webhookClient := &mockWebhookClient{
+		sendMessageFunc: func(ctx context.Context, req *model.WebhookRequest) (*model.WebhookResponse, error) {
+			sends = append(sends, req.Content)
+			return &model.WebhookResponse{MessageID: "sent"}, nil
+		},
+	}
+
+	var sweptAt time.Time
+	var expired []uint
+	repo := &mockMessageRepository{
+		expireOverdueFunc: func(ctx context.Context, now time.Time) (int64, error) {
+			sweptAt = now
+			return 3, nil
+		},
+		claimPendingFunc: func(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error) {
+			return []*model.Message{
+				// Expired between the sweep and its turn in the batch
+				{ID: 1, Content: "Stale", To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: owner, ExpiresAt: ptrTime(time.Now().Add(-time.Millisecond))},
+				{ID: 2, Content: "Fresh", To: "test@example.com", Status: model.MessageStatusProcessing, ClaimedBy: owner, ExpiresAt: ptrTime(time.Now().Add(time.Hour))},
+			}, nil
+		},
+		markExpiredFunc: func(ctx context.Context, id uint, owner string) error {
+			expired = append(expired, id)
+			return nil
+		},
+		markSentFunc: func(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(webhookClient), &mockMessageCache{}, config.Dispatcher{BatchSize: 2}, nil)
+
+	result, err := controller.processMessages(context.Background())
+	if err != nil {
+		t.Fatalf("processMessages() error = %v", err)
+	}
+	if sweptAt.IsZero() {
+		t.Error("Expected overdue pending messages to be expired before claiming")
+	}
+	if result.Sent != 1 || result.Expired != 1 || result.Failed != 0 {
+		t.Errorf("Expected 1 sent and 1 expired, got %+v", result)
+	}
+	if len(expired) != 1 || expired[0] != 1 {
+		t.Errorf("Expected message 1 to be marked expired, got %v", expired)
+	}
+	if len(sends) != 1 || sends[0] != "Fresh" {
+		t.Errorf("Expected only the fresh message to be sent, got %v", sends)
+	}
+
+	// Expired messages show up in the stats
+	repo.countByStatusFunc = func(ctx context.Context) (map[string]int64, error) {
+		return map[string]int64{model.MessageStatusSent: 1, model.MessageStatusExpired: 4}, nil
+	}
+	ctx, w := newTestContext(http.MethodGet, "/api/v1/messaging/stats", "", nil)
+	controller.GetStats(ctx)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"messages":{"expired":4,"sent":1}`) {
+		t.Errorf("Unexpected stats %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMessageController_CreateSchedule(t *testing.T) {
+	tests := []struct {
+		name           string
+		fields         map[string]interface{}
+		expectedStatus int
+	}{
+		{name: "cron", fields: map[string]interface{}{"cron": "0 9 * * MON-FRI", "timezone": "Europe/Berlin"}, expectedStatus: http.StatusCreated},
+		{name: "rrule", fields: map[string]interface{}{"rrule": "FREQ=WEEKLY;BYDAY=MO;BYHOUR=9;BYMINUTE=0"}, expectedStatus: http.StatusCreated},
+		{name: "no rule", expectedStatus: http.StatusBadRequest},
+		{name: "both rules", fields: map[string]interface{}{"cron": "@daily", "rrule": "FREQ=DAILY"}, expectedStatus: http.StatusBadRequest},
+		{name: "invalid cron", fields: map[string]interface{}{"cron": "0 25 * * *"}, expectedStatus: http.StatusBadRequest},
+		{name: "invalid rrule", fields: map[string]interface{}{"rrule": "FREQ=SECONDLY"}, expectedStatus: http.StatusBadRequest},
+		{name: "unknown timezone", fields: map[string]interface{}{"cron": "@daily", "timezone": "Mars/Olympus"}, expectedStatus: http.StatusBadRequest},
+		{name: "invalid message", fields: map[string]interface{}{"cron": "@daily", "priority": "urgent"}, expectedStatus: http.StatusBadRequest},
+		{
+			name:           "end before start",
+			fields:         map[string]interface{}{"cron": "@daily", "start_at": "2030-01-02T00:00:00Z", "end_at": "2030-01-01T00:00:00Z"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "rule ends before it starts",
+			fields:         map[string]interface{}{"rrule": "FREQ=DAILY;UNTIL=20200101T000000Z"},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created *model.RecurringSchedule
+			var advanced []*model.Message
+			repo := &mockMessageRepository{
+				createScheduleFunc: func(ctx context.Context, schedule *model.RecurringSchedule) error {
+					schedule.ID = 7
+					created = schedule
+					return nil
+				},
+				advanceScheduleFunc: func(ctx context.Context, schedule *model.RecurringSchedule, messages []*model.Message) error {
+					advanced = messages
+					return nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{ScheduleHorizon: 8 * 24 * time.Hour}, nil)
+
+			fields := map[string]interface{}{"content": "Weekly report", "to": "test@example.com"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			body, _ := json.Marshal(fields)
+			ctx, w := newTestContext(http.MethodPost, "/api/v1/schedules", string(body), nil)
+			controller.CreateSchedule(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+			if created.Status != model.ScheduleStatusActive {
+				t.Errorf("Expected an active schedule, got %q", created.Status)
+			}
+			// The messages within the horizon are created right away
+			if len(advanced) == 0 || created.Occurrences != len(advanced) {
+				t.Fatalf("Expected the first messages to be created, got %d for %d occurrences", len(advanced), created.Occurrences)
+			}
+			if created.NextRunAt == nil || !created.NextRunAt.After(advanced[len(advanced)-1].ScheduledAt) {
+				t.Errorf("Expected the next run after the created messages, got %v", created.NextRunAt)
+			}
+			for _, msg := range advanced {
+				if msg.ScheduleID == nil || *msg.ScheduleID != 7 || msg.Content != "Weekly report" || msg.Status != model.MessageStatusPending {
+					t.Errorf("Unexpected scheduled message %+v", msg)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageController_MaterializesSchedules(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	// Daily at nine in Berlin, across the switch to summer time
+	first := time.Date(2024, 3, 30, 8, 0, 0, 0, time.UTC)
+	newSchedule := func() *model.RecurringSchedule {
+		return &model.RecurringSchedule{
+			ID:        3,
+			Content:   "Good morning",
+			To:        "test@example.com",
+			TTL:       "1h",
+			Cron:      "0 9 * * *",
+			Timezone:  berlin.String(),
+			StartAt:   first.Add(-time.Hour),
+			Status:    model.ScheduleStatusActive,
+			NextRunAt: ptrTime(first),
+		}
+	}
+	horizon := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		maxOccurrences int
+		missedBefore   time.Time
+		expected       []string
+		expectedNext   string
+		expectedStatus string
+	}{
+		{
+			name:           "local time is kept across daylight saving",
+			missedBefore:   first,
+			expected:       []string{"2024-03-30T08:00:00Z", "2024-03-31T07:00:00Z", "2024-04-01T07:00:00Z"},
+			expectedNext:   "2024-04-02T07:00:00Z",
+			expectedStatus: model.ScheduleStatusActive,
+		},
+		{
+			name:           "missed occurrences are skipped",
+			missedBefore:   first.Add(time.Hour),
+			expected:       []string{"2024-03-31T07:00:00Z", "2024-04-01T07:00:00Z"},
+			expectedNext:   "2024-04-02T07:00:00Z",
+			expectedStatus: model.ScheduleStatusActive,
+		},
+		{
+			name:           "max occurrences completes the schedule",
+			maxOccurrences: 2,
+			missedBefore:   first,
+			expected:       []string{"2024-03-30T08:00:00Z", "2024-03-31T07:00:00Z"},
+			expectedStatus: model.ScheduleStatusCompleted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var advanced *model.RecurringSchedule
+			var messages []*model.Message
+			repo := &mockMessageRepository{
+				advanceScheduleFunc: func(ctx context.Context, schedule *model.RecurringSchedule, created []*model.Message) error {
+					advanced = schedule
+					messages = created
+					return nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			schedule := newSchedule()
+			schedule.MaxOccurrences = tt.maxOccurrences
+			if _, err := controller.materialize(context.Background(), schedule, tt.missedBefore, horizon); err != nil {
+				t.Fatalf("materialize() error = %v", err)
+			}
+
+			var got []string
+			for _, msg := range messages {
+				got = append(got, msg.ScheduledAt.UTC().Format(time.RFC3339))
+				if msg.ExpiresAt == nil || !msg.ExpiresAt.Equal(msg.ScheduledAt.Add(time.Hour)) {
+					t.Errorf("Expected the message to expire an hour after %s, got %v", msg.ScheduledAt, msg.ExpiresAt)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected messages at %v, got %v", tt.expected, got)
+			}
+			if advanced.Occurrences != len(tt.expected) || advanced.Status != tt.expectedStatus {
+				t.Errorf("Expected %d occurrences and status %q, got %d and %q", len(tt.expected), tt.expectedStatus, advanced.Occurrences, advanced.Status)
+			}
+			var next string
+			if advanced.NextRunAt != nil {
+				next = advanced.NextRunAt.UTC().Format(time.RFC3339)
+			}
+			if next != tt.expectedNext {
+				t.Errorf("Expected next run %q, got %q", tt.expectedNext, next)
+			}
+		})
+	}
+}
+
+func TestMessageController_PauseResumeSchedule(t *testing.T) {
+	stored := &model.RecurringSchedule{
+		ID:        4,
+		Content:   "Reminder",
+		To:        "test@example.com",
+		Cron:      "*/5 * * * *",
+		Timezone:  "UTC",
+		StartAt:   time.Now().Add(-24 * time.Hour),
+		Status:    model.ScheduleStatusActive,
+		NextRunAt: ptrTime(time.Now().Add(-time.Hour)),
+	}
+	var reasons []string
+	repo := &mockMessageRepository{
+		findScheduleByIDFunc: func(ctx context.Context, id uint) (*model.RecurringSchedule, error) {
+			if id != stored.ID {
+				return nil, gorm.ErrRecordNotFound
+			}
+			schedule := *stored
+			return &schedule, nil
+		},
+		updateScheduleFunc: func(ctx context.Context, schedule *model.RecurringSchedule, reason string) error {
+			reasons = append(reasons, reason)
+			stored = schedule
+			return nil
+		},
+	}
+	controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+	call := func(handler func(*gin.Context), id string) int {
+		ctx, w := newTestContext(http.MethodPost, "/api/v1/schedules/"+id, "", gin.Params{{Key: "id", Value: id}})
+		handler(ctx)
+		return w.Code
+	}
+
+	if code := call(controller.ResumeSchedule, "4"); code != http.StatusConflict {
+		t.Errorf("Expected resuming an active schedule to conflict, got %d", code)
+	}
+	if code := call(controller.PauseSchedule, "9"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown schedule, got %d", http.StatusNotFound, code)
+	}
+	if code := call(controller.PauseSchedule, "4"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if stored.Status != model.ScheduleStatusPaused {
+		t.Errorf("Expected the schedule to be paused, got %q", stored.Status)
+	}
+	if code := call(controller.PauseSchedule, "4"); code != http.StatusConflict {
+		t.Errorf("Expected pausing a paused schedule to conflict, got %d", code)
+	}
+
+	if code := call(controller.ResumeSchedule, "4"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	// Occurrences missed while paused are not caught up
+	if stored.Status != model.ScheduleStatusActive || stored.NextRunAt == nil || stored.NextRunAt.Before(time.Now()) {
+		t.Errorf("Expected the schedule to continue from now, got %q at %v", stored.Status, stored.NextRunAt)
+	}
+	if strings.Join(reasons, ",") != "schedule paused,schedule resumed" {
+		t.Errorf("Unexpected update reasons %v", reasons)
+	}
+}
+
+func TestMessageController_CreateMessageLocalTime(t *testing.T) {
+	tests := []struct {
+		name              string
+		fields            map[string]string
+		recipientTimezone string
+		lookupErr         error
+		expectedStatus    int
+		expectedAt        string
+		expectedLocal     string
+		expectedExpires   string
+		expectedRecipient bool
+	}{
+		{
+			name:           "local time in a given time zone",
+			fields:         map[string]string{"local_time": "2024-04-26T09:00", "timezone": "America/New_York"},
+			expectedStatus: http.StatusCreated,
+			expectedAt:     "2024-04-26T13:00:00Z",
+			expectedLocal:  "2024-04-26T09:00:00",
+		},
+		{
+			name:              "local time of the recipient",
+			fields:            map[string]string{"local_time": "2024-04-26T09:00:00", "ttl": "1h"},
+			recipientTimezone: "Asia/Tokyo",
+			expectedStatus:    http.StatusCreated,
+			expectedAt:        "2024-04-26T00:00:00Z",
+			expectedLocal:     "2024-04-26T09:00:00",
+			expectedExpires:   "2024-04-26T01:00:00Z",
+			expectedRecipient: true,
+		},
+		{
+			name:           "local time skipped by daylight saving is moved by the gap",
+			fields:         map[string]string{"local_time": "2024-03-10T02:30:00", "timezone": "America/New_York"},
+			expectedStatus: http.StatusCreated,
+			expectedAt:     "2024-03-10T07:30:00Z",
+			expectedLocal:  "2024-03-10T02:30:00",
+		},
+		{name: "recipient without time zone", fields: map[string]string{"local_time": "2024-04-26T09:00:00"}, expectedStatus: http.StatusBadRequest},
+		{name: "recipient lookup fails", fields: map[string]string{"local_time": "2024-04-26T09:00:00"}, lookupErr: errors.New("connection refused"), expectedStatus: http.StatusInternalServerError},
+		{name: "no send time", expectedStatus: http.StatusBadRequest},
+		{name: "both send times", fields: map[string]string{"local_time": "2024-04-26T09:00:00", "scheduled_at": "2024-04-26T09:00:00Z"}, expectedStatus: http.StatusBadRequest},
+		{name: "time zone without local time", fields: map[string]string{"scheduled_at": "2024-04-26T09:00:00Z", "timezone": "UTC"}, expectedStatus: http.StatusBadRequest},
+		{name: "local time with offset", fields: map[string]string{"local_time": "2024-04-26T09:00:00Z", "timezone": "UTC"}, expectedStatus: http.StatusBadRequest},
+		{name: "unknown time zone", fields: map[string]string{"local_time": "2024-04-26T09:00:00", "timezone": "Mars/Olympus"}, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created *model.Message
+			repo := &mockMessageRepository{
+				createFunc: func(ctx context.Context, message *model.Message) error {
+					created = message
+					return nil
+				},
+				findRecipientFunc: func(ctx context.Context, channel, to string) (*model.Recipient, error) {
+					if channel != model.ChannelEmail || to != "test@example.com" {
+						t.Errorf("Unexpected recipient lookup %s %s", channel, to)
+					}
+					if tt.lookupErr != nil {
+						return nil, tt.lookupErr
+					}
+					if tt.recipientTimezone == "" {
+						return nil, gorm.ErrRecordNotFound
+					}
+					return &model.Recipient{Channel: channel, To: to, Timezone: tt.recipientTimezone}, nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			fields := map[string]string{"content": "Good morning", "to": " test@example.com "}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			body, _ := json.Marshal(fields)
+			ctx, w := newTestContext(http.MethodPost, "/api/v1/messages", string(body), nil)
+			controller.CreateMessage(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+			if at := created.ScheduledAt.Format(time.RFC3339); at != tt.expectedAt {
+				t.Errorf("Expected scheduled_at %s, got %s", tt.expectedAt, at)
+			}
+			// The intent is kept so that the send time can be recomputed
+			if created.LocalTime != tt.expectedLocal {
+				t.Errorf("Expected local_time %q, got %q", tt.expectedLocal, created.LocalTime)
+			}
+			if created.Timezone == "" || created.RecipientTimezone != tt.expectedRecipient {
+				t.Errorf("Expected time zone %q to be stored with recipient_timezone %v, got %+v", created.Timezone, tt.expectedRecipient, created)
+			}
+			if tt.expectedExpires != "" && (created.ExpiresAt == nil || created.ExpiresAt.Format(time.RFC3339) != tt.expectedExpires) {
+				t.Errorf("Expected expires_at %s, got %v", tt.expectedExpires, created.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestMessageController_SaveRecipient(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "email recipient", body: `{"to": "Test@Example.com", "timezone": "Europe/Berlin"}`, expectedStatus: http.StatusOK},
+		{name: "sms recipient", body: `{"to": "+49 170 1234567", "channel": "sms", "timezone": "Europe/Berlin"}`, expectedStatus: http.StatusOK},
+		{name: "unknown time zone", body: `{"to": "test@example.com", "timezone": "Europe/Atlantis"}`, expectedStatus: http.StatusBadRequest},
+		{name: "invalid recipient", body: `{"to": "not an address", "timezone": "UTC"}`, expectedStatus: http.StatusBadRequest},
+		{name: "missing time zone", body: `{"to": "test@example.com"}`, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved *model.Recipient
+			repo := &mockMessageRepository{
+				saveRecipientFunc: func(ctx context.Context, recipient *model.Recipient) (int64, error) {
+					saved = recipient
+					recipient.ID = 1
+					return 2, nil
+				},
+			}
+			controller := NewMessageController(repo, testProviders(&mockWebhookClient{}), &mockMessageCache{}, config.Dispatcher{}, nil)
+
+			ctx, w := newTestContext(http.MethodPut, "/api/v1/recipients", tt.body, nil)
+			controller.SaveRecipient(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if saved.Timezone != "Europe/Berlin" || (saved.To != "Test@Example.com" && saved.To != "+491701234567") {
+				t.Errorf("Unexpected saved recipient %+v", saved)
+			}
+			if !strings.Contains(w.Body.String(), `"rescheduled":2`) {
+				t.Errorf("Expected the rescheduled messages to be counted, got %s", w.Body.String())
+			}
+		})
+	}
+}
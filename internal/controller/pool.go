@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"auto-messaging/internal/client"
+	"auto-messaging/internal/model"
+	"auto-messaging/internal/repository"
+)
+
+// batchResult aggregates the outcome of sending one batch
+type batchResult struct {
+	Sent     int
+	Failed   int
+	Deferred int
+	Expired  int
+	Skipped  int
+	Duration time.Duration
+}
+
+// fairSlots returns how many messages of a batch are claimed by scheduled time
+// regardless of priority. A fractional slot is granted to the matching share of
+// batches, so that even batches of one message leave room for low priorities.
+func fairSlots(batchSize int, share float64) int {
+	if share <= 0 || batchSize < 1 {
+		return 0
+	}
+	exact := float64(batchSize) * share
+	slots := int(exact)
+	if rand.Float64() < exact-float64(slots) {
+		slots++
+	}
+	return min(slots, batchSize)
+}
+
+// sendBatch sends messages in parallel with at most workers sends in flight.
+// Once ctx is cancelled no new sends are started and the ones in flight are
+// interrupted; those messages are counted as skipped and released for the next
+// run. Messages whose providers all have an open circuit are deferred until the
+// first admits a probe, or skipped as well if it is already due. Messages whose
+// lease was lost to another replica are skipped as well but left to it.
+func (c *MessageController) sendBatch(ctx context.Context, messages []*model.Message, workers int, rate float64) batchResult {
+	start := time.Now()
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(messages) {
+		workers = len(messages)
+	}
+
+	jobs := make(chan *model.Message)
+	var sent, failed, deferred, expired, skipped atomic.Int64
+	var wg sync.WaitGroup
+
+	var skippedMu sync.Mutex
+	var skippedIDs []uint
+	skip := func(msgs ...*model.Message) {
+		skippedMu.Lock()
+		for _, msg := range msgs {
+			skippedIDs = append(skippedIDs, msg.ID)
+		}
+		skippedMu.Unlock()
+		skipped.Add(int64(len(msgs)))
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				if ctx.Err() != nil {
+					skip(msg)
+					continue
+				}
+				if err := c.throttle.wait(ctx, rate); err != nil {
+					skip(msg)
+					continue
+				}
+				if err := c.processMessage(ctx, msg); err != nil {
+					if errors.Is(err, repository.ErrLeaseLost) {
+						c.logger.Printf("Lease of message %d was lost, leaving it to its new owner", msg.ID)
+						skipped.Add(1)
+						continue
+					}
+					if (ctx.Err() != nil && errors.Is(err, ctx.Err())) || errors.Is(err, client.ErrNoProviderAvailable) {
+						skip(msg)
+						continue
+					}
+					if errors.Is(err, ErrThrottled) || errors.Is(err, ErrUnavailable) {
+						deferred.Add(1)
+						continue
+					}
+					if errors.Is(err, ErrExpired) {
+						expired.Add(1)
+						continue
+					}
+					c.logger.Printf("Failed to process message %d: %v", msg.ID, err)
+					failed.Add(1)
+					continue
+				}
+				sent.Add(1)
+			}
+		}()
+	}
+
+dispatch:
+	for i, msg := range messages {
+		select {
+		case jobs <- msg:
+		case <-ctx.Done():
+			skip(messages[i:]...)
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Skipped messages were claimed but not delivered, release them right away
+	// instead of waiting for their lease to expire
+	if len(skippedIDs) > 0 {
+		owner := messages[0].ClaimedBy
+		releaseCtx := repository.WithActor(context.WithoutCancel(ctx), model.ActorDispatcher, owner)
+		if err := c.repo.ReleaseClaims(releaseCtx, owner, skippedIDs); err != nil {
+			c.logger.Printf("Failed to release %d skipped messages: %v", len(skippedIDs), err)
+		}
+	}
+
+	return batchResult{
+		Sent:     int(sent.Load()),
+		Failed:   int(failed.Load()),
+		Deferred: int(deferred.Load()),
+		Expired:  int(expired.Load()),
+		Skipped:  int(skipped.Load()),
+		Duration: time.Since(start),
+	}
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"auto-messaging/config"
+	"auto-messaging/internal/model"
+	"auto-messaging/pkg/cache"
+)
+
+var (
+	ErrThrottled = errors.New("send deferred by rate limit")
+)
+
+// quotaBuckets returns the shared token buckets a send of msg counts against.
+// The per-domain quota only applies to email recipients.
+func quotaBuckets(quotas config.Quotas, msg *model.Message) []cache.RateBucket {
+	var buckets []cache.RateBucket
+	add := func(key string, quota config.Quota) {
+		if quota.Rate <= 0 {
+			return
+		}
+		burst := quota.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		buckets = append(buckets, cache.RateBucket{Key: key, Rate: quota.Rate, Burst: burst})
+	}
+
+	channel := msg.Channel
+	if channel == "" {
+		channel = model.ChannelEmail
+	}
+	to := strings.ToLower(msg.To)
+
+	add("global", quotas.Global)
+	add("recipient:"+channel+":"+to, quotas.PerRecipient)
+	if at := strings.LastIndex(to, "@"); channel == model.ChannelEmail && at >= 0 {
+		add("domain:"+to[at+1:], quotas.PerDomain)
+	}
+	return buckets
+}
+
+// waitForQuota takes a send of msg from the shared quotas. It returns zero if
+// msg may be sent now, or else how long it has to wait. When Redis cannot be
+// reached the quotas are not enforced, so that an outage does not stop all
+// sends.
+func (c *MessageController) waitForQuota(ctx context.Context, msg *model.Message) time.Duration {
+	buckets := quotaBuckets(c.Settings().Quotas, msg)
+	if len(buckets) == 0 {
+		return 0
+	}
+
+	wait, err := c.cache.TakeTokens(ctx, buckets)
+	if err != nil {
+		c.logger.Printf("Failed to check rate limits for message %d, sending anyway: %v", msg.ID, err)
+		return 0
+	}
+	return wait
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auto-messaging/internal/model"
+	"auto-messaging/pkg/recurrence"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RecipientRequest represents the request body for setting the time zone of
+// a recipient
+type RecipientRequest struct {
+	To string `json:"to" binding:"required"`
+	// Channel is one of email, sms, push or generic, email if empty
+	Channel string `json:"channel,omitempty" enums:"email,sms,push,generic" example:"email"`
+	// Timezone is the IANA time zone the recipient lives in
+	Timezone string `json:"timezone" binding:"required" example:"America/New_York"`
+}
+
+// RecipientResponse is returned when a recipient is saved
+type RecipientResponse struct {
+	Recipient *model.Recipient `json:"recipient"`
+	// Rescheduled counts the pending messages moved to the new time zone
+	Rescheduled int64 `json:"rescheduled"`
+}
+
+// resolveLocalTime checks that a request has exactly one send time and sets
+// ScheduledAt from LocalTime if that is the one given. LocalTime is read in
+// Timezone or, if that is empty, in the time zone stored for the recipient.
+func (c *MessageController) resolveLocalTime(ctx context.Context, req *CreateMessageRequest) error {
+	if req.LocalTime == "" {
+		if req.ScheduledAt.IsZero() {
+			return ErrMissingSendTime
+		}
+		if req.Timezone != "" {
+			return ErrTimezoneNeedsLocal
+		}
+		return nil
+	}
+	if !req.ScheduledAt.IsZero() {
+		return ErrSendTimeConflict
+	}
+
+	wall, err := model.ParseLocalTime(req.LocalTime)
+	if err != nil {
+		return err
+	}
+	if req.Timezone == "" {
+		recipient, err := c.repo.FindRecipient(ctx, req.Channel, req.To)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNoRecipientTimezone
+			}
+			return fmt.Errorf("%w: %v", errRecipientLookup, err)
+		}
+		req.Timezone = recipient.Timezone
+		req.recipientTimezone = true
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnknownTimezone, req.Timezone)
+	}
+
+	req.LocalTime = wall.Format(model.LocalTimeLayout)
+	req.Timezone = loc.String()
+	req.ScheduledAt = recurrence.WallClock(wall, loc).UTC()
+	return nil
+}
+
+// @Summary Get all recipients
+// @Description Get a list of all recipients with a stored time zone
+// @Tags recipients
+// @Produce json
+// @Success 200 {array} model.Recipient
+// @Failure 500 {object} ErrorResponse
+// @Router /recipients [get]
+func (c *MessageController) GetRecipients(ctx *gin.Context) {
+	recipients, err := c.repo.FindRecipients(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get recipients"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, recipients)
+}
+
+// @Summary Set the time zone of a recipient
+// @Description Create a recipient or change its time zone. Pending messages scheduled at a local time in the recipient's time zone are moved to that local time in the new zone.
+// @Tags recipients
+// @Accept json
+// @Produce json
+// @Param recipient body RecipientRequest true "Recipient details"
+// @Success 200 {object} RecipientResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /recipients [put]
+func (c *MessageController) SaveRecipient(ctx *gin.Context) {
+	var req RecipientRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if req.Channel == "" {
+		req.Channel = model.ChannelEmail
+	}
+	if !model.ValidChannel(req.Channel) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("%v: %s", model.ErrUnknownChannel, req.Channel)})
+		return
+	}
+	to, err := model.NormalizeRecipient(req.Channel, req.To)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("%v: %s", ErrUnknownTimezone, req.Timezone)})
+		return
+	}
+
+	recipient := &model.Recipient{Channel: req.Channel, To: to, Timezone: loc.String()}
+	rescheduled, err := c.repo.SaveRecipient(apiContext(ctx), recipient)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save recipient"})
+		return
+	}
+
+	if rescheduled > 0 {
+		c.schedule(time.Now())
+		c.logger.Printf("Rescheduled %d messages of recipient %d to %s", rescheduled, recipient.ID, recipient.Timezone)
+	}
+	ctx.JSON(http.StatusOK, RecipientResponse{Recipient: recipient, Rescheduled: rescheduled})
+}
+
+// @Summary Delete a recipient
+// @Description Delete the stored time zone of a recipient. Pending messages keep the time they are scheduled at.
+// @Tags recipients
+// @Produce json
+// @Param id path int true "Recipient ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /recipients/{id} [delete]
+func (c *MessageController) DeleteRecipient(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid recipient ID"})
+		return
+	}
+
+	if err := c.repo.DeleteRecipient(ctx.Request.Context(), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Recipient not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete recipient"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
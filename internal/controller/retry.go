@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"auto-messaging/config"
+	"auto-messaging/internal/client"
+	"auto-messaging/internal/model"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBaseDelay   = 30 * time.Second
+	defaultMaxDelay    = time.Hour
+)
+
+// retryWithDefaults fills in zero-valued retry settings
+func retryWithDefaults(r config.Retry) config.Retry {
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = defaultMaxAttempts
+	}
+	if r.BaseDelay <= 0 {
+		r.BaseDelay = defaultBaseDelay
+	}
+	if r.MaxDelay <= 0 {
+		r.MaxDelay = defaultMaxDelay
+	}
+	if r.MaxDelay < r.BaseDelay {
+		r.MaxDelay = r.BaseDelay
+	}
+	return r
+}
+
+// retryDelay returns the jittered exponential backoff before the given retry
+// attempt. The delay doubles with every attempt up to maxDelay, and the actual
+// value is drawn from the upper half of that window so that messages failing
+// together do not retry in lockstep.
+func retryDelay(attempt int, base, maxDelay time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// handleSendFailure reschedules a message after a failed send, or marks it
+// failed once it has used up its attempts or the provider rejected it for good
+func (c *MessageController) handleSendFailure(ctx context.Context, msg *model.Message, sendErr error) error {
+	retry := c.Settings().Retry
+	attempt := msg.AttemptCount + 1
+
+	if client.IsPermanent(sendErr) {
+		c.logger.Printf("Message %d rejected by provider on attempt %d, not retrying: %v", msg.ID, attempt, sendErr)
+		return c.repo.MarkFailed(ctx, msg.ID, msg.ClaimedBy, sendErr.Error())
+	}
+	if attempt >= retry.MaxAttempts {
+		c.logger.Printf("Message %d failed permanently after %d attempts: %v", msg.ID, attempt, sendErr)
+		return c.repo.MarkFailed(ctx, msg.ID, msg.ClaimedBy, sendErr.Error())
+	}
+
+	// Never retry sooner than the provider asked us to
+	delay := retryDelay(attempt, retry.BaseDelay, retry.MaxDelay)
+	if retryAfter := client.RetryAfter(sendErr); retryAfter > delay {
+		delay = retryAfter
+	}
+	next := time.Now().Add(delay)
+	c.logger.Printf("Message %d attempt %d failed, retrying at %s: %v", msg.ID, attempt, next.Format(time.RFC3339), sendErr)
+	return c.repo.RescheduleAttempt(ctx, msg.ID, msg.ClaimedBy, sendErr.Error(), next)
+}
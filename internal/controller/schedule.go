@@ -0,0 +1,516 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auto-messaging/internal/model"
+	"auto-messaging/internal/repository"
+	"auto-messaging/pkg/recurrence"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrScheduleRule          = errors.New("exactly one of cron and rrule must be set")
+	ErrUnknownTimezone       = errors.New("unknown timezone")
+	ErrInvalidScheduleEnd    = errors.New("end_at must be after start_at")
+	ErrInvalidMaxOccurrences = errors.New("max_occurrences must not be negative")
+	ErrNoOccurrences         = errors.New("schedule has no further occurrences")
+)
+
+const (
+	// scheduleBatchSize is the maximum number of schedules materialized per run
+	scheduleBatchSize = 100
+	// maxOccurrencesPerRun is the maximum number of occurrences of one
+	// schedule handled per run, the rest follow on the next runs
+	maxOccurrencesPerRun = 1000
+)
+
+// ScheduleRequest represents the request body for creating or replacing a
+// recurring schedule
+type ScheduleRequest struct {
+	Content string `json:"content" binding:"required"`
+	To      string `json:"to" binding:"required"`
+	// Channel is one of email, sms, push or generic, email if empty
+	Channel string `json:"channel,omitempty" enums:"email,sms,push,generic" example:"email"`
+	// Provider names the delivery provider, the channel's provider if empty
+	Provider string `json:"provider,omitempty" example:"webhook"`
+	// Priority is one of critical, high, normal or low, normal if empty
+	Priority string `json:"priority,omitempty" enums:"critical,high,normal,low" example:"normal"`
+	// TTL is how long after its occurrence each message expires, never if empty
+	TTL string `json:"ttl,omitempty" example:"2h"`
+
+	// Cron is a five field cron expression, exclusive with RRule
+	Cron string `json:"cron,omitempty" example:"0 9 * * MON-FRI"`
+	// RRule is an iCalendar recurrence rule, exclusive with Cron
+	RRule string `json:"rrule,omitempty" example:"FREQ=WEEKLY;BYDAY=MO,WE,FR;BYHOUR=9;BYMINUTE=0"`
+	// Timezone is the IANA time zone the schedule is read in, UTC if empty
+	Timezone string `json:"timezone,omitempty" example:"Europe/Berlin"`
+	// StartAt is when the schedule begins, now if empty
+	StartAt *time.Time `json:"start_at,omitempty"`
+	// EndAt is when the schedule ends, never if empty
+	EndAt *time.Time `json:"end_at,omitempty"`
+	// MaxOccurrences limits the number of messages created, 0 for no limit
+	MaxOccurrences int `json:"max_occurrences,omitempty" example:"10"`
+}
+
+// newSchedule validates req and returns the schedule it describes
+func (c *MessageController) newSchedule(ctx context.Context, req ScheduleRequest) (*model.RecurringSchedule, error) {
+	if (req.Cron == "") == (req.RRule == "") {
+		return nil, ErrScheduleRule
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTimezone, req.Timezone)
+	}
+	startAt := time.Now().Truncate(time.Second)
+	if req.StartAt != nil {
+		startAt = *req.StartAt
+	}
+	if req.EndAt != nil && !req.EndAt.After(startAt) {
+		return nil, ErrInvalidScheduleEnd
+	}
+	if req.MaxOccurrences < 0 {
+		return nil, ErrInvalidMaxOccurrences
+	}
+
+	// The message template is checked like a message created for the start
+	msg := CreateMessageRequest{
+		Content:     req.Content,
+		To:          req.To,
+		ScheduledAt: startAt,
+		Channel:     req.Channel,
+		Provider:    req.Provider,
+		Priority:    req.Priority,
+		TTL:         req.TTL,
+	}
+	if err := c.validate(ctx, &msg); err != nil {
+		return nil, err
+	}
+
+	schedule := &model.RecurringSchedule{
+		Content:        msg.Content,
+		To:             msg.To,
+		Channel:        msg.Channel,
+		Provider:       msg.Provider,
+		Priority:       msg.Priority,
+		TTL:            req.TTL,
+		Cron:           req.Cron,
+		RRule:          req.RRule,
+		Timezone:       loc.String(),
+		StartAt:        startAt,
+		EndAt:          req.EndAt,
+		MaxOccurrences: req.MaxOccurrences,
+	}
+	if _, err := scheduleRule(schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// scheduleRule parses the recurrence of schedule
+func scheduleRule(schedule *model.RecurringSchedule) (recurrence.Rule, error) {
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTimezone, schedule.Timezone)
+	}
+	if schedule.Cron != "" {
+		return recurrence.ParseCron(schedule.Cron, loc)
+	}
+	return recurrence.ParseRRule(schedule.RRule, schedule.StartAt.In(loc))
+}
+
+// nextOccurrence returns the first occurrence of schedule after t that lies
+// within its start and end, or nil if there is none
+func nextOccurrence(schedule *model.RecurringSchedule, rule recurrence.Rule, t time.Time) *time.Time {
+	if t.Before(schedule.StartAt) {
+		t = schedule.StartAt.Add(-time.Nanosecond)
+	}
+	next, ok := rule.Next(t)
+	if !ok || (schedule.EndAt != nil && next.After(*schedule.EndAt)) {
+		return nil
+	}
+	next = next.UTC()
+	return &next
+}
+
+// plan sets the status and next run of schedule for it to continue from now,
+// skipping the occurrences before
+func (c *MessageController) plan(schedule *model.RecurringSchedule, now time.Time) error {
+	rule, err := scheduleRule(schedule)
+	if err != nil {
+		return err
+	}
+	schedule.NextRunAt = nextOccurrence(schedule, rule, now.Add(-time.Nanosecond))
+	if schedule.Status != model.ScheduleStatusPaused {
+		schedule.Status = model.ScheduleStatusActive
+		if schedule.NextRunAt == nil {
+			schedule.Status = model.ScheduleStatusCompleted
+		}
+	}
+	return nil
+}
+
+// scheduledMessage returns the message of schedule for the occurrence at
+func scheduledMessage(schedule *model.RecurringSchedule, at time.Time) *model.Message {
+	msg := &model.Message{
+		Content:     schedule.Content,
+		To:          schedule.To,
+		Channel:     schedule.Channel,
+		Provider:    schedule.Provider,
+		Priority:    schedule.Priority,
+		ScheduledAt: at,
+		Status:      model.MessageStatusPending,
+		ScheduleID:  &schedule.ID,
+	}
+	if ttl, err := time.ParseDuration(schedule.TTL); err == nil && ttl > 0 {
+		expiresAt := at.Add(ttl)
+		msg.ExpiresAt = &expiresAt
+	}
+	return msg
+}
+
+// materializeSchedules creates the messages of active schedules whose
+// occurrences fall within the schedule horizon. Occurrences missed by more
+// than the sweep interval, for example while no dispatcher was running, are
+// skipped instead of being sent late.
+func (c *MessageController) materializeSchedules(ctx context.Context, now time.Time) {
+	settings := c.Settings()
+	horizon := now.Add(settings.ScheduleHorizon)
+	missedBefore := now.Add(-settings.Interval)
+
+	schedules, err := c.repo.FindDueSchedules(ctx, horizon, scheduleBatchSize)
+	if err != nil {
+		c.logger.Printf("Failed to find due schedules: %v", err)
+		return
+	}
+	for _, schedule := range schedules {
+		created, err := c.materialize(ctx, schedule, missedBefore, horizon)
+		if err != nil {
+			if !errors.Is(err, repository.ErrScheduleChanged) {
+				c.logger.Printf("Failed to create messages of schedule %d: %v", schedule.ID, err)
+			}
+			continue
+		}
+		if created > 0 {
+			c.logger.Printf("Created %d messages of schedule %d", created, schedule.ID)
+		}
+	}
+}
+
+// materialize advances schedule up to horizon and returns the number of
+// messages created
+func (c *MessageController) materialize(ctx context.Context, schedule *model.RecurringSchedule, missedBefore, horizon time.Time) (int, error) {
+	rule, err := scheduleRule(schedule)
+	if err != nil {
+		return 0, err
+	}
+
+	// Work on a copy so that schedule is left as it is if advancing fails
+	advanced := *schedule
+	var messages []*model.Message
+	next := advanced.NextRunAt
+	for i := 0; next != nil && !next.After(horizon) && i < maxOccurrencesPerRun; i++ {
+		if advanced.MaxOccurrences > 0 && advanced.Occurrences >= advanced.MaxOccurrences {
+			break
+		}
+		if !next.Before(missedBefore) {
+			messages = append(messages, scheduledMessage(schedule, *next))
+			advanced.Occurrences++
+		}
+		next = nextOccurrence(&advanced, rule, *next)
+	}
+	if advanced.MaxOccurrences > 0 && advanced.Occurrences >= advanced.MaxOccurrences {
+		next = nil
+	}
+	advanced.NextRunAt = next
+	if next == nil {
+		advanced.Status = model.ScheduleStatusCompleted
+	}
+
+	if err := c.repo.AdvanceSchedule(ctx, &advanced, messages); err != nil {
+		return 0, err
+	}
+	*schedule = advanced
+	return len(messages), nil
+}
+
+// startSchedule creates the messages of a new or resumed schedule that fall
+// within the schedule horizon right away, rather than at the next sweep,
+// and wakes the dispatcher for the first of them. If that fails the next
+// sweep tries again.
+func (c *MessageController) startSchedule(ctx context.Context, schedule *model.RecurringSchedule) {
+	if schedule.Status != model.ScheduleStatusActive || schedule.NextRunAt == nil {
+		return
+	}
+	first := *schedule.NextRunAt
+	now := time.Now()
+	if _, err := c.materialize(ctx, schedule, now, now.Add(c.Settings().ScheduleHorizon)); err != nil {
+		c.logger.Printf("Failed to create messages of schedule %d: %v", schedule.ID, err)
+		return
+	}
+	c.schedule(first)
+}
+
+// scheduleID parses the id path parameter, answering 400 if it is invalid
+func scheduleID(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid schedule ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// findSchedule loads the schedule of the id path parameter, answering with
+// an error if there is none
+func (c *MessageController) findSchedule(ctx *gin.Context) (*model.RecurringSchedule, bool) {
+	id, ok := scheduleID(ctx)
+	if !ok {
+		return nil, false
+	}
+	schedule, err := c.repo.FindScheduleByID(ctx.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Schedule not found"})
+		} else {
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get schedule"})
+		}
+		return nil, false
+	}
+	return schedule, true
+}
+
+// @Summary Create a recurring schedule
+// @Description Create a schedule that sends a message on every occurrence of a cron expression or iCalendar RRULE, read in its timezone
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param schedule body ScheduleRequest true "Schedule details"
+// @Success 201 {object} model.RecurringSchedule
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /schedules [post]
+func (c *MessageController) CreateSchedule(ctx *gin.Context) {
+	var req ScheduleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	schedule, err := c.newSchedule(ctx.Request.Context(), req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := c.plan(schedule, time.Now()); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if schedule.NextRunAt == nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: ErrNoOccurrences.Error()})
+		return
+	}
+
+	if err := c.repo.CreateSchedule(ctx.Request.Context(), schedule); err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create schedule"})
+		return
+	}
+	c.startSchedule(apiContext(ctx), schedule)
+
+	ctx.JSON(http.StatusCreated, schedule)
+}
+
+// @Summary Get all recurring schedules
+// @Description Get a list of all recurring schedules
+// @Tags schedules
+// @Produce json
+// @Success 200 {array} model.RecurringSchedule
+// @Failure 500 {object} ErrorResponse
+// @Router /schedules [get]
+func (c *MessageController) GetSchedules(ctx *gin.Context) {
+	schedules, err := c.repo.FindSchedules(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get schedules"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schedules)
+}
+
+// @Summary Get a recurring schedule
+// @Description Get a recurring schedule by its ID
+// @Tags schedules
+// @Produce json
+// @Param id path int true "Schedule ID"
+// @Success 200 {object} model.RecurringSchedule
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /schedules/{id} [get]
+func (c *MessageController) GetSchedule(ctx *gin.Context) {
+	schedule, ok := c.findSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+// @Summary Replace a recurring schedule
+// @Description Replace the message and recurrence of a schedule. Pending messages it created are cancelled and created anew; a paused schedule stays paused.
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param id path int true "Schedule ID"
+// @Param schedule body ScheduleRequest true "Schedule details"
+// @Success 200 {object} model.RecurringSchedule
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /schedules/{id} [put]
+func (c *MessageController) UpdateSchedule(ctx *gin.Context) {
+	current, ok := c.findSchedule(ctx)
+	if !ok {
+		return
+	}
+
+	var req ScheduleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	schedule, err := c.newSchedule(ctx.Request.Context(), req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	schedule.ID = current.ID
+	schedule.CreatedAt = current.CreatedAt
+	if current.Status == model.ScheduleStatusPaused {
+		schedule.Status = model.ScheduleStatusPaused
+	}
+	if err := c.plan(schedule, time.Now()); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if !c.saveSchedule(ctx, schedule, "schedule changed") {
+		return
+	}
+	c.startSchedule(apiContext(ctx), schedule)
+
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+// @Summary Delete a recurring schedule
+// @Description Delete a schedule and cancel the pending messages it created
+// @Tags schedules
+// @Produce json
+// @Param id path int true "Schedule ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /schedules/{id} [delete]
+func (c *MessageController) DeleteSchedule(ctx *gin.Context) {
+	id, ok := scheduleID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.repo.DeleteSchedule(apiContext(ctx), id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Schedule not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete schedule"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// @Summary Pause a recurring schedule
+// @Description Stop creating messages for a schedule and cancel the pending messages it created
+// @Tags schedules
+// @Produce json
+// @Param id path int true "Schedule ID"
+// @Success 200 {object} model.RecurringSchedule
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /schedules/{id}/pause [post]
+func (c *MessageController) PauseSchedule(ctx *gin.Context) {
+	schedule, ok := c.findSchedule(ctx)
+	if !ok {
+		return
+	}
+	if schedule.Status != model.ScheduleStatusActive {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Error: "Only active schedules can be paused"})
+		return
+	}
+
+	schedule.Status = model.ScheduleStatusPaused
+	if !c.saveSchedule(ctx, schedule, "schedule paused") {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+// @Summary Resume a recurring schedule
+// @Description Continue a paused schedule from its next occurrence; occurrences missed while it was paused are not sent
+// @Tags schedules
+// @Produce json
+// @Param id path int true "Schedule ID"
+// @Success 200 {object} model.RecurringSchedule
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /schedules/{id}/resume [post]
+func (c *MessageController) ResumeSchedule(ctx *gin.Context) {
+	schedule, ok := c.findSchedule(ctx)
+	if !ok {
+		return
+	}
+	if schedule.Status != model.ScheduleStatusPaused {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Error: "Only paused schedules can be resumed"})
+		return
+	}
+
+	schedule.Status = model.ScheduleStatusActive
+	if err := c.plan(schedule, time.Now()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to plan schedule"})
+		return
+	}
+	if !c.saveSchedule(ctx, schedule, "schedule resumed") {
+		return
+	}
+	c.startSchedule(apiContext(ctx), schedule)
+
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+// saveSchedule stores a changed schedule, answering with an error if that fails
+func (c *MessageController) saveSchedule(ctx *gin.Context, schedule *model.RecurringSchedule, reason string) bool {
+	if err := c.repo.UpdateSchedule(apiContext(ctx), schedule, reason); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Schedule not found"})
+		} else {
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update schedule"})
+		}
+		return false
+	}
+	return true
+}
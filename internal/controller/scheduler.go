@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+	"time"
+)
+
+// run is the dispatcher loop. Rather than polling at a fixed interval it
+// sleeps until the next pending message falls due, so that messages go out at
+// their scheduled time. A sweep at least every Interval recovers expired
+// leases and picks up messages created by other replicas.
+func (c *MessageController) run(ctx context.Context) {
+	// Process messages immediately when started
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	defer c.setPlannedRun(time.Time{})
+
+	stalled := false
+	for {
+		select {
+		case <-timer.C:
+			c.setPlannedRun(time.Time{})
+			result, err := c.processMessages(ctx)
+			if err != nil {
+				c.logger.Printf("Error processing messages: %v", err)
+			}
+			// Nothing could be delivered, so the same messages would be due again right away
+			stalled = err != nil || (result.Skipped > 0 && result.Sent+result.Failed == 0)
+		case <-c.wakeCh:
+			stalled = false
+		case <-c.settingsCh:
+			// Pick up a changed interval without waiting for the old one to elapse
+		case <-ctx.Done():
+			return
+		}
+
+		next := c.nextRun(ctx, stalled)
+		c.setPlannedRun(next)
+		timer.Reset(time.Until(next))
+	}
+}
+
+// nextRun returns when the dispatcher should run next: when the first pending
+// message falls due, but no later than the next sweep. While every provider
+// circuit is open, or when the last run could not deliver anything, it waits
+// for a circuit to admit a probe instead.
+func (c *MessageController) nextRun(ctx context.Context, stalled bool) time.Time {
+	sweep := time.Now().Add(c.Settings().Interval)
+	if _, paused := c.providers.Unavailable(); paused || stalled {
+		if probe := c.providers.NextProbe(); !probe.IsZero() && probe.Before(sweep) {
+			return probe
+		}
+		return sweep
+	}
+
+	due, err := c.repo.NextDueAt(ctx)
+	if err != nil {
+		c.logger.Printf("Failed to look up the next scheduled message: %v", err)
+		return sweep
+	}
+	if due != nil && due.Before(sweep) {
+		return *due
+	}
+	return sweep
+}
+
+// schedule wakes the dispatcher if a message falling due at at would
+// otherwise wait for a later run
+func (c *MessageController) schedule(at time.Time) {
+	c.planMu.Lock()
+	planned := c.plannedRun
+	c.planMu.Unlock()
+
+	// A zero plan means the dispatcher is busy or stopped; waking it makes it
+	// look again once it is done
+	if !planned.IsZero() && !at.Before(planned) {
+		return
+	}
+	select {
+	case c.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (c *MessageController) setPlannedRun(at time.Time) {
+	c.planMu.Lock()
+	defer c.planMu.Unlock()
+	c.plannedRun = at
+}
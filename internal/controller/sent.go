@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"auto-messaging/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const cacheStatusHeader = "X-Cache"
+
+// SentMessageResponse answers when a message went out
+type SentMessageResponse struct {
+	MessageID string    `json:"message_id"`
+	SentAt    time.Time `json:"sent_at"`
+	// Source is "cache" or "database" depending on where the answer came from
+	Source string `json:"source"`
+}
+
+// CacheStats counts lookups served from Redis and lookups that fell back to Postgres
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// StatsResponse represents runtime statistics of the service
+type StatsResponse struct {
+	SentLookupCache CacheStats `json:"sent_lookup_cache"`
+	// Messages counts the messages in every status, such as expired
+	Messages map[string]int64 `json:"messages"`
+}
+
+// stats holds the counters behind StatsResponse
+type stats struct {
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+}
+
+// @Summary Get when a message was sent
+// @Description Look up the sent time of a message by the ID the provider assigned to it. Answers from the Redis cache and falls back to the database.
+// @Tags messaging
+// @Produce json
+// @Param messageId path string true "Provider message ID"
+// @Success 200 {object} SentMessageResponse
+// @Header 200 {string} X-Cache "HIT or MISS"
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /messaging/sent/{messageId} [get]
+func (c *MessageController) GetSentMessage(ctx *gin.Context) {
+	messageID := ctx.Param("messageId")
+	// Messages accepted without an ID of their own cannot be told apart
+	if messageID == model.AcceptedMessageID {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Sent message not found"})
+		return
+	}
+
+	sentAt, err := c.cache.GetMessageSentTime(ctx.Request.Context(), messageID)
+	if err != nil {
+		c.logger.Printf("Failed to read sent time of %s from cache: %v", messageID, err)
+	}
+	if sentAt != nil {
+		c.stats.cacheHits.Add(1)
+		ctx.Header(cacheStatusHeader, "HIT")
+		ctx.JSON(http.StatusOK, SentMessageResponse{MessageID: messageID, SentAt: *sentAt, Source: "cache"})
+		return
+	}
+
+	c.stats.cacheMisses.Add(1)
+	ctx.Header(cacheStatusHeader, "MISS")
+
+	message, err := c.repo.FindByMessageID(ctx.Request.Context(), messageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Sent message not found"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get sent message"})
+		return
+	}
+	if message.Status != model.MessageStatusSent {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Error: "Sent message not found"})
+		return
+	}
+
+	// Warm the cache for the next lookup
+	if err := c.cache.StoreMessageID(ctx.Request.Context(), messageID, message.SentAt); err != nil {
+		c.logger.Printf("Failed to cache sent time of %s: %v", messageID, err)
+	}
+
+	ctx.JSON(http.StatusOK, SentMessageResponse{MessageID: messageID, SentAt: message.SentAt, Source: "database"})
+}
+
+// @Summary Get service statistics
+// @Description Get runtime statistics such as hit and miss counts of the sent-message cache and the number of messages in every status
+// @Tags messaging
+// @Produce json
+// @Success 200 {object} StatsResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /messaging/stats [get]
+func (c *MessageController) GetStats(ctx *gin.Context) {
+	counts, err := c.repo.CountByStatus(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to count messages"})
+		return
+	}
+	if counts == nil {
+		counts = map[string]int64{}
+	}
+
+	ctx.JSON(http.StatusOK, StatsResponse{
+		SentLookupCache: CacheStats{
+			Hits:   c.stats.cacheHits.Load(),
+			Misses: c.stats.cacheMisses.Load(),
+		},
+		Messages: counts,
+	})
+}
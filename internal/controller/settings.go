@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"auto-messaging/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrInvalidBatchSize   = errors.New("batch_size must be between 1 and 1000")
+	ErrInvalidInterval    = errors.New("interval must be a duration of at least 1s")
+	ErrInvalidMaxInFlight = errors.New("max_in_flight must be between 1 and 100")
+	ErrInvalidRateLimit   = errors.New("rate_limit must not be negative")
+)
+
+const (
+	defaultBatchSize   = 2
+	defaultInterval    = 2 * time.Minute
+	defaultMaxInFlight = 1
+	defaultLease       = 5 * time.Minute
+	defaultHorizon     = 24 * time.Hour
+
+	maxBatchSize   = 1000
+	minInterval    = time.Second
+	maxMaxInFlight = 100
+)
+
+// UpdateSettingsRequest represents the request body for changing dispatcher settings.
+// Omitted fields keep their current value.
+type UpdateSettingsRequest struct {
+	BatchSize   *int     `json:"batch_size,omitempty" example:"10"`
+	Interval    *string  `json:"interval,omitempty" example:"30s"`
+	MaxInFlight *int     `json:"max_in_flight,omitempty" example:"4"`
+	RateLimit   *float64 `json:"rate_limit,omitempty" example:"5"`
+}
+
+// SettingsResponse represents the current dispatcher settings
+type SettingsResponse struct {
+	BatchSize   int     `json:"batch_size"`
+	Interval    string  `json:"interval"`
+	MaxInFlight int     `json:"max_in_flight"`
+	RateLimit   float64 `json:"rate_limit"`
+}
+
+// withDefaults fills in zero-valued dispatcher settings
+func withDefaults(s config.Dispatcher) config.Dispatcher {
+	if s.BatchSize <= 0 {
+		s.BatchSize = defaultBatchSize
+	}
+	if s.Interval <= 0 {
+		s.Interval = defaultInterval
+	}
+	if s.MaxInFlight <= 0 {
+		s.MaxInFlight = defaultMaxInFlight
+	}
+	if s.RateLimit < 0 {
+		s.RateLimit = 0
+	}
+	s.FairShare = min(max(s.FairShare, 0), 1)
+	if s.InstanceID == "" {
+		s.InstanceID = fmt.Sprintf("dispatcher-%d", os.Getpid())
+	}
+	if s.LeaseDuration <= 0 {
+		s.LeaseDuration = defaultLease
+	}
+	if s.ScheduleHorizon <= 0 {
+		s.ScheduleHorizon = defaultHorizon
+	}
+	s.Retry = retryWithDefaults(s.Retry)
+	return s
+}
+
+func newSettingsResponse(s config.Dispatcher) SettingsResponse {
+	return SettingsResponse{
+		BatchSize:   s.BatchSize,
+		Interval:    s.Interval.String(),
+		MaxInFlight: s.MaxInFlight,
+		RateLimit:   s.RateLimit,
+	}
+}
+
+// Settings returns a snapshot of the current dispatcher settings
+func (c *MessageController) Settings() config.Dispatcher {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings
+}
+
+// UpdateSettings validates and applies new dispatcher settings.
+// A running dispatcher picks them up without a restart.
+func (c *MessageController) UpdateSettings(req UpdateSettingsRequest) (config.Dispatcher, error) {
+	c.mu.Lock()
+	settings := c.settings
+	if req.BatchSize != nil {
+		if *req.BatchSize < 1 || *req.BatchSize > maxBatchSize {
+			c.mu.Unlock()
+			return settings, ErrInvalidBatchSize
+		}
+		settings.BatchSize = *req.BatchSize
+	}
+	if req.Interval != nil {
+		interval, err := time.ParseDuration(*req.Interval)
+		if err != nil || interval < minInterval {
+			c.mu.Unlock()
+			return settings, ErrInvalidInterval
+		}
+		settings.Interval = interval
+	}
+	if req.MaxInFlight != nil {
+		if *req.MaxInFlight < 1 || *req.MaxInFlight > maxMaxInFlight {
+			c.mu.Unlock()
+			return settings, ErrInvalidMaxInFlight
+		}
+		settings.MaxInFlight = *req.MaxInFlight
+	}
+	if req.RateLimit != nil {
+		if *req.RateLimit < 0 {
+			c.mu.Unlock()
+			return settings, ErrInvalidRateLimit
+		}
+		settings.RateLimit = *req.RateLimit
+	}
+	c.settings = settings
+	c.mu.Unlock()
+
+	// Notify the dispatcher loop, a pending notification is enough
+	select {
+	case c.settingsCh <- struct{}{}:
+	default:
+	}
+
+	c.logger.Printf("Dispatcher settings updated: batch_size=%d interval=%s max_in_flight=%d rate_limit=%g",
+		settings.BatchSize, settings.Interval, settings.MaxInFlight, settings.RateLimit)
+	return settings, nil
+}
+
+// @Summary Get dispatcher settings
+// @Description Get the current dispatcher throughput settings
+// @Tags messaging
+// @Produce json
+// @Success 200 {object} SettingsResponse
+// @Router /messaging/settings [get]
+func (c *MessageController) GetSettings(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, newSettingsResponse(c.Settings()))
+}
+
+// @Summary Update dispatcher settings
+// @Description Change batch size, sweep interval, max in-flight sends and rate cap of the running dispatcher
+// @Tags messaging
+// @Accept json
+// @Produce json
+// @Param settings body UpdateSettingsRequest true "Dispatcher settings"
+// @Success 200 {object} SettingsResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /messaging/settings [put]
+func (c *MessageController) PutSettings(ctx *gin.Context) {
+	var req UpdateSettingsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	settings, err := c.UpdateSettings(req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newSettingsResponse(settings))
+}
+
+// throttle spaces out sends so that at most rate sends start per second
+type throttle struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the next send may start. A rate of 0 disables the cap.
+func (t *throttle) wait(ctx context.Context, rate float64) error {
+	if rate <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	slot := t.next
+	t.next = slot.Add(time.Duration(float64(time.Second) / rate))
+	t.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
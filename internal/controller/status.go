@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"auto-messaging/internal/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusResponse represents the state of the dispatcher and of the circuit
+// breakers of the delivery providers
+type StatusResponse struct {
+	Running bool `json:"running"`
+	// Paused is true while the circuit of every provider is open, in which
+	// case no messages are dispatched until ResumesAt
+	Paused    bool                            `json:"paused"`
+	ResumesAt *time.Time                      `json:"resumes_at,omitempty"`
+	Providers map[string]client.BreakerStatus `json:"providers"`
+}
+
+// @Summary Get dispatcher status
+// @Description Get whether the dispatcher is running or paused, and the circuit breaker state of every provider
+// @Tags messaging
+// @Produce json
+// @Success 200 {object} StatusResponse
+// @Router /messaging/status [get]
+func (c *MessageController) GetStatus(ctx *gin.Context) {
+	resp := StatusResponse{
+		Running:   c.Running(),
+		Providers: c.providers.Breakers(),
+	}
+	if until, paused := c.providers.Unavailable(); paused {
+		resp.Paused = true
+		resp.ResumesAt = &until
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
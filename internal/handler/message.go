@@ -46,6 +46,36 @@ func (h *MessageHandler) UpdateMessageStatus(c *gin.Context) {
 	h.controller.UpdateMessage(c)
 }
 
+// GetMessageEvents handles retrieving the status history of a message
+func (h *MessageHandler) GetMessageEvents(c *gin.Context) {
+	h.controller.GetMessageEvents(c)
+}
+
+// GetDeadLetters handles listing permanently failed messages
+func (h *MessageHandler) GetDeadLetters(c *gin.Context) {
+	h.controller.GetDeadLetters(c)
+}
+
+// RequeueMessage handles requeueing a single failed message
+func (h *MessageHandler) RequeueMessage(c *gin.Context) {
+	h.controller.RequeueMessage(c)
+}
+
+// RequeueDeadLetters handles requeueing failed messages in bulk
+func (h *MessageHandler) RequeueDeadLetters(c *gin.Context) {
+	h.controller.RequeueDeadLetters(c)
+}
+
+// GetSentMessage handles looking up when a message was sent
+func (h *MessageHandler) GetSentMessage(c *gin.Context) {
+	h.controller.GetSentMessage(c)
+}
+
+// GetStats handles retrieving runtime statistics
+func (h *MessageHandler) GetStats(c *gin.Context) {
+	h.controller.GetStats(c)
+}
+
 // @Summary Start automatic message sending
 // @Description Start the automatic message sending process
 // @Tags messages
@@ -68,6 +98,21 @@ func (h *MessageHandler) StopMessaging(c *gin.Context) {
 	h.controller.StopMessaging(c)
 }
 
+// GetStatus handles retrieving the dispatcher and circuit breaker state
+func (h *MessageHandler) GetStatus(c *gin.Context) {
+	h.controller.GetStatus(c)
+}
+
+// GetSettings handles retrieving the dispatcher settings
+func (h *MessageHandler) GetSettings(c *gin.Context) {
+	h.controller.GetSettings(c)
+}
+
+// UpdateSettings handles changing the dispatcher settings at runtime
+func (h *MessageHandler) UpdateSettings(c *gin.Context) {
+	h.controller.PutSettings(c)
+}
+
 // @Summary Get sent messages
 // @Description Get a list of all sent messages
 // @Tags messages
@@ -84,3 +129,53 @@ func (h *MessageHandler) GetSentMessages(c *gin.Context) {
 
 	c.JSON(http.StatusOK, msgs)
 }
+
+// CreateSchedule handles creating a recurring schedule
+func (h *MessageHandler) CreateSchedule(c *gin.Context) {
+	h.controller.CreateSchedule(c)
+}
+
+// GetSchedules handles listing recurring schedules
+func (h *MessageHandler) GetSchedules(c *gin.Context) {
+	h.controller.GetSchedules(c)
+}
+
+// GetSchedule handles retrieving a recurring schedule by its ID
+func (h *MessageHandler) GetSchedule(c *gin.Context) {
+	h.controller.GetSchedule(c)
+}
+
+// UpdateSchedule handles replacing a recurring schedule
+func (h *MessageHandler) UpdateSchedule(c *gin.Context) {
+	h.controller.UpdateSchedule(c)
+}
+
+// DeleteSchedule handles deleting a recurring schedule
+func (h *MessageHandler) DeleteSchedule(c *gin.Context) {
+	h.controller.DeleteSchedule(c)
+}
+
+// PauseSchedule handles pausing a recurring schedule
+func (h *MessageHandler) PauseSchedule(c *gin.Context) {
+	h.controller.PauseSchedule(c)
+}
+
+// ResumeSchedule handles resuming a paused recurring schedule
+func (h *MessageHandler) ResumeSchedule(c *gin.Context) {
+	h.controller.ResumeSchedule(c)
+}
+
+// GetRecipients handles listing recipients with a stored time zone
+func (h *MessageHandler) GetRecipients(c *gin.Context) {
+	h.controller.GetRecipients(c)
+}
+
+// SaveRecipient handles setting the time zone of a recipient
+func (h *MessageHandler) SaveRecipient(c *gin.Context) {
+	h.controller.SaveRecipient(c)
+}
+
+// DeleteRecipient handles deleting a recipient
+func (h *MessageHandler) DeleteRecipient(c *gin.Context) {
+	h.controller.DeleteRecipient(c)
+}
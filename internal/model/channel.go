@@ -0,0 +1,109 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+)
+
+// Channel constants
+const (
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelPush    = "push"
+	ChannelGeneric = "generic"
+)
+
+var (
+	ErrUnknownChannel   = errors.New("unknown channel")
+	ErrInvalidRecipient = errors.New("invalid recipient")
+)
+
+const (
+	minPhoneDigits     = 8
+	maxPhoneDigits     = 15
+	maxPushTokenLength = 4096
+)
+
+// channelMaxLength holds the default content limit of every channel, in characters
+var channelMaxLength = map[string]int{
+	ChannelEmail:   500,
+	ChannelSMS:     1600,
+	ChannelPush:    1000,
+	ChannelGeneric: 500,
+}
+
+// ValidChannel reports whether channel is one of the supported channels
+func ValidChannel(channel string) bool {
+	_, ok := channelMaxLength[channel]
+	return ok
+}
+
+// DefaultMaxLength returns the default content limit of channel, in characters
+func DefaultMaxLength(channel string) int {
+	return channelMaxLength[channel]
+}
+
+// NormalizeRecipient validates to as a recipient on channel and returns its
+// canonical form: the bare address for email and E.164 for SMS
+func NormalizeRecipient(channel, to string) (string, error) {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return "", fmt.Errorf("%w: recipient is empty", ErrInvalidRecipient)
+	}
+
+	switch channel {
+	case ChannelEmail:
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q is not an email address", ErrInvalidRecipient, to)
+		}
+		return addr.Address, nil
+	case ChannelSMS:
+		return normalizePhoneNumber(to)
+	case ChannelPush:
+		if len(to) > maxPushTokenLength || strings.IndexFunc(to, unicode.IsSpace) >= 0 {
+			return "", fmt.Errorf("%w: %q is not a device token", ErrInvalidRecipient, to)
+		}
+		return to, nil
+	case ChannelGeneric:
+		return to, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownChannel, channel)
+	}
+}
+
+// normalizePhoneNumber converts an international phone number to E.164.
+// Spaces, dashes, dots and parentheses are dropped and a leading 00 is read
+// as +. Numbers without a country code are rejected.
+func normalizePhoneNumber(number string) (string, error) {
+	invalid := fmt.Errorf("%w: %q is not an international phone number", ErrInvalidRecipient, number)
+
+	var digits strings.Builder
+	for i, r := range number {
+		switch {
+		case r == '+' && i == 0:
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", invalid
+		}
+	}
+
+	d := digits.String()
+	switch {
+	case strings.HasPrefix(number, "+"):
+	case strings.HasPrefix(d, "00"):
+		d = d[2:]
+	default:
+		return "", invalid
+	}
+
+	if len(d) < minPhoneDigits || len(d) > maxPhoneDigits || d[0] == '0' {
+		return "", invalid
+	}
+	return "+" + d, nil
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// Event actor constants
+const (
+	ActorAPI        = "api"
+	ActorDispatcher = "dispatcher"
+	ActorSystem     = "system"
+)
+
+// MessageEvent records one status transition of a message
+type MessageEvent struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	MessageID  uint      `gorm:"index" json:"message_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	ActorID    string    `json:"actor_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
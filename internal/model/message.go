@@ -1,6 +1,9 @@
 package model
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,31 +11,104 @@ import (
 
 // Message status constants
 const (
-	MessageStatusPending   = "pending"
-	MessageStatusSent      = "sent"
-	MessageStatusFailed    = "failed"
-	MessageStatusCancelled = "cancelled"
+	MessageStatusPending    = "pending"
+	MessageStatusProcessing = "processing"
+	MessageStatusSent       = "sent"
+	MessageStatusFailed     = "failed"
+	MessageStatusCancelled  = "cancelled"
+	MessageStatusExpired    = "expired"
 )
 
+// messageTransitions lists the status changes a message may go through
+var messageTransitions = map[string][]string{
+	MessageStatusPending:    {MessageStatusProcessing, MessageStatusCancelled, MessageStatusExpired},
+	MessageStatusProcessing: {MessageStatusSent, MessageStatusFailed, MessageStatusPending, MessageStatusExpired},
+	MessageStatusFailed:     {MessageStatusPending},
+}
+
+// CanTransition reports whether a message may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, allowed := range messageTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// InvalidTransitionError is returned when a status change is not allowed
+// from the status a message is currently in
+type InvalidTransitionError struct {
+	ID   uint
+	From string
+	To   string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("message %d cannot move from %s to %s", e.ID, e.From, e.To)
+}
+
 // Message represents a message in the system
 type Message struct {
 	ID          uint      `gorm:"primarykey" json:"id"`
 	Content     string    `json:"content"`
 	To          string    `json:"to"`
-	Status      string    `json:"status"`
-	MessageID   string    `json:"message_id"`
+	Channel     string    `gorm:"default:email" json:"channel"`
+	Priority    string    `gorm:"default:normal" json:"priority"`
+	Status      string    `gorm:"index:idx_messages_status_scheduled_at,priority:1" json:"status"`
+	MessageID   string    `gorm:"index" json:"message_id"`
+	DeliveryKey string    `gorm:"index" json:"delivery_key"`
+	Provider    string    `json:"provider,omitempty"`
+	DeliveredBy string    `json:"delivered_by,omitempty"`
 	SentAt      time.Time `json:"sent_at"`
-	ScheduledAt time.Time `json:"scheduled_at"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ScheduledAt time.Time `gorm:"index:idx_messages_status_scheduled_at,priority:2" json:"scheduled_at"`
+	// LocalTime and Timezone record a send time given as wall clock time,
+	// RecipientTimezone that the zone is the recipient's and is followed
+	// when it changes
+	LocalTime         string     `json:"local_time,omitempty"`
+	Timezone          string     `json:"timezone,omitempty"`
+	RecipientTimezone bool       `json:"recipient_timezone,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	ScheduleID        *uint      `gorm:"index" json:"schedule_id,omitempty"`
+	ClaimedBy         string     `json:"claimed_by,omitempty"`
+	LeaseExpiresAt    *time.Time `json:"lease_expires_at,omitempty"`
+	AttemptCount      int        `json:"attempt_count"`
+	LastError         string     `json:"last_error,omitempty"`
+	NextAttemptAt     *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+
+	Attempts []MessageAttempt `gorm:"foreignKey:MessageID" json:"attempts,omitempty"`
+}
+
+// MessageAttempt records a single failed delivery attempt of a message
+type MessageAttempt struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	MessageID   uint      `gorm:"index" json:"message_id"`
+	Attempt     int       `json:"attempt"`
+	Error       string    `json:"error"`
+	AttemptedAt time.Time `json:"attempted_at"`
 }
 
 func (m *Message) BeforeCreate(tx *gorm.DB) error {
 	m.CreatedAt = time.Now()
 	m.UpdatedAt = time.Now()
+	if m.DeliveryKey == "" {
+		m.DeliveryKey = NewDeliveryKey()
+	}
 	return nil
 }
 
+// NewDeliveryKey returns a random key that identifies one message towards the
+// delivery provider, so that resends of the same message can be deduplicated
+func NewDeliveryKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
 func (m *Message) BeforeUpdate(tx *gorm.DB) error {
 	m.UpdatedAt = time.Now()
 	return nil
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Priority constants, from most to least urgent
+const (
+	PriorityCritical = "critical"
+	PriorityHigh     = "high"
+	PriorityNormal   = "normal"
+	PriorityLow      = "low"
+)
+
+var ErrUnknownPriority = errors.New("unknown priority")
+
+// priorityRanks orders the priorities, lower ranks are dispatched first
+var priorityRanks = map[string]int{
+	PriorityCritical: 0,
+	PriorityHigh:     1,
+	PriorityNormal:   2,
+	PriorityLow:      3,
+}
+
+// ValidPriority reports whether priority is one of the supported priorities
+func ValidPriority(priority string) bool {
+	_, ok := priorityRanks[priority]
+	return ok
+}
+
+// PriorityRank returns the dispatch rank of priority. Unknown priorities,
+// such as those of messages created before priorities existed, rank as normal.
+func PriorityRank(priority string) int {
+	if rank, ok := priorityRanks[priority]; ok {
+		return rank
+	}
+	return priorityRanks[PriorityNormal]
+}
+
+// PriorityOrderSQL is an SQL expression of the rank of the priority column,
+// for ordering messages by PriorityRank in queries
+var PriorityOrderSQL = fmt.Sprintf("CASE priority WHEN '%s' THEN %d WHEN '%s' THEN %d WHEN '%s' THEN %d ELSE %d END",
+	PriorityCritical, priorityRanks[PriorityCritical],
+	PriorityHigh, priorityRanks[PriorityHigh],
+	PriorityLow, priorityRanks[PriorityLow],
+	priorityRanks[PriorityNormal])
+
+// NormalizePriority validates priority and returns it in lower case, normal if empty
+func NormalizePriority(priority string) (string, error) {
+	if priority == "" {
+		return PriorityNormal, nil
+	}
+	priority = strings.ToLower(priority)
+	if !ValidPriority(priority) {
+		return "", fmt.Errorf("%w: %s", ErrUnknownPriority, priority)
+	}
+	return priority, nil
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"auto-messaging/pkg/recurrence"
+
+	"gorm.io/gorm"
+)
+
+// LocalTimeLayout is the layout of wall clock times, which carry no UTC offset
+const LocalTimeLayout = "2006-01-02T15:04:05"
+
+var ErrInvalidLocalTime = errors.New("local_time must be a date and time without UTC offset, such as 2024-04-26T09:00:00")
+
+// Recipient holds what is known about the receiver of messages on a channel
+type Recipient struct {
+	ID      uint   `gorm:"primarykey" json:"id"`
+	Channel string `gorm:"uniqueIndex:idx_recipients_channel_to,priority:1" json:"channel"`
+	// To is the normalized address, lower case for email
+	To string `gorm:"uniqueIndex:idx_recipients_channel_to,priority:2" json:"to"`
+	// Timezone is the IANA time zone the recipient lives in
+	Timezone  string    `json:"timezone"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (r *Recipient) BeforeCreate(tx *gorm.DB) error {
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *Recipient) BeforeUpdate(tx *gorm.DB) error {
+	r.UpdatedAt = time.Now()
+	return nil
+}
+
+// RecipientAddress returns the address a recipient is stored under for a
+// normalized address. Email addresses are compared case-insensitively.
+func RecipientAddress(channel, to string) string {
+	if channel == ChannelEmail {
+		return strings.ToLower(to)
+	}
+	return to
+}
+
+// ParseLocalTime parses a wall clock time in LocalTimeLayout, the seconds
+// may be left out
+func ParseLocalTime(s string) (time.Time, error) {
+	for _, layout := range []string{LocalTimeLayout, "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrInvalidLocalTime
+}
+
+// Reschedule moves a message given a local time to the instant that local
+// time reads in loc. ExpiresAt moves along, so that the message can still be
+// sent for as long as before. Messages scheduled at an absolute time are left
+// as they are.
+func (m *Message) Reschedule(loc *time.Location) error {
+	if m.LocalTime == "" {
+		return nil
+	}
+	wall, err := ParseLocalTime(m.LocalTime)
+	if err != nil {
+		return err
+	}
+
+	scheduledAt := recurrence.WallClock(wall, loc).UTC()
+	if m.ExpiresAt != nil {
+		expiresAt := m.ExpiresAt.Add(scheduledAt.Sub(m.ScheduledAt))
+		m.ExpiresAt = &expiresAt
+	}
+	m.ScheduledAt = scheduledAt
+	m.Timezone = loc.String()
+	return nil
+}
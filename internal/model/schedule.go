@@ -0,0 +1,55 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Recurring schedule status constants
+const (
+	ScheduleStatusActive    = "active"
+	ScheduleStatusPaused    = "paused"
+	ScheduleStatusCompleted = "completed"
+)
+
+// RecurringSchedule creates a message for every occurrence of a cron
+// expression or recurrence rule
+type RecurringSchedule struct {
+	ID uint `gorm:"primarykey" json:"id"`
+
+	// The message created for every occurrence
+	Content  string `json:"content"`
+	To       string `json:"to"`
+	Channel  string `gorm:"default:email" json:"channel"`
+	Provider string `json:"provider,omitempty"`
+	Priority string `gorm:"default:normal" json:"priority"`
+	TTL      string `json:"ttl,omitempty"`
+
+	// Exactly one of Cron and RRule is set
+	Cron           string     `json:"cron,omitempty"`
+	RRule          string     `gorm:"column:rrule" json:"rrule,omitempty"`
+	Timezone       string     `json:"timezone"`
+	StartAt        time.Time  `json:"start_at"`
+	EndAt          *time.Time `json:"end_at,omitempty"`
+	MaxOccurrences int        `json:"max_occurrences,omitempty"`
+
+	Status string `gorm:"index:idx_schedules_status_next_run_at,priority:1" json:"status"`
+	// Occurrences counts the messages created so far
+	Occurrences int `json:"occurrences"`
+	// NextRunAt is the next occurrence that has no message yet
+	NextRunAt *time.Time `gorm:"index:idx_schedules_status_next_run_at,priority:2" json:"next_run_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func (s *RecurringSchedule) BeforeCreate(tx *gorm.DB) error {
+	s.CreatedAt = time.Now()
+	s.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *RecurringSchedule) BeforeUpdate(tx *gorm.DB) error {
+	s.UpdatedAt = time.Now()
+	return nil
+}
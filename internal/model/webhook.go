@@ -1,9 +1,15 @@
 package model
 
+// AcceptedMessageID is the message ID given to every message a webhook
+// answers with 202 Accepted, so it does not identify a message
+const AcceptedMessageID = "accepted"
+
 // WebhookRequest represents the payload sent to webhook
 type WebhookRequest struct {
-	To      string `json:"to"`
-	Content string `json:"content"`
+	To             string `json:"to"`
+	Channel        string `json:"channel,omitempty"`
+	Content        string `json:"content"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 // WebhookResponse represents the response from webhook
@@ -11,3 +17,8 @@ type WebhookResponse struct {
 	Message   string `json:"message"`
 	MessageID string `json:"messageId"`
 }
+
+// HasMessageID reports whether the provider gave the message an ID of its own
+func (r *WebhookResponse) HasMessageID() bool {
+	return r.MessageID != "" && r.MessageID != AcceptedMessageID
+}
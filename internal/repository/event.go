@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"auto-messaging/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type actorKey struct{}
+
+type actor struct {
+	kind string
+	id   string
+}
+
+// WithActor attaches who is changing messages to ctx, so that the transitions
+// recorded by the repository can be attributed. kind is one of the model.Actor
+// constants, id further identifies the actor such as a replica or client address.
+func WithActor(ctx context.Context, kind, id string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor{kind: kind, id: id})
+}
+
+func actorFrom(ctx context.Context) actor {
+	if a, ok := ctx.Value(actorKey{}).(actor); ok {
+		return a
+	}
+	return actor{kind: model.ActorSystem}
+}
+
+// recordTransitions appends a status transition event for every message in ids
+func recordTransitions(tx *gorm.DB, ids []uint, from, to, errMsg string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	a := actorFrom(tx.Statement.Context)
+	now := time.Now()
+	events := make([]model.MessageEvent, len(ids))
+	for i, id := range ids {
+		events[i] = model.MessageEvent{
+			MessageID:  id,
+			FromStatus: from,
+			ToStatus:   to,
+			Actor:      a.kind,
+			ActorID:    a.id,
+			Error:      errMsg,
+			CreatedAt:  now,
+		}
+	}
+	return tx.Create(&events).Error
+}
+
+// FindEvents returns the status history of a message, oldest first
+func (r *MessageRepositoryImpl) FindEvents(ctx context.Context, messageID uint) ([]model.MessageEvent, error) {
+	var events []model.MessageEvent
+	err := r.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Order("created_at ASC, id ASC").
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -4,12 +4,22 @@ import (
 	"auto-messaging/config"
 	"auto-messaging/internal/model"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotDeadLetter = errors.New("message is not in the dead-letter queue")
+	ErrNotPending    = errors.New("message is no longer pending")
+	ErrLeaseLost     = errors.New("message is no longer leased to this replica")
 )
 
 // MessageRepository defines the interface for message data access
@@ -19,9 +29,36 @@ type MessageRepository interface {
 	FindByID(ctx context.Context, id uint) (*model.Message, error)
 	UpdateStatus(ctx context.Context, id uint, status string) error
 	FindPendingBefore(ctx context.Context, before time.Time, limit int) ([]*model.Message, error)
+	NextDueAt(ctx context.Context) (*time.Time, error)
 	FindByStatus(status string) ([]*model.Message, error)
-	UpdateMessageID(ctx context.Context, id uint, messageID string) error
-	UpdateSentAt(ctx context.Context, id uint, sentAt time.Time) error
+	FindByMessageID(ctx context.Context, messageID string) (*model.Message, error)
+	UpdatePending(ctx context.Context, id uint, changes *model.Message) error
+	MarkSent(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error
+	ClaimPending(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error)
+	ReleaseClaims(ctx context.Context, owner string, ids []uint) error
+	RenewLease(ctx context.Context, id uint, owner string, until time.Time) error
+	RecoverExpiredLeases(ctx context.Context, now time.Time) (int64, error)
+	RescheduleAttempt(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error
+	DeferClaim(ctx context.Context, id uint, owner string, until time.Time, reason string) error
+	MarkFailed(ctx context.Context, id uint, owner string, lastError string) error
+	MarkExpired(ctx context.Context, id uint, owner string) error
+	ExpireOverdue(ctx context.Context, now time.Time) (int64, error)
+	CountByStatus(ctx context.Context) (map[string]int64, error)
+	FindDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]*model.Message, error)
+	RequeueDeadLetter(ctx context.Context, id uint) error
+	RequeueDeadLetters(ctx context.Context, filter DeadLetterFilter) (int64, error)
+	FindEvents(ctx context.Context, messageID uint) ([]model.MessageEvent, error)
+	CreateSchedule(ctx context.Context, schedule *model.RecurringSchedule) error
+	FindSchedules(ctx context.Context) ([]*model.RecurringSchedule, error)
+	FindScheduleByID(ctx context.Context, id uint) (*model.RecurringSchedule, error)
+	UpdateSchedule(ctx context.Context, schedule *model.RecurringSchedule, reason string) error
+	DeleteSchedule(ctx context.Context, id uint) error
+	FindDueSchedules(ctx context.Context, before time.Time, limit int) ([]*model.RecurringSchedule, error)
+	AdvanceSchedule(ctx context.Context, schedule *model.RecurringSchedule, messages []*model.Message) error
+	FindRecipients(ctx context.Context) ([]*model.Recipient, error)
+	FindRecipient(ctx context.Context, channel, to string) (*model.Recipient, error)
+	SaveRecipient(ctx context.Context, recipient *model.Recipient) (int64, error)
+	DeleteRecipient(ctx context.Context, id uint) error
 }
 
 // MessageRepositoryImpl implements the MessageRepository interface
@@ -48,8 +85,8 @@ func InitDB(cfg config.DB) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Auto-migrate the Message model
-	if err := db.AutoMigrate(&model.Message{}); err != nil {
+	// Auto-migrate the Message models
+	if err := db.AutoMigrate(&model.Message{}, &model.MessageAttempt{}, &model.MessageEvent{}, &model.RecurringSchedule{}, &model.Recipient{}); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
@@ -57,7 +94,12 @@ func InitDB(cfg config.DB) (*gorm.DB, error) {
 }
 
 func (r *MessageRepositoryImpl) Create(ctx context.Context, message *model.Message) error {
-	return r.db.WithContext(ctx).Create(message).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		return recordTransitions(tx, []uint{message.ID}, "", message.Status, "")
+	})
 }
 
 func (r *MessageRepositoryImpl) FindAll() ([]model.Message, error) {
@@ -77,17 +119,82 @@ func (r *MessageRepositoryImpl) FindByID(ctx context.Context, id uint) (*model.M
 	return &message, nil
 }
 
+// UpdateStatus moves a message to status. Changes not allowed by the message
+// state machine return a *model.InvalidTransitionError.
 func (r *MessageRepositoryImpl) UpdateStatus(ctx context.Context, id uint, status string) error {
-	return r.db.WithContext(ctx).
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var message model.Message
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "status").
+			First(&message, id).Error
+		if err != nil {
+			return err
+		}
+
+		if !model.CanTransition(message.Status, status) {
+			return &model.InvalidTransitionError{ID: id, From: message.Status, To: status}
+		}
+
+		err = tx.Model(&model.Message{}).
+			Where("id = ?", id).
+			Update("status", status).Error
+		if err != nil {
+			return err
+		}
+		return recordTransitions(tx, []uint{id}, message.Status, status, "")
+	})
+}
+
+// FindByMessageID looks up a message by the ID the provider assigned to it
+func (r *MessageRepositoryImpl) FindByMessageID(ctx context.Context, messageID string) (*model.Message, error) {
+	var message model.Message
+	err := r.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Order("sent_at DESC").
+		First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// UpdatePending changes the details of a message that has not been picked up
+// yet to the content, recipient, channel, provider, scheduled time and local
+// time of changes
+func (r *MessageRepositoryImpl) UpdatePending(ctx context.Context, id uint, changes *model.Message) error {
+	result := r.db.WithContext(ctx).
 		Model(&model.Message{}).
-		Where("id = ?", id).
-		Update("status", status).Error
+		Where("id = ? AND status = ?", id, model.MessageStatusPending).
+		Updates(map[string]interface{}{
+			"content":            changes.Content,
+			"to":                 changes.To,
+			"channel":            changes.Channel,
+			"provider":           changes.Provider,
+			"priority":           changes.Priority,
+			"expires_at":         changes.ExpiresAt,
+			"scheduled_at":       changes.ScheduledAt,
+			"local_time":         changes.LocalTime,
+			"timezone":           changes.Timezone,
+			"recipient_timezone": changes.RecipientTimezone,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if _, err := r.FindByID(ctx, id); err != nil {
+			return err
+		}
+		return ErrNotPending
+	}
+	return nil
 }
 
 func (r *MessageRepositoryImpl) FindPendingBefore(ctx context.Context, before time.Time, limit int) ([]*model.Message, error) {
 	var messages []*model.Message
 	err := r.db.WithContext(ctx).
 		Where("status = ? AND scheduled_at <= ?", model.MessageStatusPending, before).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", before).
+		Order(model.PriorityOrderSQL).
 		Order("scheduled_at ASC").
 		Limit(limit).
 		Find(&messages).Error
@@ -97,6 +204,22 @@ func (r *MessageRepositoryImpl) FindPendingBefore(ctx context.Context, before ti
 	return messages, nil
 }
 
+// NextDueAt returns the earliest time a pending message becomes due, the
+// later of its scheduled time and its next retry, or expires if that is
+// sooner. It returns nil if no message is pending.
+func (r *MessageRepositoryImpl) NextDueAt(ctx context.Context) (*time.Time, error) {
+	var next sql.NullTime
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		// LEAST ignores the NULL expiry of messages that never expire
+		Select("MIN(LEAST(GREATEST(scheduled_at, COALESCE(next_attempt_at, scheduled_at)), expires_at))").
+		Where("status = ?", model.MessageStatusPending).
+		Scan(&next).Error
+	if err != nil || !next.Valid {
+		return nil, err
+	}
+	return &next.Time, nil
+}
+
 func (r *MessageRepositoryImpl) FindByStatus(status string) ([]*model.Message, error) {
 	var messages []*model.Message
 	if err := r.db.Where("status = ?", status).Find(&messages).Error; err != nil {
@@ -105,16 +228,472 @@ func (r *MessageRepositoryImpl) FindByStatus(status string) ([]*model.Message, e
 	return messages, nil
 }
 
-func (r *MessageRepositoryImpl) UpdateMessageID(ctx context.Context, id uint, messageID string) error {
-	return r.db.WithContext(ctx).
-		Model(&model.Message{}).
-		Where("id = ?", id).
-		Update("message_id", messageID).Error
+// MarkSent records a successful send in a single write: the provider message
+// ID, the provider that delivered it, the sent time and the sent status. The
+// message must still be processing and leased to owner.
+func (r *MessageRepositoryImpl) MarkSent(ctx context.Context, id uint, owner string, messageID, deliveredBy string, sentAt time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Message{}).
+			Where("id = ? AND status = ? AND claimed_by = ?", id, model.MessageStatusProcessing, owner).
+			Updates(map[string]interface{}{
+				"status":           model.MessageStatusSent,
+				"message_id":       messageID,
+				"delivered_by":     deliveredBy,
+				"sent_at":          sentAt,
+				"next_attempt_at":  nil,
+				"lease_expires_at": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return transitionError(tx, id, model.MessageStatusSent)
+		}
+		return recordTransitions(tx, []uint{id}, model.MessageStatusProcessing, model.MessageStatusSent, "")
+	})
 }
 
-func (r *MessageRepositoryImpl) UpdateSentAt(ctx context.Context, id uint, sentAt time.Time) error {
-	return r.db.WithContext(ctx).
-		Model(&model.Message{}).
-		Where("id = ?", id).
-		Update("sent_at", sentAt).Error
+// transitionError explains why a guarded status change matched no row: either
+// the message does not exist or it is in a status the change is not valid from
+func transitionError(tx *gorm.DB, id uint, to string) error {
+	var message model.Message
+	if err := tx.Select("id", "status").First(&message, id).Error; err != nil {
+		return err
+	}
+	return &model.InvalidTransitionError{ID: id, From: message.Status, To: to}
+}
+
+// ClaimPending atomically moves up to limit due pending messages to processing
+// and leases them to owner. Messages are claimed by priority and then
+// scheduled time, except for up to fair messages that are claimed by
+// scheduled time alone, so that a backlog of urgent messages cannot starve
+// the others. The claimed messages are returned in dispatch order. Rows
+// locked by another replica are skipped, so concurrent dispatchers never
+// claim the same message.
+func (r *MessageRepositoryImpl) ClaimPending(ctx context.Context, owner string, before time.Time, limit, fair int, lease time.Duration) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		due := func() *gorm.DB {
+			return tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("status = ? AND scheduled_at <= ?", model.MessageStatusPending, before).
+				Where("next_attempt_at IS NULL OR next_attempt_at <= ?", before)
+		}
+
+		fair = min(max(fair, 0), limit)
+		if limit > fair {
+			err := due().
+				Order(model.PriorityOrderSQL).
+				Order("scheduled_at ASC").
+				Limit(limit - fair).
+				Find(&messages).Error
+			if err != nil {
+				return err
+			}
+		}
+		if fair > 0 {
+			// Our own locks are not skipped, the messages claimed above are excluded instead
+			query := due()
+			if len(messages) > 0 {
+				claimed := make([]uint, len(messages))
+				for i, msg := range messages {
+					claimed[i] = msg.ID
+				}
+				query = query.Where("id NOT IN ?", claimed)
+			}
+			var oldest []*model.Message
+			if err := query.Order("scheduled_at ASC").Limit(fair).Find(&oldest).Error; err != nil {
+				return err
+			}
+			messages = append(messages, oldest...)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		sort.SliceStable(messages, func(i, j int) bool {
+			ri, rj := model.PriorityRank(messages[i].Priority), model.PriorityRank(messages[j].Priority)
+			if ri != rj {
+				return ri < rj
+			}
+			return messages[i].ScheduledAt.Before(messages[j].ScheduledAt)
+		})
+
+		ids := make([]uint, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+
+			// Messages created before delivery keys existed get one on first claim
+			if msg.DeliveryKey == "" {
+				msg.DeliveryKey = model.NewDeliveryKey()
+				err := tx.Model(&model.Message{}).
+					Where("id = ?", msg.ID).
+					Update("delivery_key", msg.DeliveryKey).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		now := time.Now()
+		expiresAt := now.Add(lease)
+		err := tx.Model(&model.Message{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":           model.MessageStatusProcessing,
+				"claimed_by":       owner,
+				"lease_expires_at": expiresAt,
+				"updated_at":       now,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range messages {
+			msg.Status = model.MessageStatusProcessing
+			msg.ClaimedBy = owner
+			msg.LeaseExpiresAt = &expiresAt
+			msg.UpdatedAt = now
+		}
+		return recordTransitions(tx, ids, model.MessageStatusPending, model.MessageStatusProcessing, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ReleaseClaims hands messages still leased to owner back to pending
+func (r *MessageRepositoryImpl) ReleaseClaims(ctx context.Context, owner string, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		released, err := lockMessageIDs(tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where("id IN ? AND status = ? AND claimed_by = ?", ids, model.MessageStatusProcessing, owner)
+		})
+		if err != nil || len(released) == 0 {
+			return err
+		}
+
+		err = tx.Model(&model.Message{}).
+			Where("id IN ?", released).
+			Updates(map[string]interface{}{
+				"status":           model.MessageStatusPending,
+				"claimed_by":       "",
+				"lease_expires_at": nil,
+			}).Error
+		if err != nil {
+			return err
+		}
+		return recordTransitions(tx, released, model.MessageStatusProcessing, model.MessageStatusPending, "")
+	})
+}
+
+// RenewLease extends the lease of a message leased to owner until until. It
+// returns ErrLeaseLost if the message is no longer leased to owner, for
+// example because its lease ran out and another replica recovered it.
+func (r *MessageRepositoryImpl) RenewLease(ctx context.Context, id uint, owner string, until time.Time) error {
+	result := r.db.WithContext(ctx).Model(&model.Message{}).
+		Where("id = ? AND status = ? AND claimed_by = ?", id, model.MessageStatusProcessing, owner).
+		Update("lease_expires_at", until)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// RecoverExpiredLeases returns processing messages whose lease ran out, for
+// example because their replica crashed, to pending
+func (r *MessageRepositoryImpl) RecoverExpiredLeases(ctx context.Context, now time.Time) (int64, error) {
+	var recovered []uint
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		recovered, err = lockMessageIDs(tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where("status = ? AND lease_expires_at < ?", model.MessageStatusProcessing, now)
+		})
+		if err != nil || len(recovered) == 0 {
+			return err
+		}
+
+		err = tx.Model(&model.Message{}).
+			Where("id IN ?", recovered).
+			Updates(map[string]interface{}{
+				"status":           model.MessageStatusPending,
+				"claimed_by":       "",
+				"lease_expires_at": nil,
+			}).Error
+		if err != nil {
+			return err
+		}
+		return recordTransitions(tx, recovered, model.MessageStatusProcessing, model.MessageStatusPending, "lease expired")
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(recovered)), nil
+}
+
+// ExpireOverdue moves pending messages whose expiry has passed at now to
+// expired and returns how many there were
+func (r *MessageRepositoryImpl) ExpireOverdue(ctx context.Context, now time.Time) (int64, error) {
+	var expired []uint
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		expired, err = lockMessageIDs(tx, func(db *gorm.DB) *gorm.DB {
+			return db.Where("status = ? AND expires_at <= ?", model.MessageStatusPending, now)
+		})
+		if err != nil || len(expired) == 0 {
+			return err
+		}
+
+		err = tx.Model(&model.Message{}).
+			Where("id IN ?", expired).
+			Updates(map[string]interface{}{
+				"status":          model.MessageStatusExpired,
+				"next_attempt_at": nil,
+			}).Error
+		if err != nil {
+			return err
+		}
+		return recordTransitions(tx, expired, model.MessageStatusPending, model.MessageStatusExpired, "expired before it could be sent")
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(expired)), nil
+}
+
+// lockMessageIDs locks the messages matched by scope and returns their IDs
+func lockMessageIDs(tx *gorm.DB, scope func(*gorm.DB) *gorm.DB) ([]uint, error) {
+	var ids []uint
+	err := scope(tx.Model(&model.Message{}).Clauses(clause.Locking{Strength: "UPDATE"})).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// RescheduleAttempt records a failed send of a message leased to owner and
+// returns it to pending, to be picked up again at nextAttemptAt
+func (r *MessageRepositoryImpl) RescheduleAttempt(ctx context.Context, id uint, owner string, lastError string, nextAttemptAt time.Time) error {
+	return r.recordFailedAttempt(ctx, id, owner, lastError, map[string]interface{}{
+		"status":           model.MessageStatusPending,
+		"next_attempt_at":  nextAttemptAt,
+		"claimed_by":       "",
+		"lease_expires_at": nil,
+	})
+}
+
+// DeferClaim hands a message leased to owner back to pending without using up
+// an attempt, to be picked up again at until. The reason is recorded with the
+// status change.
+func (r *MessageRepositoryImpl) DeferClaim(ctx context.Context, id uint, owner string, until time.Time, reason string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Message{}).
+			Where("id = ? AND status = ? AND claimed_by = ?", id, model.MessageStatusProcessing, owner).
+			Updates(map[string]interface{}{
+				"status":           model.MessageStatusPending,
+				"next_attempt_at":  until,
+				"claimed_by":       "",
+				"lease_expires_at": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return transitionError(tx, id, model.MessageStatusPending)
+		}
+		return recordTransitions(tx, []uint{id}, model.MessageStatusProcessing, model.MessageStatusPending, reason)
+	})
+}
+
+// MarkFailed records the last failed send of a message leased to owner and
+// moves it to the terminal failed state
+func (r *MessageRepositoryImpl) MarkFailed(ctx context.Context, id uint, owner string, lastError string) error {
+	return r.recordFailedAttempt(ctx, id, owner, lastError, map[string]interface{}{
+		"status":           model.MessageStatusFailed,
+		"next_attempt_at":  nil,
+		"lease_expires_at": nil,
+	})
+}
+
+// MarkExpired moves a message leased to owner to expired instead of sending it
+func (r *MessageRepositoryImpl) MarkExpired(ctx context.Context, id uint, owner string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Message{}).
+			Where("id = ? AND status = ? AND claimed_by = ?", id, model.MessageStatusProcessing, owner).
+			Updates(map[string]interface{}{
+				"status":           model.MessageStatusExpired,
+				"next_attempt_at":  nil,
+				"lease_expires_at": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return transitionError(tx, id, model.MessageStatusExpired)
+		}
+		return recordTransitions(tx, []uint{id}, model.MessageStatusProcessing, model.MessageStatusExpired, "expired before it could be sent")
+	})
+}
+
+// CountByStatus returns the number of messages in every status that has any
+func (r *MessageRepositoryImpl) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := r.db.WithContext(ctx).Model(&model.Message{}).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// recordFailedAttempt applies updates to a message leased to owner, bumps its
+// attempt count and appends the failure to its attempt history
+func (r *MessageRepositoryImpl) recordFailedAttempt(ctx context.Context, id uint, owner string, lastError string, updates map[string]interface{}) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updates["attempt_count"] = gorm.Expr("attempt_count + 1")
+		updates["last_error"] = lastError
+
+		result := tx.Model(&model.Message{}).
+			Where("id = ? AND status = ? AND claimed_by = ?", id, model.MessageStatusProcessing, owner).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return transitionError(tx, id, updates["status"].(string))
+		}
+
+		var attempt int
+		if err := tx.Model(&model.Message{}).Where("id = ?", id).Pluck("attempt_count", &attempt).Error; err != nil {
+			return err
+		}
+
+		err := tx.Create(&model.MessageAttempt{
+			MessageID:   id,
+			Attempt:     attempt,
+			Error:       lastError,
+			AttemptedAt: time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return recordTransitions(tx, []uint{id}, model.MessageStatusProcessing, updates["status"].(string), lastError)
+	})
+}
+
+// DeadLetterFilter narrows down failed messages. Zero values match everything.
+type DeadLetterFilter struct {
+	To            string
+	ErrorContains string
+	FailedAfter   time.Time
+	FailedBefore  time.Time
+	Limit         int
+}
+
+func (f DeadLetterFilter) apply(db *gorm.DB) *gorm.DB {
+	db = db.Where("status = ?", model.MessageStatusFailed)
+	if f.To != "" {
+		db = db.Where("\"to\" = ?", f.To)
+	}
+	if f.ErrorContains != "" {
+		db = db.Where("last_error ILIKE ?", "%"+f.ErrorContains+"%")
+	}
+	if !f.FailedAfter.IsZero() {
+		db = db.Where("updated_at >= ?", f.FailedAfter)
+	}
+	if !f.FailedBefore.IsZero() {
+		db = db.Where("updated_at < ?", f.FailedBefore)
+	}
+	return db
+}
+
+// FindDeadLetters returns failed messages matching filter, most recently failed
+// first, together with their attempt history
+func (r *MessageRepositoryImpl) FindDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]*model.Message, error) {
+	var messages []*model.Message
+	query := filter.apply(r.db.WithContext(ctx)).
+		Preload("Attempts", func(db *gorm.DB) *gorm.DB {
+			return db.Order("attempted_at ASC")
+		}).
+		Order("updated_at DESC")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// RequeueDeadLetter moves a failed message back to pending with a clean retry state
+func (r *MessageRepositoryImpl) RequeueDeadLetter(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Message{}).
+			Where("id = ? AND status = ?", id, model.MessageStatusFailed).
+			Updates(requeueUpdates(time.Now()))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotDeadLetter
+		}
+		return recordTransitions(tx, []uint{id}, model.MessageStatusFailed, model.MessageStatusPending, "")
+	})
+}
+
+// RequeueDeadLetters moves all failed messages matching filter back to pending
+// with a clean retry state and returns how many were requeued
+func (r *MessageRepositoryImpl) RequeueDeadLetters(ctx context.Context, filter DeadLetterFilter) (int64, error) {
+	var requeued []uint
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		requeued, err = lockMessageIDs(tx, func(db *gorm.DB) *gorm.DB {
+			db = filter.apply(db).Order("updated_at DESC")
+			if filter.Limit > 0 {
+				db = db.Limit(filter.Limit)
+			}
+			return db
+		})
+		if err != nil || len(requeued) == 0 {
+			return err
+		}
+
+		err = tx.Model(&model.Message{}).
+			Where("id IN ?", requeued).
+			Updates(requeueUpdates(time.Now())).Error
+		if err != nil {
+			return err
+		}
+		return recordTransitions(tx, requeued, model.MessageStatusFailed, model.MessageStatusPending, "")
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(requeued)), nil
+}
+
+// requeueUpdates resets the retry bookkeeping of a message. The attempt
+// history is kept for reference. An expiry that has passed by now is dropped,
+// otherwise the message would expire again before it could be sent.
+func requeueUpdates(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"expires_at":       gorm.Expr("CASE WHEN expires_at <= ? THEN NULL ELSE expires_at END", now),
+		"status":           model.MessageStatusPending,
+		"attempt_count":    0,
+		"last_error":       "",
+		"next_attempt_at":  nil,
+		"claimed_by":       "",
+		"lease_expires_at": nil,
+	}
 }
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -67,8 +68,8 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		t.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Auto-migrate the Message model
-	if err := db.AutoMigrate(&model.Message{}); err != nil {
+	// Auto-migrate the Message models
+	if err := db.AutoMigrate(&model.Message{}, &model.MessageAttempt{}, &model.MessageEvent{}, &model.RecurringSchedule{}, &model.Recipient{}); err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
 
@@ -144,11 +145,18 @@ func TestMessageRepository_UpdateStatus(t *testing.T) {
 	}
 
 	// Update the status
-	newStatus := model.MessageStatusSent
+	newStatus := model.MessageStatusCancelled
 	if err := repo.UpdateStatus(context.Background(), message.ID, newStatus); err != nil {
 		t.Errorf("UpdateStatus() error = %v", err)
 	}
 
+	// A cancelled message cannot be sent anymore
+	err := repo.UpdateStatus(context.Background(), message.ID, model.MessageStatusSent)
+	var transitionErr *model.InvalidTransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Errorf("Expected InvalidTransitionError, got %v", err)
+	}
+
 	// Verify the update
 	found, err := repo.FindByID(context.Background(), message.ID)
 	if err != nil {
@@ -205,3 +213,775 @@ func TestMessageRepository_FindPendingBefore(t *testing.T) {
 		t.Errorf("Expected message content %q, got %q", "Past message", found[0].Content)
 	}
 }
+
+func TestMessageRepository_NextDueAt(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	next, err := repo.NextDueAt(context.Background())
+	if err != nil || next != nil {
+		t.Fatalf("Expected no due time without pending messages, got %v, %v", next, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	retryAt := now.Add(30 * time.Minute)
+	messages := []*model.Message{
+		// Due at its retry, which is later than its scheduled time
+		{Content: "Retried", To: "test@example.com", Status: model.MessageStatusPending, ScheduledAt: now.Add(-time.Hour), NextAttemptAt: &retryAt},
+		{Content: "Later", To: "test@example.com", Status: model.MessageStatusPending, ScheduledAt: now.Add(time.Hour)},
+		{Content: "Sent", To: "test@example.com", Status: model.MessageStatusSent, ScheduledAt: now.Add(-2 * time.Hour)},
+	}
+	for _, msg := range messages {
+		if err := repo.Create(context.Background(), msg); err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+	}
+
+	next, err = repo.NextDueAt(context.Background())
+	if err != nil {
+		t.Fatalf("NextDueAt() error = %v", err)
+	}
+	if next == nil || !next.Equal(retryAt) {
+		t.Errorf("Expected next due time %s, got %v", retryAt, next)
+	}
+}
+
+func TestMessageRepository_ClaimPending(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		msg := &model.Message{
+			Content:     fmt.Sprintf("Message %d", i),
+			To:          "test@example.com",
+			Status:      model.MessageStatusPending,
+			ScheduledAt: now.Add(-time.Duration(i+1) * time.Minute),
+		}
+		if err := repo.Create(context.Background(), msg); err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+	}
+
+	// Two replicas claiming concurrently must not get the same message
+	type claimResult struct {
+		messages []*model.Message
+		err      error
+	}
+	results := make(chan claimResult, 2)
+	for _, owner := range []string{"replica-a", "replica-b"} {
+		go func(owner string) {
+			msgs, err := repo.ClaimPending(context.Background(), owner, now, 3, 0, time.Minute)
+			results <- claimResult{msgs, err}
+		}(owner)
+	}
+
+	seen := make(map[uint]bool)
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.err != nil {
+			t.Fatalf("ClaimPending() error = %v", res.err)
+		}
+		for _, msg := range res.messages {
+			if seen[msg.ID] {
+				t.Errorf("Message %d claimed twice", msg.ID)
+			}
+			seen[msg.ID] = true
+			if msg.Status != model.MessageStatusProcessing {
+				t.Errorf("Expected status %q, got %q", model.MessageStatusProcessing, msg.Status)
+			}
+		}
+	}
+	if len(seen) != 4 {
+		t.Errorf("Expected 4 claimed messages, got %d", len(seen))
+	}
+
+	// Nothing is left to claim
+	left, err := repo.ClaimPending(context.Background(), "replica-c", now, 10, 0, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+	if len(left) != 0 {
+		t.Errorf("Expected no messages left to claim, got %d", len(left))
+	}
+}
+
+func TestMessageRepository_ClaimPendingByPriority(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now()
+	messages := []*model.Message{
+		{Content: "Newsletter 1", Priority: model.PriorityLow, ScheduledAt: now.Add(-3 * time.Hour)},
+		{Content: "Newsletter 2", Priority: model.PriorityLow, ScheduledAt: now.Add(-2 * time.Hour)},
+		{Content: "Reminder", Priority: model.PriorityHigh, ScheduledAt: now.Add(-time.Hour)},
+		{Content: "Code 1", Priority: model.PriorityCritical, ScheduledAt: now.Add(-2 * time.Minute)},
+		{Content: "Code 2", Priority: model.PriorityCritical, ScheduledAt: now.Add(-time.Minute)},
+	}
+	for _, msg := range messages {
+		msg.To = "test@example.com"
+		msg.Status = model.MessageStatusPending
+		if err := repo.Create(context.Background(), msg); err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+	}
+
+	contents := func(msgs []*model.Message) []string {
+		var out []string
+		for _, msg := range msgs {
+			out = append(out, msg.Content)
+		}
+		return out
+	}
+
+	// Due messages are dispatched by priority and then scheduled time
+	pending, err := repo.FindPendingBefore(context.Background(), now, 10)
+	if err != nil {
+		t.Fatalf("FindPendingBefore() error = %v", err)
+	}
+	expected := []string{"Code 1", "Code 2", "Reminder", "Newsletter 1", "Newsletter 2"}
+	if fmt.Sprint(contents(pending)) != fmt.Sprint(expected) {
+		t.Errorf("Expected order %v, got %v", expected, contents(pending))
+	}
+
+	// The fair slot goes to the longest waiting message of any priority
+	claimed, err := repo.ClaimPending(context.Background(), "replica", now, 3, 1, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+	expected = []string{"Code 1", "Code 2", "Newsletter 1"}
+	if fmt.Sprint(contents(claimed)) != fmt.Sprint(expected) {
+		t.Errorf("Expected claimed %v, got %v", expected, contents(claimed))
+	}
+
+	claimed, err = repo.ClaimPending(context.Background(), "replica", now, 3, 0, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+	expected = []string{"Reminder", "Newsletter 2"}
+	if fmt.Sprint(contents(claimed)) != fmt.Sprint(expected) {
+		t.Errorf("Expected claimed %v, got %v", expected, contents(claimed))
+	}
+}
+
+func TestMessageRepository_RecoverExpiredLeases(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	message := &model.Message{
+		Content:     "Test message",
+		To:          "test@example.com",
+		Status:      model.MessageStatusPending,
+		ScheduledAt: time.Now().Add(-1 * time.Minute),
+	}
+	if err := repo.Create(context.Background(), message); err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+
+	if _, err := repo.ClaimPending(context.Background(), "crashed-replica", time.Now(), 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+
+	// The lease is still valid
+	recovered, err := repo.RecoverExpiredLeases(context.Background(), time.Now())
+	if err != nil {
+		t.Fatalf("RecoverExpiredLeases() error = %v", err)
+	}
+	if recovered != 0 {
+		t.Errorf("Expected no recovered messages, got %d", recovered)
+	}
+
+	// The lease has expired
+	recovered, err = repo.RecoverExpiredLeases(context.Background(), time.Now().Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("RecoverExpiredLeases() error = %v", err)
+	}
+	if recovered != 1 {
+		t.Errorf("Expected 1 recovered message, got %d", recovered)
+	}
+
+	found, err := repo.FindByID(context.Background(), message.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if found.Status != model.MessageStatusPending || found.ClaimedBy != "" {
+		t.Errorf("Expected message back in pending without owner, got %q owned by %q", found.Status, found.ClaimedBy)
+	}
+}
+
+func TestMessageRepository_RenewLease(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now()
+	message := &model.Message{
+		Content:     "Test message",
+		To:          "test@example.com",
+		Status:      model.MessageStatusPending,
+		ScheduledAt: now.Add(-1 * time.Minute),
+	}
+	if err := repo.Create(context.Background(), message); err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	if _, err := repo.ClaimPending(context.Background(), "replica", now, 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+
+	// A renewed lease is not recovered when the original one would have run out
+	if err := repo.RenewLease(context.Background(), message.ID, "other", now.Add(5*time.Minute)); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost renewing the lease of another replica, got %v", err)
+	}
+	if err := repo.RenewLease(context.Background(), message.ID, "replica", now.Add(5*time.Minute)); err != nil {
+		t.Fatalf("RenewLease() error = %v", err)
+	}
+	if recovered, err := repo.RecoverExpiredLeases(context.Background(), now.Add(2*time.Minute)); err != nil || recovered != 0 {
+		t.Fatalf("Expected no recovered messages, got %d, %v", recovered, err)
+	}
+
+	// Once recovered, the lease can no longer be renewed
+	if _, err := repo.RecoverExpiredLeases(context.Background(), now.Add(6*time.Minute)); err != nil {
+		t.Fatalf("RecoverExpiredLeases() error = %v", err)
+	}
+	if err := repo.RenewLease(context.Background(), message.ID, "replica", now.Add(10*time.Minute)); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost after the lease was recovered, got %v", err)
+	}
+}
+
+func TestMessageRepository_RescheduleAttempt(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now()
+	message := &model.Message{
+		Content:     "Test message",
+		To:          "test@example.com",
+		Status:      model.MessageStatusPending,
+		ScheduledAt: now.Add(-1 * time.Minute),
+	}
+	if err := repo.Create(context.Background(), message); err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	if _, err := repo.ClaimPending(context.Background(), "replica", now, 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+
+	next := now.Add(10 * time.Minute)
+	if err := repo.RescheduleAttempt(context.Background(), message.ID, "replica", "webhook error", next); err != nil {
+		t.Fatalf("RescheduleAttempt() error = %v", err)
+	}
+
+	found, err := repo.FindByID(context.Background(), message.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if found.Status != model.MessageStatusPending || found.AttemptCount != 1 || found.LastError != "webhook error" {
+		t.Errorf("Unexpected message after reschedule: %+v", found)
+	}
+
+	// Not due before its next attempt
+	pending, err := repo.FindPendingBefore(context.Background(), now, 10)
+	if err != nil {
+		t.Fatalf("FindPendingBefore() error = %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Expected no due messages before next attempt, got %d", len(pending))
+	}
+
+	pending, err = repo.FindPendingBefore(context.Background(), next.Add(time.Second), 10)
+	if err != nil {
+		t.Fatalf("FindPendingBefore() error = %v", err)
+	}
+	if len(pending) != 1 {
+		t.Errorf("Expected 1 due message after next attempt, got %d", len(pending))
+	}
+
+	// Claim again and use up the attempts
+	if _, err := repo.ClaimPending(context.Background(), "replica", next.Add(time.Second), 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+	if err := repo.MarkFailed(context.Background(), message.ID, "replica", "still failing"); err != nil {
+		t.Fatalf("MarkFailed() error = %v", err)
+	}
+	found, err = repo.FindByID(context.Background(), message.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if found.Status != model.MessageStatusFailed || found.AttemptCount != 2 {
+		t.Errorf("Expected failed message with 2 attempts, got %q with %d", found.Status, found.AttemptCount)
+	}
+}
+
+func TestMessageRepository_DeferClaim(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now()
+	message := &model.Message{
+		Content:     "Test message",
+		To:          "test@example.com",
+		Status:      model.MessageStatusPending,
+		ScheduledAt: now.Add(-1 * time.Minute),
+	}
+	if err := repo.Create(context.Background(), message); err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	if _, err := repo.ClaimPending(context.Background(), "replica", now, 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+
+	// Only the replica holding the lease can defer it
+	until := now.Add(5 * time.Second)
+	if err := repo.DeferClaim(context.Background(), message.ID, "other", until, "throttled"); err == nil {
+		t.Error("Expected error deferring a message claimed by another replica")
+	}
+	if err := repo.DeferClaim(context.Background(), message.ID, "replica", until, "throttled"); err != nil {
+		t.Fatalf("DeferClaim() error = %v", err)
+	}
+
+	found, err := repo.FindByID(context.Background(), message.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if found.Status != model.MessageStatusPending || found.AttemptCount != 0 || found.ClaimedBy != "" {
+		t.Errorf("Unexpected message after defer: %+v", found)
+	}
+
+	pending, err := repo.FindPendingBefore(context.Background(), now, 10)
+	if err != nil {
+		t.Fatalf("FindPendingBefore() error = %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Expected no due messages while deferred, got %d", len(pending))
+	}
+	pending, err = repo.FindPendingBefore(context.Background(), until.Add(time.Second), 10)
+	if err != nil {
+		t.Fatalf("FindPendingBefore() error = %v", err)
+	}
+	if len(pending) != 1 {
+		t.Errorf("Expected 1 due message once deferral ends, got %d", len(pending))
+	}
+}
+
+func TestMessageRepository_Expiry(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	overdue := now.Add(-time.Minute)
+	soon := now.Add(10 * time.Minute)
+	messages := []*model.Message{
+		{Content: "Overdue", ScheduledAt: now.Add(-time.Hour), ExpiresAt: &overdue},
+		{Content: "Expires soon", ScheduledAt: now.Add(-time.Hour), ExpiresAt: &soon},
+		{Content: "Never expires", ScheduledAt: now.Add(-time.Hour)},
+	}
+	for _, msg := range messages {
+		msg.To = "test@example.com"
+		msg.Status = model.MessageStatusPending
+		if err := repo.Create(context.Background(), msg); err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+	}
+
+	expired, err := repo.ExpireOverdue(context.Background(), now)
+	if err != nil {
+		t.Fatalf("ExpireOverdue() error = %v", err)
+	}
+	if expired != 1 {
+		t.Errorf("Expected 1 expired message, got %d", expired)
+	}
+	found, err := repo.FindByID(context.Background(), messages[0].ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if found.Status != model.MessageStatusExpired {
+		t.Errorf("Expected status %q, got %q", model.MessageStatusExpired, found.Status)
+	}
+
+	// A retry planned past the expiry is due at the expiry instead
+	retryAt := now.Add(time.Hour)
+	if err := db.Model(&model.Message{}).Where("id = ?", messages[1].ID).Update("next_attempt_at", retryAt).Error; err != nil {
+		t.Fatalf("Failed to plan retry: %v", err)
+	}
+	if err := db.Model(&model.Message{}).Where("id = ?", messages[2].ID).Update("next_attempt_at", retryAt).Error; err != nil {
+		t.Fatalf("Failed to plan retry: %v", err)
+	}
+	next, err := repo.NextDueAt(context.Background())
+	if err != nil {
+		t.Fatalf("NextDueAt() error = %v", err)
+	}
+	if next == nil || !next.Equal(soon) {
+		t.Errorf("Expected next due time %s, got %v", soon, next)
+	}
+
+	// A claimed message can only be expired by the replica holding it
+	if err := db.Model(&model.Message{}).Where("id = ?", messages[1].ID).Update("next_attempt_at", nil).Error; err != nil {
+		t.Fatalf("Failed to clear retry: %v", err)
+	}
+	if _, err := repo.ClaimPending(context.Background(), "replica", now, 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+	if err := repo.MarkExpired(context.Background(), messages[1].ID, "other"); err == nil {
+		t.Error("Expected error expiring a message claimed by another replica")
+	}
+	if err := repo.MarkExpired(context.Background(), messages[1].ID, "replica"); err != nil {
+		t.Fatalf("MarkExpired() error = %v", err)
+	}
+
+	counts, err := repo.CountByStatus(context.Background())
+	if err != nil {
+		t.Fatalf("CountByStatus() error = %v", err)
+	}
+	if counts[model.MessageStatusExpired] != 2 || counts[model.MessageStatusPending] != 1 {
+		t.Errorf("Expected 2 expired and 1 pending message, got %v", counts)
+	}
+}
+
+func TestMessageRepository_DeadLetters(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now()
+	expiresAt := now.Add(time.Second)
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		message := &model.Message{
+			Content:     "Test message",
+			To:          to,
+			Status:      model.MessageStatusPending,
+			ScheduledAt: now.Add(-1 * time.Minute),
+			ExpiresAt:   &expiresAt,
+		}
+		if err := repo.Create(context.Background(), message); err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		if _, err := repo.ClaimPending(context.Background(), "replica", now, 1, 0, time.Minute); err != nil {
+			t.Fatalf("ClaimPending() error = %v", err)
+		}
+		if err := repo.MarkFailed(context.Background(), message.ID, "replica", "connection refused"); err != nil {
+			t.Fatalf("MarkFailed() error = %v", err)
+		}
+	}
+
+	failed, err := repo.FindDeadLetters(context.Background(), DeadLetterFilter{ErrorContains: "refused"})
+	if err != nil {
+		t.Fatalf("FindDeadLetters() error = %v", err)
+	}
+	if len(failed) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %d", len(failed))
+	}
+	if len(failed[0].Attempts) != 1 || failed[0].Attempts[0].Error != "connection refused" {
+		t.Errorf("Expected attempt history to be loaded, got %+v", failed[0].Attempts)
+	}
+
+	// Both messages are past their expiry by the time they are requeued
+	time.Sleep(time.Until(expiresAt))
+	if err := repo.RequeueDeadLetter(context.Background(), failed[0].ID); err != nil {
+		t.Fatalf("RequeueDeadLetter() error = %v", err)
+	}
+	if err := repo.RequeueDeadLetter(context.Background(), failed[0].ID); err != ErrNotDeadLetter {
+		t.Errorf("Expected ErrNotDeadLetter for pending message, got %v", err)
+	}
+
+	requeued, err := repo.RequeueDeadLetters(context.Background(), DeadLetterFilter{To: failed[1].To})
+	if err != nil {
+		t.Fatalf("RequeueDeadLetters() error = %v", err)
+	}
+	if requeued != 1 {
+		t.Errorf("Expected 1 requeued message, got %d", requeued)
+	}
+
+	found, err := repo.FindByID(context.Background(), failed[1].ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if found.Status != model.MessageStatusPending || found.AttemptCount != 0 || found.LastError != "" || found.ExpiresAt != nil {
+		t.Errorf("Expected reset retry state, got %+v", found)
+	}
+
+	// The requeued messages are sent rather than expired again
+	if expired, err := repo.ExpireOverdue(context.Background(), time.Now()); err != nil || expired != 0 {
+		t.Errorf("Expected no requeued message to expire, got %d, %v", expired, err)
+	}
+}
+
+func TestMessageRepository_MarkSent(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now()
+	message := &model.Message{
+		Content:     "Test message",
+		To:          "test@example.com",
+		Status:      model.MessageStatusPending,
+		ScheduledAt: now.Add(-1 * time.Minute),
+	}
+	if err := repo.Create(context.Background(), message); err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+
+	// Pending messages have not been claimed and cannot be marked sent
+	err := repo.MarkSent(context.Background(), message.ID, "replica", "provider-1", "webhook", now)
+	var transitionErr *model.InvalidTransitionError
+	if !errors.As(err, &transitionErr) || transitionErr.From != model.MessageStatusPending {
+		t.Fatalf("Expected InvalidTransitionError from pending, got %v", err)
+	}
+
+	if _, err := repo.ClaimPending(context.Background(), "replica", now, 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+	if err := repo.MarkSent(context.Background(), message.ID, "other", "provider-1", "webhook", now); err == nil {
+		t.Error("Expected error marking sent a message claimed by another replica")
+	}
+	if err := repo.MarkSent(context.Background(), message.ID, "replica", "provider-1", "webhook", now); err != nil {
+		t.Fatalf("MarkSent() error = %v", err)
+	}
+
+	found, err := repo.FindByID(context.Background(), message.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if found.Status != model.MessageStatusSent || found.MessageID != "provider-1" || found.DeliveredBy != "webhook" || found.SentAt.IsZero() {
+		t.Errorf("Expected sent message with ID, provider and sent time, got %+v", found)
+	}
+
+	// Marking it sent twice is rejected
+	if err := repo.MarkSent(context.Background(), message.ID, "replica", "provider-2", "webhook", now); !errors.As(err, &transitionErr) {
+		t.Errorf("Expected InvalidTransitionError, got %v", err)
+	}
+}
+
+func TestMessageRepository_FindEvents(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now()
+	message := &model.Message{
+		Content:     "Test message",
+		To:          "test@example.com",
+		Status:      model.MessageStatusPending,
+		ScheduledAt: now.Add(-1 * time.Minute),
+	}
+	apiCtx := WithActor(context.Background(), model.ActorAPI, "192.0.2.1")
+	if err := repo.Create(apiCtx, message); err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+
+	dispatcherCtx := WithActor(context.Background(), model.ActorDispatcher, "replica")
+	if _, err := repo.ClaimPending(dispatcherCtx, "replica", now, 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+	if err := repo.RescheduleAttempt(dispatcherCtx, message.ID, "replica", "webhook returned 503", now); err != nil {
+		t.Fatalf("RescheduleAttempt() error = %v", err)
+	}
+	if _, err := repo.ClaimPending(dispatcherCtx, "replica", now, 1, 0, time.Minute); err != nil {
+		t.Fatalf("ClaimPending() error = %v", err)
+	}
+	if err := repo.MarkSent(dispatcherCtx, message.ID, "replica", "provider-1", "webhook", now); err != nil {
+		t.Fatalf("MarkSent() error = %v", err)
+	}
+
+	events, err := repo.FindEvents(context.Background(), message.ID)
+	if err != nil {
+		t.Fatalf("FindEvents() error = %v", err)
+	}
+
+	expected := []model.MessageEvent{
+		{FromStatus: "", ToStatus: model.MessageStatusPending, Actor: model.ActorAPI, ActorID: "192.0.2.1"},
+		{FromStatus: model.MessageStatusPending, ToStatus: model.MessageStatusProcessing, Actor: model.ActorDispatcher, ActorID: "replica"},
+		{FromStatus: model.MessageStatusProcessing, ToStatus: model.MessageStatusPending, Actor: model.ActorDispatcher, ActorID: "replica", Error: "webhook returned 503"},
+		{FromStatus: model.MessageStatusPending, ToStatus: model.MessageStatusProcessing, Actor: model.ActorDispatcher, ActorID: "replica"},
+		{FromStatus: model.MessageStatusProcessing, ToStatus: model.MessageStatusSent, Actor: model.ActorDispatcher, ActorID: "replica"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, want := range expected {
+		got := events[i]
+		if got.FromStatus != want.FromStatus || got.ToStatus != want.ToStatus ||
+			got.Actor != want.Actor || got.ActorID != want.ActorID || got.Error != want.Error {
+			t.Errorf("Event %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	// Rejected transitions leave no trace
+	if err := repo.UpdateStatus(context.Background(), message.ID, model.MessageStatusCancelled); err == nil {
+		t.Fatal("Expected sent message not to be cancellable")
+	}
+	events, err = repo.FindEvents(context.Background(), message.ID)
+	if err != nil {
+		t.Fatalf("FindEvents() error = %v", err)
+	}
+	if len(events) != len(expected) {
+		t.Errorf("Expected rejected transition not to be recorded, got %d events", len(events))
+	}
+}
+
+func TestMessageRepository_Schedules(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	next := now.Add(time.Hour)
+	schedule := &model.RecurringSchedule{
+		Content:   "Daily digest",
+		To:        "test@example.com",
+		Cron:      "@daily",
+		Timezone:  "UTC",
+		StartAt:   now,
+		Status:    model.ScheduleStatusActive,
+		NextRunAt: &next,
+	}
+	if err := repo.CreateSchedule(context.Background(), schedule); err != nil {
+		t.Fatalf("CreateSchedule() error = %v", err)
+	}
+
+	due, err := repo.FindDueSchedules(context.Background(), now, 10)
+	if err != nil {
+		t.Fatalf("FindDueSchedules() error = %v", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("Expected no due schedules, got %d", len(due))
+	}
+	due, err = repo.FindDueSchedules(context.Background(), next, 10)
+	if err != nil {
+		t.Fatalf("FindDueSchedules() error = %v", err)
+	}
+	if len(due) != 1 {
+		t.Fatalf("Expected 1 due schedule, got %d", len(due))
+	}
+
+	// Advancing creates the messages of the passed occurrences
+	advanced := due[0]
+	stale := *advanced
+	following := next.Add(24 * time.Hour)
+	advanced.Occurrences = 1
+	advanced.NextRunAt = &following
+	messages := []*model.Message{{
+		Content:     advanced.Content,
+		To:          advanced.To,
+		ScheduledAt: next,
+		Status:      model.MessageStatusPending,
+		ScheduleID:  &advanced.ID,
+	}}
+	if err := repo.AdvanceSchedule(context.Background(), advanced, messages); err != nil {
+		t.Fatalf("AdvanceSchedule() error = %v", err)
+	}
+	if messages[0].ID == 0 {
+		t.Fatal("Expected the scheduled message to be created")
+	}
+
+	// A replica holding the schedule as it was before cannot advance it again
+	if err := repo.AdvanceSchedule(context.Background(), &stale, nil); !errors.Is(err, ErrScheduleChanged) {
+		t.Errorf("Expected ErrScheduleChanged, got %v", err)
+	}
+
+	// Pausing cancels the pending messages, which no longer count
+	advanced.Status = model.ScheduleStatusPaused
+	if err := repo.UpdateSchedule(context.Background(), advanced, "schedule paused"); err != nil {
+		t.Fatalf("UpdateSchedule() error = %v", err)
+	}
+	found, err := repo.FindScheduleByID(context.Background(), advanced.ID)
+	if err != nil {
+		t.Fatalf("FindScheduleByID() error = %v", err)
+	}
+	if found.Status != model.ScheduleStatusPaused || found.Occurrences != 0 {
+		t.Errorf("Expected a paused schedule with no occurrences, got %q with %d", found.Status, found.Occurrences)
+	}
+	msg, err := repo.FindByID(context.Background(), messages[0].ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if msg.Status != model.MessageStatusCancelled {
+		t.Errorf("Expected status %q, got %q", model.MessageStatusCancelled, msg.Status)
+	}
+	if err := repo.AdvanceSchedule(context.Background(), found, nil); !errors.Is(err, ErrScheduleChanged) {
+		t.Errorf("Expected a paused schedule not to advance, got %v", err)
+	}
+
+	if err := repo.DeleteSchedule(context.Background(), advanced.ID); err != nil {
+		t.Fatalf("DeleteSchedule() error = %v", err)
+	}
+	if _, err := repo.FindScheduleByID(context.Background(), advanced.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Expected the schedule to be deleted, got %v", err)
+	}
+}
+
+func TestMessageRepository_SaveRecipient(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewMessageRepository(db)
+
+	// 09:00 in New York, with a two hour window to send it
+	scheduledAt := time.Date(2030, 4, 26, 13, 0, 0, 0, time.UTC)
+	expiresAt := scheduledAt.Add(2 * time.Hour)
+	messages := []*model.Message{
+		{To: "Test@Example.com", ScheduledAt: scheduledAt, ExpiresAt: &expiresAt, LocalTime: "2030-04-26T09:00:00", Timezone: "America/New_York", RecipientTimezone: true},
+		{To: "test@example.com", ScheduledAt: scheduledAt, LocalTime: "2030-04-26T09:00:00", Timezone: "America/New_York"},
+		{To: "test@example.com", ScheduledAt: scheduledAt},
+		{To: "other@example.com", ScheduledAt: scheduledAt, LocalTime: "2030-04-26T09:00:00", Timezone: "America/New_York", RecipientTimezone: true},
+	}
+	for _, msg := range messages {
+		msg.Content = "Good morning"
+		msg.Status = model.MessageStatusPending
+		if err := repo.Create(context.Background(), msg); err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+	}
+
+	recipient := &model.Recipient{Channel: model.ChannelEmail, To: "TEST@example.com", Timezone: "America/New_York"}
+	rescheduled, err := repo.SaveRecipient(context.Background(), recipient)
+	if err != nil {
+		t.Fatalf("SaveRecipient() error = %v", err)
+	}
+	if rescheduled != 0 {
+		t.Errorf("Expected no message to move while the time zone stays, got %d", rescheduled)
+	}
+	found, err := repo.FindRecipient(context.Background(), model.ChannelEmail, "test@EXAMPLE.com")
+	if err != nil {
+		t.Fatalf("FindRecipient() error = %v", err)
+	}
+	if found.ID != recipient.ID {
+		t.Errorf("Expected recipient %d, got %d", recipient.ID, found.ID)
+	}
+
+	// Moving to Berlin moves only the message that follows the recipient
+	rescheduled, err = repo.SaveRecipient(context.Background(), &model.Recipient{Channel: model.ChannelEmail, To: "test@example.com", Timezone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatalf("SaveRecipient() error = %v", err)
+	}
+	if rescheduled != 1 {
+		t.Errorf("Expected 1 rescheduled message, got %d", rescheduled)
+	}
+	moved, err := repo.FindByID(context.Background(), messages[0].ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	expected := time.Date(2030, 4, 26, 7, 0, 0, 0, time.UTC)
+	if !moved.ScheduledAt.Equal(expected) || moved.Timezone != "Europe/Berlin" {
+		t.Errorf("Expected the message at %s in Europe/Berlin, got %s in %s", expected, moved.ScheduledAt, moved.Timezone)
+	}
+	if moved.ExpiresAt == nil || !moved.ExpiresAt.Equal(expected.Add(2*time.Hour)) {
+		t.Errorf("Expected the expiry to move along, got %v", moved.ExpiresAt)
+	}
+	for _, msg := range messages[1:] {
+		kept, err := repo.FindByID(context.Background(), msg.ID)
+		if err != nil {
+			t.Fatalf("FindByID() error = %v", err)
+		}
+		if !kept.ScheduledAt.Equal(scheduledAt) {
+			t.Errorf("Expected message %d to stay at %s, got %s", msg.ID, scheduledAt, kept.ScheduledAt)
+		}
+	}
+
+	recipients, err := repo.FindRecipients(context.Background())
+	if err != nil {
+		t.Fatalf("FindRecipients() error = %v", err)
+	}
+	if len(recipients) != 1 || recipients[0].Timezone != "Europe/Berlin" {
+		t.Errorf("Expected a single recipient in Europe/Berlin, got %+v", recipients)
+	}
+	if err := repo.DeleteRecipient(context.Background(), recipient.ID); err != nil {
+		t.Fatalf("DeleteRecipient() error = %v", err)
+	}
+	if err := repo.DeleteRecipient(context.Background(), recipient.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Expected gorm.ErrRecordNotFound, got %v", err)
+	}
+}
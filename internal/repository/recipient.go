@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"auto-messaging/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FindRecipients returns all recipients, oldest first
+func (r *MessageRepositoryImpl) FindRecipients(ctx context.Context) ([]*model.Recipient, error) {
+	var recipients []*model.Recipient
+	if err := r.db.WithContext(ctx).Order("id ASC").Find(&recipients).Error; err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// FindRecipient returns the recipient with a normalized address on channel
+func (r *MessageRepositoryImpl) FindRecipient(ctx context.Context, channel, to string) (*model.Recipient, error) {
+	var recipient model.Recipient
+	err := r.db.WithContext(ctx).
+		Where("channel = ? AND \"to\" = ?", channel, model.RecipientAddress(channel, to)).
+		First(&recipient).Error
+	if err != nil {
+		return nil, err
+	}
+	return &recipient, nil
+}
+
+// SaveRecipient creates the recipient or changes its time zone. Pending
+// messages that follow the recipient's time zone are moved to their local
+// time in the new zone; it returns how many were.
+func (r *MessageRepositoryImpl) SaveRecipient(ctx context.Context, recipient *model.Recipient) (int64, error) {
+	loc, err := time.LoadLocation(recipient.Timezone)
+	if err != nil {
+		return 0, err
+	}
+	recipient.To = model.RecipientAddress(recipient.Channel, recipient.To)
+
+	var rescheduled int64
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "channel"}, {Name: "to"}},
+			DoUpdates: clause.AssignmentColumns([]string{"timezone", "updated_at"}),
+		}).Create(recipient).Error
+		if err != nil {
+			return err
+		}
+		// An existing recipient keeps its ID and creation time, read them back
+		if err := tx.Where("channel = ? AND \"to\" = ?", recipient.Channel, recipient.To).First(recipient).Error; err != nil {
+			return err
+		}
+
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ? AND recipient_timezone AND channel = ?", model.MessageStatusPending, recipient.Channel)
+		if recipient.Channel == model.ChannelEmail {
+			query = query.Where("LOWER(\"to\") = ?", recipient.To)
+		} else {
+			query = query.Where("\"to\" = ?", recipient.To)
+		}
+		var messages []*model.Message
+		if err := query.Find(&messages).Error; err != nil {
+			return err
+		}
+
+		for _, msg := range messages {
+			if msg.Timezone == loc.String() {
+				continue
+			}
+			if err := msg.Reschedule(loc); err != nil {
+				return err
+			}
+			err := tx.Model(&model.Message{}).
+				Where("id = ?", msg.ID).
+				Updates(map[string]interface{}{
+					"scheduled_at": msg.ScheduledAt,
+					"expires_at":   msg.ExpiresAt,
+					"timezone":     msg.Timezone,
+				}).Error
+			if err != nil {
+				return err
+			}
+			rescheduled++
+		}
+		return nil
+	})
+	return rescheduled, err
+}
+
+// DeleteRecipient deletes a recipient. Its pending messages keep the time
+// they were last scheduled at.
+func (r *MessageRepositoryImpl) DeleteRecipient(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Delete(&model.Recipient{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
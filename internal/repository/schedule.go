@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"auto-messaging/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrScheduleChanged is returned when a schedule was paused, changed or
+// advanced by someone else since it was read
+var ErrScheduleChanged = errors.New("schedule was changed concurrently")
+
+// CreateSchedule stores a new recurring schedule
+func (r *MessageRepositoryImpl) CreateSchedule(ctx context.Context, schedule *model.RecurringSchedule) error {
+	return r.db.WithContext(ctx).Create(schedule).Error
+}
+
+// FindSchedules returns all recurring schedules, oldest first
+func (r *MessageRepositoryImpl) FindSchedules(ctx context.Context) ([]*model.RecurringSchedule, error) {
+	var schedules []*model.RecurringSchedule
+	if err := r.db.WithContext(ctx).Order("id ASC").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (r *MessageRepositoryImpl) FindScheduleByID(ctx context.Context, id uint) (*model.RecurringSchedule, error) {
+	var schedule model.RecurringSchedule
+	if err := r.db.WithContext(ctx).First(&schedule, id).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// UpdateSchedule replaces a schedule with schedule. Messages it created that
+// are still pending are cancelled with reason and no longer count as
+// occurrences, so that they are created anew from the changed schedule.
+func (r *MessageRepositoryImpl) UpdateSchedule(ctx context.Context, schedule *model.RecurringSchedule, reason string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current model.RecurringSchedule
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "occurrences").
+			First(&current, schedule.ID).Error
+		if err != nil {
+			return err
+		}
+
+		cancelled, err := cancelScheduledMessages(tx, schedule.ID, reason)
+		if err != nil {
+			return err
+		}
+		schedule.Occurrences = max(current.Occurrences-cancelled, 0)
+		schedule.UpdatedAt = time.Now()
+
+		return tx.Model(&model.RecurringSchedule{}).
+			Where("id = ?", schedule.ID).
+			Updates(map[string]interface{}{
+				"content":         schedule.Content,
+				"to":              schedule.To,
+				"channel":         schedule.Channel,
+				"provider":        schedule.Provider,
+				"priority":        schedule.Priority,
+				"ttl":             schedule.TTL,
+				"cron":            schedule.Cron,
+				"rrule":           schedule.RRule,
+				"timezone":        schedule.Timezone,
+				"start_at":        schedule.StartAt,
+				"end_at":          schedule.EndAt,
+				"max_occurrences": schedule.MaxOccurrences,
+				"status":          schedule.Status,
+				"occurrences":     schedule.Occurrences,
+				"next_run_at":     schedule.NextRunAt,
+				"updated_at":      schedule.UpdatedAt,
+			}).Error
+	})
+}
+
+// DeleteSchedule deletes a schedule and cancels the messages it created that
+// are still pending
+func (r *MessageRepositoryImpl) DeleteSchedule(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var schedule model.RecurringSchedule
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&schedule, id).Error; err != nil {
+			return err
+		}
+		if _, err := cancelScheduledMessages(tx, id, "schedule deleted"); err != nil {
+			return err
+		}
+		return tx.Delete(&model.RecurringSchedule{}, id).Error
+	})
+}
+
+// FindDueSchedules returns up to limit active schedules whose next
+// occurrence is before before
+func (r *MessageRepositoryImpl) FindDueSchedules(ctx context.Context, before time.Time, limit int) ([]*model.RecurringSchedule, error) {
+	var schedules []*model.RecurringSchedule
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND next_run_at <= ?", model.ScheduleStatusActive, before).
+		Order("next_run_at ASC").
+		Limit(limit).
+		Find(&schedules).Error
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// AdvanceSchedule stores the occurrences, next run and status of schedule
+// and creates messages for the occurrences it has passed. It returns
+// ErrScheduleChanged, and creates nothing, if the schedule is no longer
+// active or was changed or advanced by someone else since it was read, as
+// told by its UpdatedAt.
+func (r *MessageRepositoryImpl) AdvanceSchedule(ctx context.Context, schedule *model.RecurringSchedule, messages []*model.Message) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&model.RecurringSchedule{}).
+			Where("id = ? AND status = ? AND updated_at = ?", schedule.ID, model.ScheduleStatusActive, schedule.UpdatedAt).
+			Updates(map[string]interface{}{
+				"occurrences": schedule.Occurrences,
+				"next_run_at": schedule.NextRunAt,
+				"status":      schedule.Status,
+				"updated_at":  now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrScheduleChanged
+		}
+		schedule.UpdatedAt = now
+		if len(messages) == 0 {
+			return nil
+		}
+
+		if err := tx.Create(&messages).Error; err != nil {
+			return err
+		}
+		ids := make([]uint, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+		}
+		return recordTransitions(tx, ids, "", model.MessageStatusPending, "")
+	})
+}
+
+// cancelScheduledMessages cancels the pending messages of a schedule and
+// returns how many there were
+func cancelScheduledMessages(tx *gorm.DB, scheduleID uint, reason string) (int, error) {
+	ids, err := lockMessageIDs(tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("schedule_id = ? AND status = ?", scheduleID, model.MessageStatusPending)
+	})
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	err = tx.Model(&model.Message{}).
+		Where("id IN ?", ids).
+		Update("status", model.MessageStatusCancelled).Error
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), recordTransitions(tx, ids, model.MessageStatusPending, model.MessageStatusCancelled, reason)
+}
@@ -23,6 +23,32 @@ func SetupRouter(messageHandler *handler.MessageHandler) *gin.Engine {
 			msgs.GET("", messageHandler.GetMessages)
 			msgs.GET("/:id", messageHandler.GetMessageByID)
 			msgs.PUT("/:id/status", messageHandler.UpdateMessageStatus)
+			msgs.GET("/:id/events", messageHandler.GetMessageEvents)
+			msgs.POST("/:id/requeue", messageHandler.RequeueMessage)
+
+			// Dead-letter queue
+			msgs.GET("/dead-letter", messageHandler.GetDeadLetters)
+			msgs.POST("/dead-letter/requeue", messageHandler.RequeueDeadLetters)
+		}
+
+		// Recurring schedules
+		schedules := api.Group("/schedules")
+		{
+			schedules.POST("", messageHandler.CreateSchedule)
+			schedules.GET("", messageHandler.GetSchedules)
+			schedules.GET("/:id", messageHandler.GetSchedule)
+			schedules.PUT("/:id", messageHandler.UpdateSchedule)
+			schedules.DELETE("/:id", messageHandler.DeleteSchedule)
+			schedules.POST("/:id/pause", messageHandler.PauseSchedule)
+			schedules.POST("/:id/resume", messageHandler.ResumeSchedule)
+		}
+
+		// Recipient time zones
+		recipients := api.Group("/recipients")
+		{
+			recipients.GET("", messageHandler.GetRecipients)
+			recipients.PUT("", messageHandler.SaveRecipient)
+			recipients.DELETE("/:id", messageHandler.DeleteRecipient)
 		}
 
 		// Message processing control
@@ -31,6 +57,11 @@ func SetupRouter(messageHandler *handler.MessageHandler) *gin.Engine {
 			ctrl.POST("/start", messageHandler.StartMessaging)
 			ctrl.POST("/stop", messageHandler.StopMessaging)
 			ctrl.GET("/sent", messageHandler.GetSentMessages)
+			ctrl.GET("/sent/:messageId", messageHandler.GetSentMessage)
+			ctrl.GET("/stats", messageHandler.GetStats)
+			ctrl.GET("/status", messageHandler.GetStatus)
+			ctrl.GET("/settings", messageHandler.GetSettings)
+			ctrl.PUT("/settings", messageHandler.UpdateSettings)
 		}
 	}
 
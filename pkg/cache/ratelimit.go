@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateBucket is a token bucket shared by all replicas. It holds up to Burst
+// tokens and refills at Rate tokens per second.
+type RateBucket struct {
+	Key   string
+	Rate  float64
+	Burst int
+}
+
+// takeTokensScript takes one token from every bucket in KEYS, or none at all
+// if any of them is empty. ARGV holds the rate and burst of each bucket in
+// turn. It returns 0 on success, or else the number of milliseconds until
+// every bucket has a token again. Buckets are refilled against the Redis
+// clock so that replicas with skewed clocks agree.
+var takeTokensScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local tokens = {}
+local wait = 0
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[2 * i - 1])
+	local burst = tonumber(ARGV[2 * i])
+	local state = redis.call('HMGET', key, 'tokens', 'ts')
+	local available = tonumber(state[1]) or burst
+	local ts = tonumber(state[2]) or now
+	available = math.min(burst, available + math.max(0, now - ts) * rate / 1000)
+	tokens[i] = available
+	if available < 1 then
+		wait = math.max(wait, math.ceil((1 - available) * 1000 / rate))
+	end
+end
+if wait > 0 then
+	return wait
+end
+
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[2 * i - 1])
+	local burst = tonumber(ARGV[2 * i])
+	redis.call('HSET', key, 'tokens', tokens[i] - 1, 'ts', now)
+	redis.call('PEXPIRE', key, math.ceil(burst * 1000 / rate) + 1000)
+end
+return 0
+`)
+
+// TakeTokens takes a token from every bucket at once. It returns zero if the
+// tokens were taken, or else how long to wait until all buckets have one
+// again, without taking any.
+func (c *redisCache) TakeTokens(ctx context.Context, buckets []RateBucket) (time.Duration, error) {
+	if len(buckets) == 0 {
+		return 0, nil
+	}
+
+	keys := make([]string, len(buckets))
+	args := make([]interface{}, 0, 2*len(buckets))
+	for i, bucket := range buckets {
+		if bucket.Rate <= 0 || bucket.Burst < 1 {
+			return 0, fmt.Errorf("invalid rate bucket %s: rate %v, burst %d", bucket.Key, bucket.Rate, bucket.Burst)
+		}
+		keys[i] = fmt.Sprintf("ratelimit:%s", bucket.Key)
+		args = append(args, bucket.Rate, bucket.Burst)
+	}
+
+	wait, err := takeTokensScript.Run(ctx, c.client, keys, args...).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -11,6 +12,31 @@ import (
 type MessageCache interface {
 	StoreMessageID(ctx context.Context, messageID string, sentAt time.Time) error
 	GetMessageSentTime(ctx context.Context, messageID string) (*time.Time, error)
+	ReserveIdempotencyKey(ctx context.Context, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	StoreDeliveryAck(ctx context.Context, deliveryKey string, ack DeliveryAck) error
+	GetDeliveryAck(ctx context.Context, deliveryKey string) (*DeliveryAck, error)
+	TakeTokens(ctx context.Context, buckets []RateBucket) (time.Duration, error)
+}
+
+// DeliveryAck records that the provider accepted a message
+type DeliveryAck struct {
+	MessageID string    `json:"message_id"`
+	Provider  string    `json:"provider,omitempty"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// deliveryAckTTL outlives the lease and retry window of a message so that a
+// resend after a crash still finds the acknowledgement
+const deliveryAckTTL = 7 * 24 * time.Hour
+
+// IdempotencyRecord is the stored outcome of a request made with an idempotency key.
+// A zero StatusCode means the original request is still being processed.
+type IdempotencyRecord struct {
+	RequestHash string          `json:"request_hash"`
+	StatusCode  int             `json:"status_code,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
 }
 
 type redisCache struct {
@@ -31,12 +57,12 @@ func NewRedisCache(host string, port int, password string, db int) MessageCache
 
 func (c *redisCache) StoreMessageID(ctx context.Context, messageID string, sentAt time.Time) error {
 	key := fmt.Sprintf("message:%s", messageID)
-	return c.client.Set(ctx, key, sentAt.Unix(), 24*time.Hour).Err()
+	return c.client.Set(ctx, key, sentAt.Format(time.RFC3339Nano), 24*time.Hour).Err()
 }
 
 func (c *redisCache) GetMessageSentTime(ctx context.Context, messageID string) (*time.Time, error) {
 	key := fmt.Sprintf("message:%s", messageID)
-	val, err := c.client.Get(ctx, key).Int64()
+	val, err := c.client.Get(ctx, key).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil
@@ -44,6 +70,82 @@ func (c *redisCache) GetMessageSentTime(ctx context.Context, messageID string) (
 		return nil, err
 	}
 
-	t := time.Unix(val, 0)
+	t, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		return nil, err
+	}
 	return &t, nil
 }
+
+// ReserveIdempotencyKey claims key for a new request. It returns nil if the key
+// was free, or the record stored by an earlier request with the same key.
+func (c *redisCache) ReserveIdempotencyKey(ctx context.Context, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	redisKey := fmt.Sprintf("idempotency:%s", key)
+	pending, err := json.Marshal(IdempotencyRecord{RequestHash: requestHash})
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := c.client.SetNX(ctx, redisKey, pending, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, nil
+	}
+
+	val, err := c.client.Get(ctx, redisKey).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			// Expired or released between the two calls, try again
+			return c.ReserveIdempotencyKey(ctx, key, requestHash, ttl)
+		}
+		return nil, err
+	}
+
+	var record IdempotencyRecord
+	if err := json.Unmarshal(val, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request that reserved key
+func (c *redisCache) CompleteIdempotencyKey(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error {
+	val, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, fmt.Sprintf("idempotency:%s", key), val, ttl).Err()
+}
+
+// ReleaseIdempotencyKey frees key so the request can be retried
+func (c *redisCache) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return c.client.Del(ctx, fmt.Sprintf("idempotency:%s", key)).Err()
+}
+
+// StoreDeliveryAck remembers that the message with deliveryKey was accepted by the provider
+func (c *redisCache) StoreDeliveryAck(ctx context.Context, deliveryKey string, ack DeliveryAck) error {
+	val, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, fmt.Sprintf("delivery:%s", deliveryKey), val, deliveryAckTTL).Err()
+}
+
+// GetDeliveryAck returns the acknowledgement stored for deliveryKey, or nil if there is none
+func (c *redisCache) GetDeliveryAck(ctx context.Context, deliveryKey string) (*DeliveryAck, error) {
+	val, err := c.client.Get(ctx, fmt.Sprintf("delivery:%s", deliveryKey)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ack DeliveryAck
+	if err := json.Unmarshal(val, &ack); err != nil {
+		return nil, err
+	}
+	return &ack, nil
+}
@@ -20,8 +20,8 @@ func NewPostgresDB(host string, port int, user, password, dbname string) (*gorm.
 		return nil, fmt.Errorf("failed to initialize database, got error %v", err)
 	}
 
-	// Auto-migrate the Message model
-	if err := db.AutoMigrate(&model.Message{}); err != nil {
+	// Auto-migrate the Message models
+	if err := db.AutoMigrate(&model.Message{}, &model.MessageAttempt{}, &model.MessageEvent{}, &model.RecurringSchedule{}, &model.Recipient{}); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
 
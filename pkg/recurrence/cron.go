@@ -0,0 +1,236 @@
+package recurrence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronDays bounds the search for the next day an expression matches, so
+// that expressions such as "0 0 29 2 1-5" that rarely match end the search
+const maxCronDays = 366 * 30
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+
+var weekdayNames = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// cronField is the set of values a field matches, bit n standing for value n
+type cronField uint64
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// cronRule is a parsed five field cron expression
+type cronRule struct {
+	minutes, hours, days, months, weekdays cronField
+	// restricted days and weekdays match either, as in the cron daemon
+	anyDay, anyWeekday bool
+	everyHour          bool
+	// never is set if the months have none of the days of the month
+	never bool
+	loc   *time.Location
+}
+
+// ParseCron parses a standard five field cron expression (minute, hour, day
+// of month, month, day of week) evaluated in loc. Fields accept *, values,
+// ranges, lists and steps, months and weekdays also their three letter
+// English names, and 7 is Sunday like 0. The macros @yearly, @monthly,
+// @weekly, @daily and @hourly are understood as well.
+func ParseCron(expr string, loc *time.Location) (Rule, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: cron expression %q must have 5 fields", ErrInvalidRule, expr)
+	}
+
+	r := &cronRule{loc: loc}
+	var err error
+	if r.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if r.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if r.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if r.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if r.weekdays, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, err
+	}
+	if r.weekdays.has(7) {
+		r.weekdays |= 1
+	}
+	r.anyDay = strings.HasPrefix(fields[2], "*")
+	r.anyWeekday = strings.HasPrefix(fields[4], "*")
+	r.everyHour = r.hours == 1<<24-1
+	r.never = r.anyWeekday && !r.hasMonthDay()
+	return r, nil
+}
+
+// hasMonthDay reports whether one of the months has one of the days of the
+// month in some year
+func (r *cronRule) hasMonthDay() bool {
+	for m := 1; m <= 12; m++ {
+		if !r.months.has(m) {
+			continue
+		}
+		// 2000 is a leap year, so every month has all the days it can have
+		for d := 1; d <= daysIn(2000, time.Month(m)); d++ {
+			if r.days.has(d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseCronField parses one field whose values lie between min and max.
+// names, if given, are the names of the values from min on.
+func parseCronField(field string, min, max int, names []string) (cronField, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < min || v > max {
+			return 0, fmt.Errorf("%w: %q is not a value between %d and %d", ErrInvalidRule, s, min, max)
+		}
+		return v, nil
+	}
+
+	var set cronField
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			s, err := strconv.Atoi(item[i+1:])
+			if err != nil || s < 1 {
+				return 0, fmt.Errorf("%w: invalid step in %q", ErrInvalidRule, item)
+			}
+			rng, step = item[:i], s
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			parts := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = value(parts[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = value(parts[1]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%w: range %q is reversed", ErrInvalidRule, rng)
+			}
+		default:
+			v, err := value(rng)
+			if err != nil {
+				return 0, err
+			}
+			// A single value with a step runs from the value to the maximum
+			lo, hi = v, v
+			if step > 1 {
+				hi = max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// matchDay reports whether the expression runs on the calendar date day
+func (r *cronRule) matchDay(day time.Time) bool {
+	if !r.months.has(int(day.Month())) {
+		return false
+	}
+	dayMatch := r.days.has(day.Day())
+	weekdayMatch := r.weekdays.has(int(day.Weekday()))
+	if r.anyDay || r.anyWeekday {
+		return dayMatch && weekdayMatch
+	}
+	return dayMatch || weekdayMatch
+}
+
+func (r *cronRule) Next(t time.Time) (time.Time, bool) {
+	if r.never {
+		return time.Time{}, false
+	}
+	day := civilDate(t.In(r.loc))
+	// An occurrence on the day before may have been moved past midnight
+	day = day.AddDate(0, 0, -1)
+	for i := 0; i < maxCronDays; i++ {
+		if r.matchDay(day) {
+			if next, ok := r.nextOnDay(day, t); ok {
+				return next, true
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}, false
+}
+
+// nextOnDay returns the first occurrence on the calendar date day after t
+func (r *cronRule) nextOnDay(day, t time.Time) (time.Time, bool) {
+	y, m, d := day.Date()
+	if r.everyHour {
+		// Follow the clock minute by minute through the day as it was lived
+		start := localTime(y, m, d, 0, 0, 0, r.loc)
+		end := localTime(y, m, d+1, 0, 0, 0, r.loc)
+		if from := t.Truncate(time.Minute).Add(time.Minute); from.After(start) {
+			start = from
+		}
+		for at := start; at.Before(end); at = at.Add(time.Minute) {
+			local := at.In(r.loc)
+			if r.hours.has(local.Hour()) && r.minutes.has(local.Minute()) {
+				return local, true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	// Moved times can overtake later ones, so take the earliest of the day
+	var next time.Time
+	found := false
+	for h := 0; h < 24; h++ {
+		if !r.hours.has(h) {
+			continue
+		}
+		for min := 0; min < 60; min++ {
+			if !r.minutes.has(min) {
+				continue
+			}
+			at := localTime(y, m, d, h, min, 0, r.loc)
+			if at.After(t) && (!found || at.Before(next)) {
+				next, found = at, true
+			}
+		}
+	}
+	return next, found
+}
@@ -0,0 +1,71 @@
+// Package recurrence computes the occurrences of recurring schedules given as
+// cron expressions or iCalendar recurrence rules (RFC 5545 RRULE).
+//
+// Occurrences are wall clock times in a time zone, so a schedule for 09:00
+// stays at 09:00 local time across daylight saving transitions. Wall clock
+// times that a transition makes ambiguous or skips are resolved as RFC 5545
+// prescribes: an ambiguous time refers to its first occurrence, and a skipped
+// time is read with the UTC offset before the gap, which moves it forward by
+// the length of the gap (02:30 becomes 03:30 when clocks jump from 02:00 to
+// 03:00). A schedule therefore neither fires twice nor misses a day.
+//
+// Cron expressions that run every hour follow the clock instead, like the
+// classic cron daemon: they fire in both passes of an hour that repeats and
+// not at all in an hour that is skipped.
+package recurrence
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+// Rule yields the occurrences of a recurring schedule
+type Rule interface {
+	// Next returns the first occurrence strictly after t, or false if the
+	// rule has no further occurrences
+	Next(t time.Time) (time.Time, bool)
+}
+
+// WallClock returns the instant at which the clock in loc reads the date and
+// time of day of wall, resolving ambiguous and skipped times as described in
+// the package documentation. The location of wall is ignored.
+func WallClock(wall time.Time, loc *time.Location) time.Time {
+	return localTime(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), loc)
+}
+
+// localTime returns the instant the wall clock time reads in loc, resolving
+// ambiguous and skipped times as described in the package documentation
+func localTime(year int, month time.Month, day, hour, min, sec int, loc *time.Location) time.Time {
+	wall := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+
+	// Real zones change their offset at most once within a day either side
+	_, before := wall.Add(-24 * time.Hour).In(loc).Zone()
+	_, after := wall.Add(24 * time.Hour).In(loc).Zone()
+
+	var first time.Time
+	found := false
+	for _, offset := range []int{before, after} {
+		t := wall.Add(-time.Duration(offset) * time.Second)
+		if _, actual := t.In(loc).Zone(); actual == offset && (!found || t.Before(first)) {
+			first, found = t, true
+		}
+	}
+	if !found {
+		first = wall.Add(-time.Duration(before) * time.Second)
+	}
+	return first.In(loc)
+}
+
+// civilDate returns the calendar date of t as midnight UTC, so that dates can
+// be stepped through without daylight saving getting in the way
+func civilDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// daysIn returns the number of days of month in year
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
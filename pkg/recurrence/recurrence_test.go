@@ -0,0 +1,345 @@
+package recurrence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation(%q) error = %v", name, err)
+	}
+	return loc
+}
+
+// occurrences returns the first n occurrences of rule after from, in UTC
+func occurrences(rule Rule, from time.Time, n int) []string {
+	var out []string
+	for i := 0; i < n; i++ {
+		next, ok := rule.Next(from)
+		if !ok {
+			break
+		}
+		out = append(out, next.UTC().Format(time.RFC3339))
+		from = next
+	}
+	return out
+}
+
+func assertOccurrences(t *testing.T, got, expected []string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("Expected occurrences %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected occurrences %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestWallClock(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	tests := []struct {
+		name     string
+		wall     time.Time
+		expected string
+	}{
+		{name: "winter time", wall: time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC), expected: "2024-01-15T08:00:00Z"},
+		{name: "summer time", wall: time.Date(2024, 7, 15, 9, 0, 0, 0, time.UTC), expected: "2024-07-15T07:00:00Z"},
+		{name: "skipped time is moved by the gap", wall: time.Date(2024, 3, 31, 2, 30, 0, 0, time.UTC), expected: "2024-03-31T01:30:00Z"},
+		{name: "repeated time is the first one", wall: time.Date(2024, 10, 27, 2, 30, 0, 0, time.UTC), expected: "2024-10-27T00:30:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WallClock(tt.wall, berlin).UTC().Format(time.RFC3339); got != tt.expected {
+				t.Errorf("WallClock() = %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCron(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	newYork := mustLocation(t, "America/New_York")
+
+	tests := []struct {
+		name     string
+		expr     string
+		loc      *time.Location
+		from     string
+		expected []string
+	}{
+		{
+			name:     "weekdays at nine",
+			expr:     "0 9 * * MON-FRI",
+			from:     "2024-04-26T09:00:00Z",
+			expected: []string{"2024-04-29T09:00:00Z", "2024-04-30T09:00:00Z"},
+		},
+		{
+			name:     "steps and lists",
+			expr:     "*/20 8,17 * * *",
+			from:     "2024-04-26T08:30:00Z",
+			expected: []string{"2024-04-26T08:40:00Z", "2024-04-26T17:00:00Z", "2024-04-26T17:20:00Z"},
+		},
+		{
+			name:     "day of month or day of week",
+			expr:     "0 0 1 * SUN",
+			from:     "2024-04-26T00:00:00Z",
+			expected: []string{"2024-04-28T00:00:00Z", "2024-05-01T00:00:00Z", "2024-05-05T00:00:00Z"},
+		},
+		{
+			name:     "macro",
+			expr:     "@monthly",
+			from:     "2024-04-26T00:00:00Z",
+			expected: []string{"2024-05-01T00:00:00Z", "2024-06-01T00:00:00Z"},
+		},
+		{
+			name:     "leap day",
+			expr:     "0 12 29 2 *",
+			from:     "2024-03-01T00:00:00Z",
+			expected: []string{"2028-02-29T12:00:00Z"},
+		},
+		{
+			name:     "local time is kept across daylight saving",
+			expr:     "0 9 * * *",
+			loc:      berlin,
+			from:     "2024-03-30T00:00:00Z",
+			expected: []string{"2024-03-30T08:00:00Z", "2024-03-31T07:00:00Z"},
+		},
+		{
+			name:     "time skipped in spring runs after the gap",
+			expr:     "30 2 * * *",
+			loc:      berlin,
+			from:     "2024-03-30T00:00:00Z",
+			expected: []string{"2024-03-30T01:30:00Z", "2024-03-31T01:30:00Z", "2024-04-01T00:30:00Z"},
+		},
+		{
+			name:     "time repeated in autumn runs once",
+			expr:     "30 2 * * *",
+			loc:      berlin,
+			from:     "2024-10-26T12:00:00Z",
+			expected: []string{"2024-10-27T00:30:00Z", "2024-10-28T01:30:00Z"},
+		},
+		{
+			name:     "hourly schedules follow the clock through a repeated hour",
+			expr:     "0,30 * * * *",
+			loc:      newYork,
+			from:     "2024-11-03T05:00:00Z",
+			expected: []string{"2024-11-03T05:30:00Z", "2024-11-03T06:00:00Z", "2024-11-03T06:30:00Z", "2024-11-03T07:00:00Z"},
+		},
+		{
+			name:     "hourly schedules skip a missing hour",
+			expr:     "30 * * * *",
+			loc:      newYork,
+			from:     "2024-03-10T06:00:00Z",
+			expected: []string{"2024-03-10T06:30:00Z", "2024-03-10T07:30:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseCron(tt.expr, tt.loc)
+			if err != nil {
+				t.Fatalf("ParseCron() error = %v", err)
+			}
+			from, _ := time.Parse(time.RFC3339, tt.from)
+			assertOccurrences(t, occurrences(rule, from, len(tt.expected)), tt.expected)
+		})
+	}
+}
+
+func TestCron_NeverMatches(t *testing.T) {
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		rule, err := ParseCron(expr, time.UTC)
+		if err != nil {
+			t.Fatalf("ParseCron(%q) error = %v", expr, err)
+		}
+		if next, ok := rule.Next(time.Now()); ok {
+			t.Errorf("Expected no occurrence of %q, got %s", expr, next)
+		}
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "* * * FOO *"} {
+		if _, err := ParseCron(expr, time.UTC); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("ParseCron(%q) error = %v, expected ErrInvalidRule", expr, err)
+		}
+	}
+}
+
+func TestRRule(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+
+	tests := []struct {
+		name     string
+		rule     string
+		start    time.Time
+		n        int
+		expected []string
+	}{
+		{
+			name:     "daily keeps local time across daylight saving",
+			rule:     "FREQ=DAILY;COUNT=3",
+			start:    time.Date(2024, 3, 30, 9, 0, 0, 0, berlin),
+			n:        5,
+			expected: []string{"2024-03-30T08:00:00Z", "2024-03-31T07:00:00Z", "2024-04-01T07:00:00Z"},
+		},
+		{
+			name:     "time skipped in spring is moved by the gap",
+			rule:     "FREQ=DAILY;BYHOUR=2;BYMINUTE=30",
+			start:    time.Date(2024, 3, 30, 0, 0, 0, 0, berlin),
+			n:        3,
+			expected: []string{"2024-03-30T01:30:00Z", "2024-03-31T01:30:00Z", "2024-04-01T00:30:00Z"},
+		},
+		{
+			name:     "time repeated in autumn is the first one",
+			rule:     "FREQ=DAILY;BYHOUR=2;BYMINUTE=30",
+			start:    time.Date(2024, 10, 27, 0, 0, 0, 0, berlin),
+			n:        2,
+			expected: []string{"2024-10-27T00:30:00Z", "2024-10-28T01:30:00Z"},
+		},
+		{
+			name:     "every other week on two days",
+			rule:     "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+			start:    time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC),
+			n:        4,
+			expected: []string{"2024-04-01T09:00:00Z", "2024-04-04T09:00:00Z", "2024-04-15T09:00:00Z", "2024-04-18T09:00:00Z"},
+		},
+		{
+			name:     "last friday of the month",
+			rule:     "FREQ=MONTHLY;BYDAY=-1FR;BYHOUR=16;BYMINUTE=0",
+			start:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			n:        3,
+			expected: []string{"2024-01-26T16:00:00Z", "2024-02-23T16:00:00Z", "2024-03-29T16:00:00Z"},
+		},
+		{
+			name:     "months without the day are skipped",
+			rule:     "FREQ=MONTHLY",
+			start:    time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC),
+			n:        3,
+			expected: []string{"2024-01-31T10:00:00Z", "2024-03-31T10:00:00Z", "2024-05-31T10:00:00Z"},
+		},
+		{
+			name:     "last day of the month",
+			rule:     "FREQ=MONTHLY;BYMONTHDAY=-1",
+			start:    time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			n:        2,
+			expected: []string{"2024-01-31T10:00:00Z", "2024-02-29T10:00:00Z"},
+		},
+		{
+			name:     "yearly on the first monday of september",
+			rule:     "FREQ=YEARLY;BYMONTH=9;BYDAY=1MO",
+			start:    time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC),
+			n:        2,
+			expected: []string{"2024-09-02T08:00:00Z", "2025-09-01T08:00:00Z"},
+		},
+		{
+			name:     "until includes the whole day",
+			rule:     "FREQ=DAILY;UNTIL=20240403",
+			start:    time.Date(2024, 4, 1, 23, 0, 0, 0, berlin),
+			n:        5,
+			expected: []string{"2024-04-01T21:00:00Z", "2024-04-02T21:00:00Z", "2024-04-03T21:00:00Z"},
+		},
+		{
+			name:     "start that does not match is not an occurrence",
+			rule:     "RRULE:FREQ=WEEKLY;BYDAY=FR",
+			start:    time.Date(2024, 4, 24, 12, 0, 0, 0, time.UTC),
+			n:        2,
+			expected: []string{"2024-04-26T12:00:00Z", "2024-05-03T12:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRRule(tt.rule, tt.start)
+			if err != nil {
+				t.Fatalf("ParseRRule() error = %v", err)
+			}
+			assertOccurrences(t, occurrences(rule, tt.start.Add(-time.Nanosecond), tt.n), tt.expected)
+		})
+	}
+}
+
+func TestRRule_CountFromStart(t *testing.T) {
+	start := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	rule, err := ParseRRule("FREQ=DAILY;COUNT=5", start)
+	if err != nil {
+		t.Fatalf("ParseRRule() error = %v", err)
+	}
+	// Occurrences before the lookup still count towards COUNT
+	assertOccurrences(t, occurrences(rule, start.AddDate(0, 0, 2), 5), []string{"2024-04-04T09:00:00Z", "2024-04-05T09:00:00Z"})
+}
+
+func TestRRule_NextFromAnyTime(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	start := time.Date(2024, 1, 31, 9, 0, 0, 0, berlin)
+
+	for _, spec := range []string{
+		"FREQ=DAILY;INTERVAL=3;BYHOUR=2;BYMINUTE=30",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;WKST=SU",
+		"FREQ=MONTHLY;INTERVAL=5",
+		"FREQ=MONTHLY;BYDAY=-1FR;COUNT=40",
+		"FREQ=YEARLY;INTERVAL=2;BYMONTH=2;BYMONTHDAY=29",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			walked, err := ParseRRule(spec, start)
+			if err != nil {
+				t.Fatalf("ParseRRule() error = %v", err)
+			}
+			expected := occurrences(walked, start.Add(-time.Nanosecond), 30)
+
+			// Occurrences looked up from anywhere agree with those walked
+			// through from the start, whether or not the rule was used before
+			for i, at := range expected {
+				at, _ := time.Parse(time.RFC3339, at)
+				fresh, _ := ParseRRule(spec, start)
+				for _, rule := range []Rule{fresh, walked} {
+					next, ok := rule.Next(at.Add(-time.Second))
+					if !ok || next.UTC().Format(time.RFC3339) != expected[i] {
+						t.Fatalf("Next(%s) = %s, %v, expected %s", at.Add(-time.Second), next.UTC(), ok, expected[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRRule_NeverMatches(t *testing.T) {
+	start := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	for _, spec := range []string{"FREQ=MONTHLY;BYMONTH=2;BYMONTHDAY=31", "FREQ=MONTHLY;BYMONTHDAY=1;BYDAY=5MO"} {
+		rule, err := ParseRRule(spec, start)
+		if err != nil {
+			t.Fatalf("ParseRRule(%q) error = %v", spec, err)
+		}
+		if next, ok := rule.Next(start); ok {
+			t.Errorf("Expected no occurrence of %q, got %s", spec, next)
+		}
+	}
+}
+
+func TestParseRRule_Invalid(t *testing.T) {
+	start := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	for _, rule := range []string{
+		"",
+		"BYDAY=MO",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20240501",
+		"FREQ=DAILY;BYSETPOS=1",
+		"FREQ=DAILY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=DAILY;UNTIL=tomorrow",
+	} {
+		if _, err := ParseRRule(rule, start); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("ParseRRule(%q) error = %v, expected ErrInvalidRule", rule, err)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence frequencies
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+// maxRRuleYears bounds the search for the next occurrence, so that rules such
+// as "FREQ=MONTHLY;BYMONTHDAY=1;BYDAY=5MO" that never match end the search
+const maxRRuleYears = 100
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// byDay is a BYDAY entry: a weekday, optionally the nth (from the end if
+// negative) of its month or year
+type byDay struct {
+	weekday time.Weekday
+	n       int
+}
+
+// rrule is a parsed recurrence rule anchored at its start. It remembers where
+// the last search of a rule with COUNT ended, so it is not safe for concurrent
+// use.
+type rrule struct {
+	freq       string
+	interval   int
+	count      int
+	until      *time.Time
+	byMonth    []int
+	byMonthDay []int
+	byDay      []byDay
+	byHour     []int
+	byMinute   []int
+	weekStart  time.Weekday
+	start      time.Time
+	// never is set if BYMONTHDAY names no day of the months the rule runs in
+	never bool
+	// mark is the first date of the period the last occurrence found fell in
+	// and markCount the number of occurrences before it
+	mark      time.Time
+	markCount int
+}
+
+// ParseRRule parses an RFC 5545 recurrence rule such as
+// "FREQ=WEEKLY;BYDAY=MO,WE;BYHOUR=9" that starts at start, in the location of
+// start. Like DTSTART, start sets the time of day and the day that BY* parts
+// leave open; it is the first occurrence only if it matches the rule.
+//
+// The frequencies DAILY, WEEKLY, MONTHLY and YEARLY are supported with
+// INTERVAL, COUNT, UNTIL, BYMONTH, BYMONTHDAY, BYDAY, BYHOUR, BYMINUTE and
+// WKST. A floating or date-only UNTIL is read in the location of start.
+func ParseRRule(rule string, start time.Time) (Rule, error) {
+	r := &rrule{interval: 1, weekStart: time.Monday, start: start.Truncate(time.Second)}
+	spec := strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	if spec == "" {
+		return nil, fmt.Errorf("%w: rule is empty", ErrInvalidRule)
+	}
+
+	for _, part := range strings.Split(spec, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("%w: malformed part %q", ErrInvalidRule, part)
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			r.freq = strings.ToUpper(value)
+			switch r.freq {
+			case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+			default:
+				return nil, fmt.Errorf("%w: unsupported frequency %q", ErrInvalidRule, value)
+			}
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(value)
+			if err == nil && r.interval < 1 {
+				err = fmt.Errorf("interval must be positive")
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(value)
+			if err == nil && r.count < 1 {
+				err = fmt.Errorf("count must be positive")
+			}
+		case "UNTIL":
+			var until time.Time
+			until, err = parseUntil(value, start.Location())
+			r.until = &until
+		case "BYMONTH":
+			r.byMonth, err = parseInts(value, 1, 12, false)
+		case "BYMONTHDAY":
+			r.byMonthDay, err = parseInts(value, 1, 31, true)
+		case "BYHOUR":
+			r.byHour, err = parseInts(value, 0, 23, false)
+		case "BYMINUTE":
+			r.byMinute, err = parseInts(value, 0, 59, false)
+		case "BYDAY":
+			r.byDay, err = parseByDay(value)
+		case "WKST":
+			wd, ok := rruleWeekdays[strings.ToUpper(value)]
+			if !ok {
+				err = fmt.Errorf("unknown weekday %q", value)
+			}
+			r.weekStart = wd
+		default:
+			return nil, fmt.Errorf("%w: unsupported part %s", ErrInvalidRule, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidRule, name, err)
+		}
+	}
+
+	switch {
+	case r.freq == "":
+		return nil, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	case r.count > 0 && r.until != nil:
+		return nil, fmt.Errorf("%w: COUNT and UNTIL cannot be combined", ErrInvalidRule)
+	case r.freq == FreqWeekly && len(r.byMonthDay) > 0:
+		return nil, fmt.Errorf("%w: BYMONTHDAY cannot be used with WEEKLY", ErrInvalidRule)
+	}
+	for _, d := range r.byDay {
+		if d.n != 0 && r.freq != FreqMonthly && r.freq != FreqYearly {
+			return nil, fmt.Errorf("%w: numbered BYDAY needs MONTHLY or YEARLY", ErrInvalidRule)
+		}
+	}
+	r.never = !r.hasMonthDay()
+	return r, nil
+}
+
+// hasMonthDay reports whether a month the rule runs in has a day BYMONTHDAY
+// names in some year
+func (r *rrule) hasMonthDay() bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	for m := 1; m <= 12; m++ {
+		if len(r.byMonth) > 0 && !containsInt(r.byMonth, m) {
+			continue
+		}
+		// 2000 is a leap year, so every month has all the days it can have
+		days := daysIn(2000, time.Month(m))
+		for _, d := range r.byMonthDay {
+			if d <= days && -d <= days {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseUntil parses an UTC, floating or date-only UNTIL value
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return localTime(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), loc), nil
+	}
+	t, err := time.ParseInLocation("20060102", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	// A date includes all of its day
+	return localTime(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, loc).Add(-time.Second), nil
+}
+
+// parseInts parses a list of values between min and max, or between -max and
+// -min as well if negative is set
+func parseInts(value string, min, max int, negative bool) ([]int, error) {
+	var values []int
+	for _, s := range strings.Split(value, ",") {
+		v, err := strconv.Atoi(s)
+		abs := v
+		if negative && v < 0 {
+			abs = -v
+		}
+		if err != nil || abs < min || abs > max || (v < 0 && !negative) {
+			return nil, fmt.Errorf("invalid value %q", s)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// parseByDay parses a BYDAY list such as "MO,WE" or "1MO,-1FR"
+func parseByDay(value string) ([]byDay, error) {
+	var days []byDay
+	for _, s := range strings.Split(strings.ToUpper(value), ",") {
+		if len(s) < 2 {
+			return nil, fmt.Errorf("invalid weekday %q", s)
+		}
+		wd, ok := rruleWeekdays[s[len(s)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", s)
+		}
+		d := byDay{weekday: wd}
+		if prefix := s[:len(s)-2]; prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -53 || n > 53 {
+				return nil, fmt.Errorf("invalid weekday %q", s)
+			}
+			d.n = n
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+func (r *rrule) Next(t time.Time) (time.Time, bool) {
+	if r.never {
+		return time.Time{}, false
+	}
+	limit := r.start.Year() + maxRRuleYears
+	if t.Year()+maxRRuleYears > limit {
+		limit = t.Year() + maxRRuleYears
+	}
+
+	// COUNT counts from the start, so the periods before t have to be walked
+	// through, except for those before where the last search ended
+	period, count := r.seek(t), 0
+	if r.count > 0 {
+		if !r.mark.IsZero() && !r.mark.After(period) {
+			period, count = r.mark, r.markCount
+		} else {
+			period = r.firstPeriod()
+		}
+	}
+	for ; period.Year() <= limit; period = r.nextPeriod(period) {
+		before := count
+		for _, at := range r.occurrences(period) {
+			if at.Before(r.start) {
+				continue
+			}
+			if r.until != nil && at.After(*r.until) {
+				return time.Time{}, false
+			}
+			count++
+			if r.count > 0 && count > r.count {
+				return time.Time{}, false
+			}
+			if at.After(t) {
+				if r.count > 0 {
+					r.mark, r.markCount = period, before
+				}
+				return at, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// seek returns the first date of the period that t falls in, or of the first
+// period if t lies before it
+func (r *rrule) seek(t time.Time) time.Time {
+	first := r.firstPeriod()
+	// An occurrence on the day before may have been moved past midnight
+	day := civilDate(t.In(r.start.Location())).AddDate(0, 0, -1)
+	if !day.After(first) {
+		return first
+	}
+
+	var periods int
+	switch r.freq {
+	case FreqWeekly:
+		periods = int(day.Sub(first).Hours()/24) / 7
+	case FreqMonthly:
+		periods = (day.Year()-first.Year())*12 + int(day.Month()) - int(first.Month())
+	case FreqYearly:
+		periods = day.Year() - first.Year()
+	default:
+		periods = int(day.Sub(first).Hours() / 24)
+	}
+	return r.advance(first, periods/r.interval)
+}
+
+// firstPeriod returns the first date of the period the start falls in
+func (r *rrule) firstPeriod() time.Time {
+	day := civilDate(r.start)
+	switch r.freq {
+	case FreqWeekly:
+		back := (int(day.Weekday()) - int(r.weekStart) + 7) % 7
+		return day.AddDate(0, 0, -back)
+	case FreqMonthly:
+		return day.AddDate(0, 0, 1-day.Day())
+	case FreqYearly:
+		return time.Date(day.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// nextPeriod returns the first date of the period interval periods on
+func (r *rrule) nextPeriod(period time.Time) time.Time {
+	return r.advance(period, 1)
+}
+
+// advance returns the first date of the period n times interval periods on
+func (r *rrule) advance(period time.Time, n int) time.Time {
+	switch r.freq {
+	case FreqWeekly:
+		return period.AddDate(0, 0, 7*r.interval*n)
+	case FreqMonthly:
+		return period.AddDate(0, r.interval*n, 0)
+	case FreqYearly:
+		return period.AddDate(r.interval*n, 0, 0)
+	default:
+		return period.AddDate(0, 0, r.interval*n)
+	}
+}
+
+// occurrences returns the occurrences of the period starting at period in
+// chronological order
+func (r *rrule) occurrences(period time.Time) []time.Time {
+	var dates []time.Time
+	switch r.freq {
+	case FreqDaily:
+		if r.matchMonth(period) && r.matchMonthDay(period) && r.matchWeekday(period) {
+			dates = []time.Time{period}
+		}
+	case FreqWeekly:
+		for i := 0; i < 7; i++ {
+			day := period.AddDate(0, 0, i)
+			if !r.matchMonth(day) {
+				continue
+			}
+			if len(r.byDay) == 0 && day.Weekday() != r.start.Weekday() {
+				continue
+			}
+			if r.matchWeekday(day) {
+				dates = append(dates, day)
+			}
+		}
+	case FreqMonthly:
+		if r.matchMonth(period) {
+			dates = r.monthDates(period.Year(), period.Month())
+		}
+	case FreqYearly:
+		dates = r.yearDates(period.Year())
+	}
+
+	hours, minutes := r.byHour, r.byMinute
+	if len(hours) == 0 {
+		hours = []int{r.start.Hour()}
+	}
+	if len(minutes) == 0 {
+		minutes = []int{r.start.Minute()}
+	}
+
+	var times []time.Time
+	for _, date := range dates {
+		for _, h := range hours {
+			for _, m := range minutes {
+				times = append(times, localTime(date.Year(), date.Month(), date.Day(), h, m, r.start.Second(), r.start.Location()))
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	// Times moved by a daylight saving gap may land on another one
+	unique := times[:0]
+	for i, at := range times {
+		if i == 0 || !at.Equal(times[i-1]) {
+			unique = append(unique, at)
+		}
+	}
+	return unique
+}
+
+// monthDates returns the dates of a month selected by BYMONTHDAY and BYDAY,
+// or the day of the month of the start if neither is given
+func (r *rrule) monthDates(year int, month time.Month) []time.Time {
+	days := daysIn(year, month)
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	var dates []time.Time
+	if len(r.byMonthDay) == 0 && len(r.byDay) == 0 {
+		if r.start.Day() <= days {
+			dates = append(dates, first.AddDate(0, 0, r.start.Day()-1))
+		}
+		return dates
+	}
+	for i := 0; i < days; i++ {
+		day := first.AddDate(0, 0, i)
+		if r.matchMonthDay(day) && r.matchNthWeekday(day, first, days) {
+			dates = append(dates, day)
+		}
+	}
+	return dates
+}
+
+// yearDates returns the dates of a year selected by BYMONTH, BYMONTHDAY and
+// BYDAY, with numbered weekdays counted within the year unless BYMONTH is set
+func (r *rrule) yearDates(year int) []time.Time {
+	if len(r.byMonthDay) == 0 && len(r.byDay) == 0 {
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []int{int(r.start.Month())}
+		}
+		var dates []time.Time
+		for m := 1; m <= 12; m++ {
+			if containsInt(months, m) && r.start.Day() <= daysIn(year, time.Month(m)) {
+				dates = append(dates, time.Date(year, time.Month(m), r.start.Day(), 0, 0, 0, 0, time.UTC))
+			}
+		}
+		return dates
+	}
+
+	if len(r.byMonth) == 0 && len(r.byMonthDay) == 0 {
+		first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		days := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+		var dates []time.Time
+		for i := 0; i < days; i++ {
+			day := first.AddDate(0, 0, i)
+			if r.matchNthWeekday(day, first, days) {
+				dates = append(dates, day)
+			}
+		}
+		return dates
+	}
+
+	var dates []time.Time
+	for m := 1; m <= 12; m++ {
+		if len(r.byMonth) == 0 || containsInt(r.byMonth, m) {
+			dates = append(dates, r.monthDates(year, time.Month(m))...)
+		}
+	}
+	return dates
+}
+
+func (r *rrule) matchMonth(day time.Time) bool {
+	return len(r.byMonth) == 0 || containsInt(r.byMonth, int(day.Month()))
+}
+
+func (r *rrule) matchMonthDay(day time.Time) bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	days := daysIn(day.Year(), day.Month())
+	for _, d := range r.byMonthDay {
+		if d == day.Day() || (d < 0 && days+d+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWeekday matches BYDAY without regard to numbers
+func (r *rrule) matchWeekday(day time.Time) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, d := range r.byDay {
+		if d.weekday == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchNthWeekday matches BYDAY with numbers counted within the span of days
+// days that begins at first
+func (r *rrule) matchNthWeekday(day, first time.Time, days int) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	index := int(day.Sub(first).Hours() / 24)
+	for _, d := range r.byDay {
+		if d.weekday != day.Weekday() {
+			continue
+		}
+		switch {
+		case d.n == 0:
+			return true
+		case d.n > 0 && index/7+1 == d.n:
+			return true
+		case d.n < 0 && (days-1-index)/7+1 == -d.n:
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package signature
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifier_Verify(t *testing.T) {
+	now := time.Unix(1714125600, 0)
+	body := []byte(`{"content":"Test message","to":"test@example.com"}`)
+
+	signed := func(keyID, secret string, at time.Time) http.Header {
+		header := http.Header{}
+		NewSigner(keyID, secret).Sign(header, body, at)
+		return header
+	}
+
+	tests := []struct {
+		name          string
+		header        http.Header
+		body          []byte
+		expectedError error
+	}{
+		{name: "current key", header: signed("2024-05", "new-secret", now)},
+		{name: "previous key during rotation", header: signed("2024-04", "old-secret", now)},
+		{name: "no key id", header: signed("", "old-secret", now)},
+		{name: "tampered body", header: signed("2024-05", "new-secret", now), body: []byte(`{"content":"Other"}`), expectedError: ErrInvalidSignature},
+		{name: "wrong secret", header: signed("2024-05", "old-secret", now), expectedError: ErrInvalidSignature},
+		{name: "unknown key", header: signed("2023-12", "retired-secret", now), expectedError: ErrUnknownKey},
+		{name: "replayed after tolerance", header: signed("2024-05", "new-secret", now.Add(-6*time.Minute)), expectedError: ErrStaleTimestamp},
+		{name: "timestamp in the future", header: signed("2024-05", "new-secret", now.Add(6*time.Minute)), expectedError: ErrStaleTimestamp},
+		{name: "unsigned", header: http.Header{}, expectedError: ErrMissingSignature},
+	}
+
+	verifier := NewVerifier(map[string]string{"2024-04": "old-secret", "2024-05": "new-secret"}, 0)
+	verifier.now = func() time.Time { return now }
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := body
+			if tt.body != nil {
+				b = tt.body
+			}
+			err := verifier.Verify(tt.header, b)
+			if tt.expectedError == nil && err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+			if tt.expectedError != nil && !errors.Is(err, tt.expectedError) {
+				t.Errorf("Expected error %v, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestSigner_Sign(t *testing.T) {
+	now := time.Unix(1714125600, 0)
+	header := http.Header{}
+	NewSigner("2024-05", "secret").Sign(header, []byte("{}"), now)
+
+	if header.Get(TimestampHeader) != strconv.FormatInt(now.Unix(), 10) {
+		t.Errorf("Unexpected timestamp header %q", header.Get(TimestampHeader))
+	}
+	if header.Get(KeyIDHeader) != "2024-05" {
+		t.Errorf("Unexpected key ID header %q", header.Get(KeyIDHeader))
+	}
+	if header.Get(SignatureHeader) != Compute([]byte("secret"), now.Unix(), []byte("{}")) {
+		t.Errorf("Unexpected signature header %q", header.Get(SignatureHeader))
+	}
+}